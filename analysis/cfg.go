@@ -0,0 +1,345 @@
+// Package analysis builds control flow graphs of LC-3 programs and runs data flow analyses on them:
+// register liveness, reaching definitions and reads of uninitialised registers.
+//
+// The graph contains instructions reachable from the entries. BR, JMP, JSR, JSRR, RTI and HALT end
+// basic blocks, other traps are served like ordinary instructions. A call has an edge to the subroutine
+// and an edge to the instruction after it, data flow analyses follow only the latter and treat
+// subroutines as separate procedures.
+package analysis
+
+import (
+	"sort"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// EdgeKind tells how control gets from one block to another
+type EdgeKind int
+
+const (
+	// execution continues with the next instruction
+	EdgeFall EdgeKind = iota
+	// BR is taken
+	EdgeBranch
+	// JSR calls a subroutine
+	EdgeCall
+	// execution continues after the called subroutine returns
+	EdgeReturn
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeFall:
+		return "fall"
+	case EdgeBranch:
+		return "branch"
+	case EdgeCall:
+		return "call"
+	case EdgeReturn:
+		return "return"
+	}
+	return "unknown"
+}
+
+// ExitKind tells how the last instruction of a block leaves the graph
+type ExitKind int
+
+const (
+	// the block continues only by its edges
+	ExitNone ExitKind = iota
+	// RET returns from a subroutine
+	ExitReturn
+	// JMP to a register other than R7 or JSRR to an unknown subroutine
+	ExitIndirect
+	// HALT stops the machine
+	ExitHalt
+	// RTI or the reserved opcode
+	ExitInvalid
+)
+
+func (k ExitKind) String() string {
+	switch k {
+	case ExitNone:
+		return "none"
+	case ExitReturn:
+		return "return"
+	case ExitIndirect:
+		return "indirect"
+	case ExitHalt:
+		return "halt"
+	case ExitInvalid:
+		return "invalid"
+	}
+	return "unknown"
+}
+
+type Edge struct {
+	// first address of the block. it can be outside of the analysed memory
+	To   lc3.Word
+	Kind EdgeKind
+}
+
+// Block is a basic block: a run of instructions executed one after another
+type Block struct {
+	Start lc3.Word
+	Words []lc3.Word
+	Succs []Edge
+	// first addresses of predecessor blocks
+	Preds []lc3.Word
+	Exit  ExitKind
+}
+
+// End returns address of the last instruction
+func (b *Block) End() lc3.Word {
+	return b.Start + lc3.Word(len(b.Words)) - 1
+}
+
+// Graph is a control flow graph of memory words reachable from the entries
+type Graph struct {
+	// sorted by start address
+	Blocks []*Block
+	// addresses where execution starts
+	Entries []lc3.Word
+	// entries of subroutines called by JSR, sorted
+	Subroutines []lc3.Word
+	// labels of the program, sorted by address
+	Symbols []lc3.Symbol
+
+	words map[lc3.Word]lc3.Word
+	// words that are code in the source. all words of memory ranges
+	code  map[lc3.Word]bool
+	block map[lc3.Word]*Block
+
+	liveIn  map[*Block]RegSet
+	liveOut map[*Block]RegSet
+	// definitions reaching the start of blocks
+	reachIn map[*Block]defSet
+	defs    []Def
+	// indexes of defs made by instructions and of every register
+	instructionDefs map[lc3.Word][]int
+	registerDefs    [8][]int
+}
+
+// FromProgram builds graph of the program starting at its entry
+func FromProgram(program *lc3.Program) *Graph {
+	code := make(map[lc3.Word]bool)
+	for _, line := range program.Lines {
+		for i := lc3.Word(0); line.Code && i < line.Size; i++ {
+			code[line.Address+i] = true
+		}
+	}
+	return build(program.Sections, []lc3.Word{program.Entry}, program.Symbols, code)
+}
+
+// FromMemory builds graph of memory range [from, to] of m. the range start is the entry if no entries are given
+func FromMemory(m *lc3.VM, from, to lc3.Word, entries ...lc3.Word) *Graph {
+	var words []lc3.Word
+	for address := int(from); address <= int(to); address++ {
+		words = append(words, m.PeekMem(lc3.Word(address)))
+	}
+	if len(entries) == 0 {
+		entries = []lc3.Word{from}
+	}
+	return build([]lc3.Section{{Origin: from, Words: words}}, entries, nil, nil)
+}
+
+func build(sections []lc3.Section, entries []lc3.Word, symbols []lc3.Symbol, code map[lc3.Word]bool) *Graph {
+	g := &Graph{
+		Entries: entries,
+		Symbols: symbols,
+		words:   make(map[lc3.Word]lc3.Word),
+		code:    code,
+		block:   make(map[lc3.Word]*Block),
+	}
+	for _, section := range sections {
+		for i, word := range section.Words {
+			g.words[section.Origin+lc3.Word(i)] = word
+		}
+	}
+	if g.code == nil {
+		g.code = make(map[lc3.Word]bool)
+		for address := range g.words {
+			g.code[address] = true
+		}
+	}
+
+	reachable, leaders := g.trace()
+	g.split(reachable, leaders)
+	g.link()
+	g.liveness()
+	g.reachingDefinitions()
+	return g
+}
+
+// endsBlock checks if instruction leaves the straight line of execution
+func endsBlock(i lc3.Instruction) bool {
+	switch i.Opcode {
+	case lc3.OpBr, lc3.OpJmp, lc3.OpJsr, lc3.OpRti, lc3.OpRes:
+		return true
+	case lc3.OpTrap:
+		return i.Immediate == lc3.TrapVectHalt
+	}
+	return false
+}
+
+// find reachable addresses and first addresses of blocks
+func (g *Graph) trace() (map[lc3.Word]bool, map[lc3.Word]bool) {
+	reachable := make(map[lc3.Word]bool)
+	leaders := make(map[lc3.Word]bool)
+	subroutines := make(map[lc3.Word]bool)
+	queue := append([]lc3.Word{}, g.Entries...)
+	for _, entry := range g.Entries {
+		leaders[entry] = true
+	}
+
+	for len(queue) > 0 {
+		address := queue[0]
+		queue = queue[1:]
+
+		word, ok := g.words[address]
+		if !ok || reachable[address] {
+			continue
+		}
+		reachable[address] = true
+
+		i := lc3.DecodeInstruction(word)
+		switch i.Opcode {
+		case lc3.OpBr:
+			target, _ := i.Target(address)
+			leaders[target] = true
+			queue = append(queue, target)
+		case lc3.OpJsr:
+			if target, ok := i.Target(address); ok {
+				leaders[target] = true
+				subroutines[target] = true
+				queue = append(queue, target)
+			}
+		}
+		if endsBlock(i) {
+			leaders[address+1] = true
+		}
+		if fallsThrough(i) {
+			queue = append(queue, address+1)
+		}
+	}
+
+	for address := range subroutines {
+		g.Subroutines = append(g.Subroutines, address)
+	}
+	sortWords(g.Subroutines)
+	return reachable, leaders
+}
+
+// checks if execution can continue with the next instruction, after return of a subroutine for calls
+func fallsThrough(i lc3.Instruction) bool {
+	switch i.Opcode {
+	case lc3.OpBr:
+		return i.Flags != lc3.FlN|lc3.FlZ|lc3.FlP
+	case lc3.OpJmp, lc3.OpRti, lc3.OpRes:
+		return false
+	case lc3.OpTrap:
+		return i.Immediate != lc3.TrapVectHalt
+	}
+	return true
+}
+
+// split reachable addresses into blocks
+func (g *Graph) split(reachable map[lc3.Word]bool, leaders map[lc3.Word]bool) {
+	var addresses []lc3.Word
+	for address := range reachable {
+		addresses = append(addresses, address)
+	}
+	sortWords(addresses)
+
+	var current *Block
+	for _, address := range addresses {
+		if current == nil || leaders[address] || address != current.End()+1 {
+			current = &Block{Start: address}
+			g.Blocks = append(g.Blocks, current)
+		}
+		word := g.words[address]
+		current.Words = append(current.Words, word)
+		g.block[address] = current
+		if endsBlock(lc3.DecodeInstruction(word)) {
+			current = nil
+		}
+	}
+}
+
+func (g *Graph) link() {
+	for _, b := range g.Blocks {
+		last := b.End()
+		i := lc3.DecodeInstruction(g.words[last])
+		switch i.Opcode {
+		case lc3.OpBr:
+			target, _ := i.Target(last)
+			b.Succs = append(b.Succs, Edge{target, EdgeBranch})
+		case lc3.OpJsr:
+			if target, ok := i.Target(last); ok {
+				b.Succs = append(b.Succs, Edge{target, EdgeCall})
+			} else {
+				b.Exit = ExitIndirect
+			}
+		case lc3.OpJmp:
+			b.Exit = ExitIndirect
+			if i.SR1 == lc3.RegR7 {
+				b.Exit = ExitReturn
+			}
+		case lc3.OpRti, lc3.OpRes:
+			b.Exit = ExitInvalid
+		case lc3.OpTrap:
+			if i.Immediate == lc3.TrapVectHalt {
+				b.Exit = ExitHalt
+			}
+		}
+
+		if fallsThrough(i) {
+			kind := EdgeFall
+			if i.Opcode == lc3.OpJsr {
+				kind = EdgeReturn
+			}
+			// BR to the next instruction has one edge
+			if i.Opcode != lc3.OpBr || b.Succs[0].To != last+1 {
+				b.Succs = append(b.Succs, Edge{last + 1, kind})
+			}
+		}
+	}
+
+	for _, b := range g.Blocks {
+		for _, e := range b.Succs {
+			if succ := g.block[e.To]; succ != nil && succ.Start == e.To {
+				succ.Preds = append(succ.Preds, b.Start)
+			}
+		}
+	}
+}
+
+// Block returns block containing address
+func (g *Graph) Block(address lc3.Word) *Block {
+	return g.block[address]
+}
+
+// Unreachable returns addresses of code words not reachable from the entries, i.e. dead code
+func (g *Graph) Unreachable() []lc3.Word {
+	var ret []lc3.Word
+	for address := range g.code {
+		if _, ok := g.words[address]; ok && g.block[address] == nil {
+			ret = append(ret, address)
+		}
+	}
+	sortWords(ret)
+	return ret
+}
+
+// Label returns label at address
+func (g *Graph) Label(address lc3.Word) (string, bool) {
+	i := sort.Search(len(g.Symbols), func(i int) bool { return g.Symbols[i].Address >= address })
+	if i < len(g.Symbols) && g.Symbols[i].Address == address {
+		return g.Symbols[i].Name, true
+	}
+	return "", false
+}
+
+func sortWords(words []lc3.Word) {
+	sort.Slice(words, func(i, j int) bool { return words[i] < words[j] })
+}
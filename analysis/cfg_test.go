@@ -0,0 +1,121 @@
+package analysis
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+const testProgram = `	.orig x3000
+main	ld r1, count
+	add r2, r2, #1
+loop	add r1, r1, #-1
+	brp loop
+	jsr sub
+	add r3, r0, #0
+	halt
+dead	add r4, r4, #1
+sub	add r0, r5, #0
+	ret
+count	.fill #3
+	.end
+`
+
+func assemble(t *testing.T, source string) *lc3.Program {
+	t.Helper()
+	program, err := (&lc3.Assembler{}).Assemble("main.asm", strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+func Test_Graph(t *testing.T) {
+	g := FromProgram(assemble(t, testProgram))
+
+	type block struct {
+		start, end lc3.Word
+		succs      []Edge
+		preds      []lc3.Word
+		exit       ExitKind
+	}
+	expected := []block{
+		{0x3000, 0x3001, []Edge{{0x3002, EdgeFall}}, nil, ExitNone},
+		{0x3002, 0x3003, []Edge{{0x3002, EdgeBranch}, {0x3004, EdgeFall}}, []lc3.Word{0x3000, 0x3002}, ExitNone},
+		{0x3004, 0x3004, []Edge{{0x3008, EdgeCall}, {0x3005, EdgeReturn}}, []lc3.Word{0x3002}, ExitNone},
+		{0x3005, 0x3006, nil, []lc3.Word{0x3004}, ExitHalt},
+		{0x3008, 0x3009, nil, []lc3.Word{0x3004}, ExitReturn},
+	}
+	if len(g.Blocks) != len(expected) {
+		t.Fatalf("expected %d blocks, got %d", len(expected), len(g.Blocks))
+	}
+	for i, e := range expected {
+		b := g.Blocks[i]
+		got := block{b.Start, b.End(), b.Succs, b.Preds, b.Exit}
+		if !reflect.DeepEqual(got, e) {
+			t.Errorf("expected block %+v, got %+v", e, got)
+		}
+	}
+
+	if !reflect.DeepEqual(g.Subroutines, []lc3.Word{0x3008}) {
+		t.Errorf("expected subroutine at x3008, got %v", g.Subroutines)
+	}
+	if !reflect.DeepEqual(g.Unreachable(), []lc3.Word{0x3007}) {
+		t.Errorf("expected dead code at x3007, got %v", g.Unreachable())
+	}
+	if g.Block(0x3003) != g.Blocks[1] || g.Block(0x300A) != nil {
+		t.Errorf("unexpected blocks of addresses")
+	}
+}
+
+func Test_FromMemory(t *testing.T) {
+	program := assemble(t, testProgram)
+	vm := program.NewVM()
+	g := FromMemory(vm, 0x3000, 0x300A, 0x3000, 0x3007)
+	if len(g.Blocks) != 6 || len(g.Unreachable()) != 1 {
+		t.Errorf("expected 6 blocks and the count word unreachable, got %d blocks and %v", len(g.Blocks), g.Unreachable())
+	}
+}
+
+func Test_Export(t *testing.T) {
+	g := FromProgram(assemble(t, testProgram))
+
+	var dot bytes.Buffer
+	if err := g.WriteDot(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"x3000" [label="MAIN:\lx3000  LD R1, x300A\lx3001  ADD R2, R2, x1\l"];`,
+		`"x3004" -> "x3008" [label="call" style=dashed];`,
+		`"x3004" -> "x3005" [label="return" style=dotted];`,
+		`"x3002" -> "x3002" [label="branch"];`,
+	} {
+		if !strings.Contains(dot.String(), s) {
+			t.Errorf("%s is expected in DOT:\n%s", s, dot.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := g.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"unreachable": [
+    "x3007"
+  ]`,
+		`"uninitialized_reads": [
+    {
+      "address": "x3001",
+      "register": "R2"
+    }
+  ]`,
+		`"exit": "return"`,
+	} {
+		if !strings.Contains(js.String(), s) {
+			t.Errorf("%s is expected in JSON:\n%s", s, js.String())
+		}
+	}
+}
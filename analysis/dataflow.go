@@ -0,0 +1,340 @@
+package analysis
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// RegSet is a set of registers R0-R7
+type RegSet uint8
+
+const allRegisters RegSet = 0xFF
+
+func regs(registers ...lc3.Word) RegSet {
+	var ret RegSet
+	for _, r := range registers {
+		ret |= 1 << r
+	}
+	return ret
+}
+
+func (s RegSet) Has(register int) bool {
+	return s&(1<<register) != 0
+}
+
+// Registers returns numbers of registers in the set
+func (s RegSet) Registers() []int {
+	var ret []int
+	for r := 0; r < 8; r++ {
+		if s.Has(r) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+func (s RegSet) String() string {
+	var names []string
+	for _, r := range s.Registers() {
+		names = append(names, fmt.Sprintf("R%d", r))
+	}
+	return strings.Join(names, " ")
+}
+
+// registers read by operands and written by instruction. calls, returns and indirect jumps are assumed
+// to read all registers implicitly, calls to write them all
+func effect(i lc3.Instruction) (reads RegSet, implicit RegSet, writes RegSet) {
+	switch i.Opcode {
+	case lc3.OpAdd, lc3.OpAnd:
+		reads = regs(i.SR1)
+		if !i.ImmediateMode {
+			reads |= regs(i.SR2)
+		}
+		// AND with zero clears the register without depending on it
+		if i.Opcode == lc3.OpAnd && i.ImmediateMode && i.Immediate == 0 {
+			reads = 0
+		}
+		writes = regs(i.DR)
+	case lc3.OpNot:
+		reads, writes = regs(i.SR1), regs(i.DR)
+	case lc3.OpLd, lc3.OpLdi, lc3.OpLea:
+		writes = regs(i.DR)
+	case lc3.OpLdr:
+		reads, writes = regs(i.SR1), regs(i.DR)
+	case lc3.OpSt, lc3.OpSti:
+		reads = regs(i.DR)
+	case lc3.OpStr:
+		reads = regs(i.DR, i.SR1)
+	case lc3.OpJmp:
+		reads, implicit = regs(i.SR1), allRegisters
+	case lc3.OpJsr:
+		if !i.ImmediateMode {
+			reads = regs(i.SR1)
+		}
+		implicit, writes = allRegisters, allRegisters
+	case lc3.OpTrap:
+		switch i.Immediate {
+		case lc3.TrapVectGetc, lc3.TrapVectIn:
+			writes = regs(lc3.RegR0, lc3.RegR7)
+		case lc3.TrapVectOut, lc3.TrapVectPuts, lc3.TrapVectPutsp:
+			reads, writes = regs(lc3.RegR0), regs(lc3.RegR7)
+		case lc3.TrapVectHalt:
+		default:
+			implicit, writes = allRegisters, allRegisters
+		}
+	}
+	return
+}
+
+// data flow follows edges inside procedures
+func intraprocedural(e Edge) bool {
+	return e.Kind != EdgeCall
+}
+
+func (g *Graph) liveness() {
+	use := make(map[*Block]RegSet)
+	def := make(map[*Block]RegSet)
+	for _, b := range g.Blocks {
+		for _, word := range b.Words {
+			reads, implicit, writes := effect(lc3.DecodeInstruction(word))
+			use[b] |= (reads | implicit) &^ def[b]
+			def[b] |= writes
+		}
+	}
+
+	g.liveIn = make(map[*Block]RegSet)
+	g.liveOut = make(map[*Block]RegSet)
+	for changed := true; changed; {
+		changed = false
+		for i := len(g.Blocks) - 1; i >= 0; i-- {
+			b := g.Blocks[i]
+			var out RegSet
+			for _, e := range b.Succs {
+				if succ := g.block[e.To]; intraprocedural(e) && succ != nil {
+					out |= g.liveIn[succ]
+				}
+			}
+			in := use[b] | out&^def[b]
+			if in != g.liveIn[b] || out != g.liveOut[b] {
+				g.liveIn[b], g.liveOut[b] = in, out
+				changed = true
+			}
+		}
+	}
+}
+
+// LiveIn returns registers live before the instruction at address
+func (g *Graph) LiveIn(address lc3.Word) RegSet {
+	b := g.block[address]
+	if b == nil {
+		return 0
+	}
+	live := g.liveOut[b]
+	for a := b.End(); ; a-- {
+		reads, implicit, writes := effect(lc3.DecodeInstruction(g.words[a]))
+		live = live&^writes | reads | implicit
+		if a == address {
+			return live
+		}
+	}
+}
+
+// LiveOut returns registers live after the instruction at address
+func (g *Graph) LiveOut(address lc3.Word) RegSet {
+	b := g.block[address]
+	if b == nil {
+		return 0
+	}
+	if address == b.End() {
+		return g.liveOut[b]
+	}
+	return g.LiveIn(address + 1)
+}
+
+// Def is a definition of a register value
+type Def struct {
+	// address of the instruction writing the register or of the entry point
+	Address  lc3.Word
+	Register int
+	// the value is undefined at the program entry
+	Uninitialized bool
+	// the value is passed by the caller of the subroutine at Address
+	Parameter bool
+}
+
+func (d Def) String() string {
+	switch {
+	case d.Uninitialized:
+		return fmt.Sprintf("R%d uninitialized at x%04X", d.Register, d.Address)
+	case d.Parameter:
+		return fmt.Sprintf("R%d parameter of x%04X", d.Register, d.Address)
+	}
+	return fmt.Sprintf("R%d at x%04X", d.Register, d.Address)
+}
+
+// set of indexes of Graph.defs
+type defSet []uint64
+
+func newDefSet(size int) defSet {
+	return make(defSet, (size+63)/64)
+}
+
+func (s defSet) add(i int)      { s[i/64] |= 1 << (i % 64) }
+func (s defSet) remove(i int)   { s[i/64] &^= 1 << (i % 64) }
+func (s defSet) has(i int) bool { return s[i/64]&(1<<(i%64)) != 0 }
+func (s defSet) union(other defSet) {
+	for i := range s {
+		s[i] |= other[i]
+	}
+}
+func (s defSet) clone() defSet { return append(defSet{}, s...) }
+
+func (s defSet) equal(other defSet) bool {
+	for i := range s {
+		if s[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s defSet) count() int {
+	ret := 0
+	for _, w := range s {
+		ret += bits.OnesCount64(w)
+	}
+	return ret
+}
+
+func (g *Graph) reachingDefinitions() {
+	// definitions made at the start of blocks and by instructions
+	initial := make(map[lc3.Word][]int)
+	g.instructionDefs = make(map[lc3.Word][]int)
+	add := func(d Def) int {
+		g.defs = append(g.defs, d)
+		i := len(g.defs) - 1
+		g.registerDefs[d.Register] = append(g.registerDefs[d.Register], i)
+		return i
+	}
+
+	for _, entry := range g.Entries {
+		for r := 0; r < 8; r++ {
+			initial[entry] = append(initial[entry], add(Def{Address: entry, Register: r, Uninitialized: true}))
+		}
+	}
+	for _, entry := range g.Subroutines {
+		for r := 0; r < 8; r++ {
+			initial[entry] = append(initial[entry], add(Def{Address: entry, Register: r, Parameter: true}))
+		}
+	}
+	for _, b := range g.Blocks {
+		for i, word := range b.Words {
+			address := b.Start + lc3.Word(i)
+			_, _, writes := effect(lc3.DecodeInstruction(word))
+			for _, r := range writes.Registers() {
+				g.instructionDefs[address] = append(g.instructionDefs[address], add(Def{Address: address, Register: r}))
+			}
+		}
+	}
+
+	g.reachIn = make(map[*Block]defSet)
+	out := make(map[*Block]defSet)
+	for _, b := range g.Blocks {
+		g.reachIn[b] = newDefSet(len(g.defs))
+		out[b] = newDefSet(len(g.defs))
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range g.Blocks {
+			in := newDefSet(len(g.defs))
+			for _, d := range initial[b.Start] {
+				in.add(d)
+			}
+			for _, predStart := range b.Preds {
+				pred := g.block[predStart]
+				for _, e := range pred.Succs {
+					if e.To == b.Start && intraprocedural(e) {
+						in.union(out[pred])
+						break
+					}
+				}
+			}
+
+			set := in.clone()
+			for i := range b.Words {
+				g.transfer(set, b.Start+lc3.Word(i))
+			}
+			if !in.equal(g.reachIn[b]) || !set.equal(out[b]) {
+				g.reachIn[b], out[b] = in, set
+				changed = true
+			}
+		}
+	}
+}
+
+// apply definitions of instruction at address to set
+func (g *Graph) transfer(set defSet, address lc3.Word) {
+	for _, d := range g.instructionDefs[address] {
+		for _, other := range g.registerDefs[g.defs[d].Register] {
+			set.remove(other)
+		}
+	}
+	for _, d := range g.instructionDefs[address] {
+		set.add(d)
+	}
+}
+
+// Reaching returns definitions reaching the instruction at address
+func (g *Graph) Reaching(address lc3.Word) []Def {
+	b := g.block[address]
+	if b == nil {
+		return nil
+	}
+	set := g.reachIn[b].clone()
+	for a := b.Start; a != address; a++ {
+		g.transfer(set, a)
+	}
+
+	ret := make([]Def, 0, set.count())
+	for i, d := range g.defs {
+		if set.has(i) {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+// Read is a read of a register by an instruction
+type Read struct {
+	Address  lc3.Word
+	Register int
+}
+
+// UninitializedReads returns reads of registers which may be undefined because no instruction
+// writes them on some path from a program entry. reads in subroutines are not reported
+func (g *Graph) UninitializedReads() []Read {
+	var ret []Read
+	for _, b := range g.Blocks {
+		for i, word := range b.Words {
+			address := b.Start + lc3.Word(i)
+			reads, _, _ := effect(lc3.DecodeInstruction(word))
+			if reads == 0 {
+				continue
+			}
+			var uninitialized RegSet
+			for _, d := range g.Reaching(address) {
+				if d.Uninitialized {
+					uninitialized |= 1 << d.Register
+				}
+			}
+			for _, r := range (reads & uninitialized).Registers() {
+				ret = append(ret, Read{Address: address, Register: r})
+			}
+		}
+	}
+	return ret
+}
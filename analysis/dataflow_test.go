@@ -0,0 +1,67 @@
+package analysis
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+func Test_Liveness(t *testing.T) {
+	g := FromProgram(assemble(t, `	.orig x3000
+	and r0, r0, #0
+	add r1, r0, #5
+	add r2, r1, r1
+	add r0, r2, #0
+	halt
+	.end
+`))
+	for address, live := range map[lc3.Word]RegSet{
+		0x3000: 0,
+		0x3001: regs(lc3.RegR0),
+		0x3002: regs(lc3.RegR1),
+		0x3003: regs(lc3.RegR2),
+		0x3004: 0,
+	} {
+		if got := g.LiveIn(address); got != live {
+			t.Errorf("expected [%s] live at x%04X, got [%s]", live, address, got)
+		}
+	}
+	if got := g.LiveOut(0x3001); got != regs(lc3.RegR1) {
+		t.Errorf("expected R1 live after x3001, got [%s]", got)
+	}
+	if reads := g.UninitializedReads(); len(reads) != 0 {
+		t.Errorf("no uninitialized reads are expected, got %v", reads)
+	}
+}
+
+func Test_ReachingDefinitions(t *testing.T) {
+	g := FromProgram(assemble(t, testProgram))
+
+	var r1 []Def
+	for _, d := range g.Reaching(0x3002) {
+		if d.Register == lc3.RegR1 {
+			r1 = append(r1, d)
+		}
+	}
+	expected := []Def{{Address: 0x3000, Register: lc3.RegR1}, {Address: 0x3002, Register: lc3.RegR1}}
+	if !reflect.DeepEqual(r1, expected) {
+		t.Errorf("expected definitions %v of R1, got %v", expected, r1)
+	}
+
+	// R0 is defined by the call, R5 is a parameter of the subroutine
+	for _, d := range g.Reaching(0x3005) {
+		if d.Register == lc3.RegR0 && d != (Def{Address: 0x3004, Register: lc3.RegR0}) {
+			t.Errorf("unexpected definition of R0 after the call %v", d)
+		}
+	}
+	for _, d := range g.Reaching(0x3008) {
+		if d.Register == lc3.RegR5 && d != (Def{Address: 0x3008, Register: lc3.RegR5, Parameter: true}) {
+			t.Errorf("unexpected definition of R5 in the subroutine %v", d)
+		}
+	}
+
+	if reads := g.UninitializedReads(); !reflect.DeepEqual(reads, []Read{{0x3001, lc3.RegR2}}) {
+		t.Errorf("expected uninitialized read of R2 at x3001, got %v", reads)
+	}
+}
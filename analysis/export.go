@@ -0,0 +1,167 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+var formatter = lc3.Formatter{Signed: true, UpperHex: true, Targets: true}
+
+func hex(address lc3.Word) string {
+	return fmt.Sprintf("x%04X", address)
+}
+
+// names of registers, never nil for JSON
+func (s RegSet) names() []string {
+	ret := []string{}
+	for _, r := range s.Registers() {
+		ret = append(ret, fmt.Sprintf("R%d", r))
+	}
+	return ret
+}
+
+// WriteDot writes the graph in Graphviz DOT format. calls are dashed, returns from calls are dotted
+func (g *Graph) WriteDot(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph cfg {\n\tnode [shape=box fontname=\"monospace\"];\n")
+
+	external := make(map[lc3.Word]bool)
+	for _, block := range g.Blocks {
+		var label strings.Builder
+		if name, ok := g.Label(block.Start); ok {
+			label.WriteString(name + ":\\l")
+		}
+		for i, word := range block.Words {
+			address := block.Start + lc3.Word(i)
+			label.WriteString(dotEscape(fmt.Sprintf("%s  %s", hex(address), formatter.EncodeInstructionAt(address, word))) + "\\l")
+		}
+		fmt.Fprintf(&b, "\t%q [label=\"%s\"];\n", hex(block.Start), label.String())
+
+		for _, e := range block.Succs {
+			style := ""
+			switch e.Kind {
+			case EdgeCall:
+				style = " style=dashed"
+			case EdgeReturn:
+				style = " style=dotted"
+			}
+			fmt.Fprintf(&b, "\t%q -> %q [label=%q%s];\n", hex(block.Start), hex(e.To), e.Kind.String(), style)
+			if g.block[e.To] == nil {
+				external[e.To] = true
+			}
+		}
+	}
+
+	var addresses []lc3.Word
+	for address := range external {
+		addresses = append(addresses, address)
+	}
+	sortWords(addresses)
+	for _, address := range addresses {
+		fmt.Fprintf(&b, "\t%q [shape=plaintext];\n", hex(address))
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text)
+}
+
+type jsonGraph struct {
+	Entries            []string    `json:"entries"`
+	Subroutines        []string    `json:"subroutines"`
+	Blocks             []jsonBlock `json:"blocks"`
+	Unreachable        []string    `json:"unreachable"`
+	UninitializedReads []jsonRead  `json:"uninitialized_reads"`
+}
+
+type jsonBlock struct {
+	Start        string            `json:"start"`
+	End          string            `json:"end"`
+	Label        string            `json:"label,omitempty"`
+	Exit         string            `json:"exit"`
+	LiveIn       []string          `json:"live_in"`
+	LiveOut      []string          `json:"live_out"`
+	Instructions []jsonInstruction `json:"instructions"`
+	Successors   []jsonEdge        `json:"successors"`
+	Predecessors []string          `json:"predecessors"`
+}
+
+type jsonInstruction struct {
+	Address string   `json:"address"`
+	Word    string   `json:"word"`
+	Text    string   `json:"text"`
+	LiveIn  []string `json:"live_in"`
+	LiveOut []string `json:"live_out"`
+}
+
+type jsonEdge struct {
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+type jsonRead struct {
+	Address  string `json:"address"`
+	Register string `json:"register"`
+}
+
+func hexes(addresses []lc3.Word) []string {
+	ret := []string{}
+	for _, address := range addresses {
+		ret = append(ret, hex(address))
+	}
+	return ret
+}
+
+// WriteJSON writes blocks with liveness of registers, unreachable code and uninitialised reads as JSON
+func (g *Graph) WriteJSON(w io.Writer) error {
+	ret := jsonGraph{
+		Entries:            hexes(g.Entries),
+		Subroutines:        hexes(g.Subroutines),
+		Blocks:             []jsonBlock{},
+		Unreachable:        hexes(g.Unreachable()),
+		UninitializedReads: []jsonRead{},
+	}
+
+	for _, block := range g.Blocks {
+		jb := jsonBlock{
+			Start:        hex(block.Start),
+			End:          hex(block.End()),
+			Exit:         block.Exit.String(),
+			LiveIn:       g.liveIn[block].names(),
+			LiveOut:      g.liveOut[block].names(),
+			Successors:   []jsonEdge{},
+			Predecessors: hexes(block.Preds),
+		}
+		jb.Label, _ = g.Label(block.Start)
+		for i, word := range block.Words {
+			address := block.Start + lc3.Word(i)
+			jb.Instructions = append(jb.Instructions, jsonInstruction{
+				Address: hex(address),
+				Word:    hex(word),
+				Text:    formatter.EncodeInstructionAt(address, word),
+				LiveIn:  g.LiveIn(address).names(),
+				LiveOut: g.LiveOut(address).names(),
+			})
+		}
+		for _, e := range block.Succs {
+			jb.Successors = append(jb.Successors, jsonEdge{To: hex(e.To), Kind: e.Kind.String()})
+		}
+		ret.Blocks = append(ret.Blocks, jb)
+	}
+
+	for _, read := range g.UninitializedReads() {
+		ret.UninitializedReads = append(ret.UninitializedReads, jsonRead{Address: hex(read.Address), Register: fmt.Sprintf("R%d", read.Register)})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(ret)
+}
@@ -0,0 +1,308 @@
+package lc3
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Assembler holds settings shared by all files of one program
+type Assembler struct {
+	// FS is used to read source files. nil means the operating system's file system
+	FS fs.FS
+	// IncludePaths are searched in order for .INCLUDE files not found next to the including file
+	IncludePaths []string
+	// Defines are symbols for .IF and .IFDEF. immediate operands of ADD, AND, LDR, STR and TRAP
+	// and operands of .ORIG and .BLKW named after a define are replaced by its value
+	Defines map[string]int
+	// MaxExpandedLines limits lines produced by macro expansion, so nested macros can't exhaust time and memory.
+	// zero means no limit
+	MaxExpandedLines int
+}
+
+// Parse assembles a program read from reader and loads it into a new VM.
+// name is used in error messages and as a base for .INCLUDE lookups. it can be empty
+func (a *Assembler) Parse(name string, reader io.Reader) (*VM, error) {
+	program, err := a.Assemble(name, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return program.NewVM(), nil
+}
+
+// ParseFile assembles source file name and loads it into a new VM
+func (a *Assembler) ParseFile(name string) (*VM, error) {
+	program, err := a.AssembleFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return program.NewVM(), nil
+}
+
+// Assemble translates source read from reader into an absolute program
+func (a *Assembler) Assemble(name string, reader io.Reader) (*Program, error) {
+	lines, err := a.parse(name, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err = a.expandLines(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	return assemble(name, lines)
+}
+
+// AssembleFile translates source file name into an absolute program
+func (a *Assembler) AssembleFile(name string) (*Program, error) {
+	file, err := a.fs().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return a.Assemble(name, file)
+}
+
+// ParseSource reads syntax tree of source file without assembling it.
+// every line is kept as written including blank lines, comments, inactive conditional blocks,
+// .INCLUDE directives and lines after .END, so the file can be printed back with FormatSource.
+// included files are only read for names of macros they define
+func (a *Assembler) ParseSource(name string, reader io.Reader) (*File, error) {
+	p := a.newParser()
+	p.files = append(p.files, p.clean(name))
+
+	file, err := p.parseSource(name, reader)
+	if err != nil {
+		return nil, err
+	}
+	for i := range file.Lines {
+		if file.Lines[i].err != nil {
+			return nil, file.Lines[i].err
+		}
+	}
+	return file, nil
+}
+
+// read lines of source file and all files it includes
+func (a *Assembler) parse(name string, reader io.Reader) ([]Line, error) {
+	p := a.newParser()
+	if name != "" {
+		p.files = append(p.files, p.clean(name))
+	} else {
+		p.files = append(p.files, "")
+	}
+
+	file, err := p.parseSource(name, reader)
+	if err != nil {
+		return nil, err
+	}
+	return p.preprocess(file)
+}
+
+func (a *Assembler) fs() fs.FS {
+	if a.FS == nil {
+		return osFS{}
+	}
+	return a.FS
+}
+
+func (a *Assembler) newParser() *parser {
+	ret := &parser{
+		assembler: a,
+		macros:    make(map[string]bool),
+		defines:   a.defines(),
+		sources:   make(map[string]*File),
+	}
+	return ret
+}
+
+// defines by upper case names
+func (a *Assembler) defines() map[string]int {
+	ret := make(map[string]int)
+	for name, value := range a.Defines {
+		ret[strings.ToUpper(name)] = value
+	}
+	return ret
+}
+
+// osFS opens files by operating system's paths, including absolute ones
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+// state shared by all files of a program while they are parsed
+type parser struct {
+	assembler *Assembler
+	// names of macros defined so far. a macro call looks like an opcode
+	macros map[string]bool
+	// stack of files being parsed. the last one is the current file
+	files []string
+	// symbols defined for conditional assembly. names are in upper case
+	defines map[string]int
+	// stack of open .IF directives
+	conditions []condition
+	// number of conditions opened before the current file
+	conditionsBase int
+	// syntax trees of included files by their resolved names
+	sources map[string]*File
+	// stack of included files read for names of macros
+	reading []string
+}
+
+func (p *parser) isOS() bool {
+	_, ok := p.assembler.fs().(osFS)
+	return ok
+}
+
+func (p *parser) clean(name string) string {
+	if p.isOS() {
+		return filepath.Clean(name)
+	}
+	return path.Clean(name)
+}
+
+func (p *parser) join(dir, name string) string {
+	if p.isOS() {
+		if filepath.IsAbs(name) {
+			return filepath.Clean(name)
+		}
+		return filepath.Join(dir, name)
+	}
+	return path.Join(dir, name)
+}
+
+func (p *parser) dir(name string) string {
+	if p.isOS() {
+		return filepath.Dir(name)
+	}
+	return path.Dir(name)
+}
+
+// find file to be included from file "from".
+// the directory of "from" is checked first, then include paths
+func (p *parser) resolve(from string, name string) (string, error) {
+	dirs := append([]string{p.dir(from)}, p.assembler.IncludePaths...)
+	for _, dir := range dirs {
+		candidate := p.join(dir, name)
+		_, err := fs.Stat(p.assembler.fs(), candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", errors.Errorf("file %s not found", name)
+}
+
+// parse file included by .INCLUDE line
+func (p *parser) include(from string, line Line) ([]Line, error) {
+	if line.Label != "" {
+		return nil, line.pos.errorf("label is not allowed before .INCLUDE")
+	}
+	if len(line.Operands) != 1 || !line.Operands[0].isString() {
+		return nil, line.pos.errorf("file name expected for .INCLUDE")
+	}
+
+	name, err := p.resolve(from, *line.Operands[0].string)
+	if err != nil {
+		return nil, line.pos.wrap(err)
+	}
+
+	for i, file := range p.files {
+		if file == name {
+			cycle := append(append([]string{}, p.files[i:]...), name)
+			return nil, line.pos.errorf("include cycle %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	source, ok := p.sources[name]
+	if !ok {
+		source, err = p.readSource(name)
+		if err != nil {
+			return nil, line.pos.wrap(err)
+		}
+	}
+
+	p.files = append(p.files, name)
+	defer func() { p.files = p.files[:len(p.files)-1] }()
+
+	return p.preprocess(source)
+}
+
+// parse included file and remember its syntax tree
+func (p *parser) readSource(name string) (*File, error) {
+	file, err := p.assembler.fs().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p.reading = append(p.reading, name)
+	defer func() { p.reading = p.reading[:len(p.reading)-1] }()
+
+	source, err := p.parseSource(name, file)
+	if err != nil {
+		return nil, err
+	}
+	p.sources[name] = source
+	return source, nil
+}
+
+// remember names of macros defined by a parsed line or by the file it includes.
+// includes are read before conditions are known, so errors are reported only when the file is assembled
+func (p *parser) learnMacros(from string, line Line) {
+	if line.Opcode == stropMacro && len(line.Operands) > 0 && line.Operands[0].isLabel() {
+		p.macros[*line.Operands[0].label] = true
+	}
+
+	if line.Opcode != stropInclude || len(line.Operands) != 1 || !line.Operands[0].isString() {
+		return
+	}
+	name, err := p.resolve(from, *line.Operands[0].string)
+	if err != nil {
+		return
+	}
+	if _, ok := p.sources[name]; ok {
+		return
+	}
+	for _, file := range append(p.reading, p.files...) {
+		if file == name {
+			return
+		}
+	}
+	p.readSource(name)
+}
+
+// expand macros, defines and local labels, so lines can be assembled
+func (a *Assembler) expandLines(lines []Line) ([]Line, error) {
+	lines, err := expandMacros(lines, a.MaxExpandedLines)
+	if err != nil {
+		return nil, err
+	}
+	substituteDefines(lines, a.defines())
+
+	return resolveLocalLabels(lines)
+}
+
+// translate parsed lines into an absolute program
+func assemble(name string, lines []Line) (*Program, error) {
+	obj, err := assembleObject(lines, false)
+	if err != nil {
+		return nil, err
+	}
+	obj.Name = name
+
+	return Link(0, obj)
+}
@@ -0,0 +1,192 @@
+package lc3
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var testIncludeFS = fstest.MapFS{
+	"main.asm": {Data: []byte(`
+		.include "lib/stack.asm"
+		.include "io.asm"
+				lea r6, stack
+				add r0, r0, #5
+				push r0
+				pop r1
+				inc r1
+				halt
+				.fill #0
+		stack	.fill #0
+		.end`)},
+	"lib/stack.asm": {Data: []byte(`
+		.macro push reg
+				add r6, r6, #-1
+				str reg, r6, #0
+		.endm
+		.macro pop reg
+				ldr reg, r6, #0
+				add r6, r6, #1
+		.endm
+		.end
+		this line is ignored`)},
+	"include/io.asm": {Data: []byte(`
+		.macro inc reg
+				add reg, reg, #1
+		.endm`)},
+	"cycle/a.asm":      {Data: []byte(`.include "b.asm"`)},
+	"cycle/b.asm":      {Data: []byte(`.include "a.asm"`)},
+	"errors/main.asm":  {Data: []byte("halt\n.include \"bad.asm\"\n")},
+	"errors/bad.asm":   {Data: []byte("\nld r0, nowhere\n")},
+	"errors/macro.asm": {Data: []byte(".include \"../lib/stack.asm\"\npush r0, r1\n")},
+}
+
+func Test_Include(t *testing.T) {
+	m, err := (&Assembler{FS: testIncludeFS, IncludePaths: []string{"include"}}).ParseFile("main.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Start()
+	for m.Step() == nil {
+	}
+
+	if m.GetRegister(RegR1) != 6 {
+		t.Errorf("expected r1 = 6, got %d", m.GetRegister(RegR1))
+	}
+}
+
+func Test_IncludeErrors(t *testing.T) {
+	type testCase struct {
+		file  string
+		error string
+	}
+
+	testData := []testCase{
+		{"main.asm", "file io.asm not found at main.asm:3"},
+		{"cycle/a.asm", "include cycle cycle/a.asm -> cycle/b.asm -> cycle/a.asm at cycle/b.asm:1"},
+		{"errors/main.asm", "unknown label NOWHERE at errors/bad.asm:2"},
+		{"errors/macro.asm", "macro PUSH expects 1 arguments, got 2 at errors/macro.asm:2"},
+		{"missing.asm", "file does not exist"},
+	}
+
+	for i := range testData {
+		_, err := ParseAssemblyFS(testIncludeFS, testData[i].file)
+		if err == nil {
+			t.Errorf("%d: expected error %q", i, testData[i].error)
+			continue
+		}
+		if !strings.Contains(err.Error(), testData[i].error) {
+			t.Errorf("%d: expected error %q, got %q", i, testData[i].error, err.Error())
+		}
+	}
+}
+
+func Test_Blkw(t *testing.T) {
+	program, err := (&Assembler{}).Assemble("main.asm", strings.NewReader(`
+			.orig x3000
+					ld r0, after
+					halt
+			buffer	.blkw #3
+			after	.fill #7
+			.end`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address, _ := program.Symbol("AFTER"); address != 0x3005 {
+		t.Errorf("expected AFTER at x3005, got x%04X", address)
+	}
+	line, ok := program.Line(0x3003)
+	if !ok || line.Address != 0x3002 || line.Size != 3 || line.Code || !line.Reserved {
+		t.Errorf("expected reserved data line of 3 words at x3002, got %+v", line)
+	}
+	if location, _ := program.Location(0x3004); location != "BUFFER+2" {
+		t.Errorf("expected location BUFFER+2, got %s", location)
+	}
+
+	m := program.NewVM()
+	m.Start()
+	for m.Step() == nil {
+	}
+	if m.GetRegister(RegR0) != 7 {
+		t.Errorf("expected r0 = 7, got %d", m.GetRegister(RegR0))
+	}
+
+	for _, code := range []string{".blkw #0", ".blkw #-1"} {
+		if _, err := (&Assembler{}).Assemble("bad.asm", strings.NewReader(code)); err == nil || !strings.Contains(err.Error(), "positive number of words expected") {
+			t.Errorf("%s: expected size error, got %v", code, err)
+		}
+	}
+}
+
+func Test_Diagnostics(t *testing.T) {
+	program, err := (&Assembler{}).Assemble("main.asm", strings.NewReader(`
+			.orig x3000
+			main	add r0, r0, #1
+					halt
+			.end`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d Diagnostics
+	diagnostic, ok := d.Report(program, 0, 0x3001, "message")
+	if expected := "main.asm:4: MAIN+1 (x3001): message"; !ok || diagnostic.String() != expected {
+		t.Errorf("expected %q, got %q", expected, diagnostic.String())
+	}
+	if _, ok := d.Report(program, 0, 0x3001, "again"); ok {
+		t.Errorf("instruction is reported twice with the same kind")
+	}
+	if _, ok := d.Report(program, 1, 0x3001, "another kind"); !ok {
+		t.Errorf("instruction is not reported with another kind")
+	}
+	if diagnostic, _ := d.Report(nil, 0, 0x3000, "message"); diagnostic.String() != "(x3000): message" {
+		t.Errorf("unexpected diagnostic without program %q", diagnostic.String())
+	}
+}
+
+func Test_ParseNumber(t *testing.T) {
+	for text, expected := range map[string]Word{
+		"12": 12, "#-1": 0xFFFF, "x3000": 0x3000, "0XFE00": 0xFE00, "-x10": 0xFFF0, "#65535": 0xFFFF,
+	} {
+		if n, err := ParseNumber(text); err != nil || n != expected {
+			t.Errorf("%s: expected x%04X, got x%04X, %v", text, expected, n, err)
+		}
+	}
+	for _, text := range []string{"", "#", "x", "xg", "65536", "label"} {
+		if _, err := ParseNumber(text); err == nil || err.Error() != "bad number "+text {
+			t.Errorf("%q: expected bad number, got %v", text, err)
+		}
+	}
+}
+
+func Test_ErrorPositions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir with spaces/main.asm": {Data: []byte(".include \"lib.asm\"\n.orig x3000\n  load at\n  halt\n.end\n")},
+		"dir with spaces/lib.asm":  {Data: []byte(".macro load label\n  ld r0, label\n.endm\n")},
+	}
+	_, err := (&Assembler{FS: fsys}).AssembleFile("dir with spaces/main.asm")
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected Error, got %v", err)
+	}
+	expected := &Error{
+		Pos:   Position{File: "dir with spaces/lib.asm", Line: 2},
+		Msg:   "unknown label AT",
+		Calls: []MacroCall{{Macro: "LOAD", Pos: Position{File: "dir with spaces/main.asm", Line: 3}}},
+	}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %+v, got %+v", expected, e)
+	}
+	if message := "unknown label AT at dir with spaces/lib.asm:2 in macro LOAD, expanded at dir with spaces/main.asm:3"; err.Error() != message {
+		t.Errorf("expected message %q, got %q", message, err.Error())
+	}
+
+	_, err = (&Assembler{}).ParseSource("main.asm", strings.NewReader("  add r0, r0, #"))
+	if !errors.As(err, &e) || e.Pos != (Position{File: "main.asm", Line: 1, Column: 15}) || e.Msg != "digits expected in #" {
+		t.Errorf("unexpected error %#v", err)
+	}
+}
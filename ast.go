@@ -0,0 +1,206 @@
+package lc3
+
+import "fmt"
+
+// Position is a place in a source file. lines and columns start at 1, columns count bytes
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// position in error messages: file:line or line N followed by :column if it is known
+func (p Position) location() string {
+	ret := currentPosition(p.File, p.Line)
+	if p.File == "" {
+		ret = "line " + ret
+	}
+	if p.Column > 0 {
+		ret += fmt.Sprintf(":%d", p.Column)
+	}
+	return ret
+}
+
+// File is a syntax tree of a source file returned by Assembler.ParseSource
+type File struct {
+	Name string
+	// every line of the file including blank ones
+	Lines []Line
+}
+
+// Node is an element of syntax tree: *File, *Line or *Operand
+type Node interface {
+	Pos() Position
+}
+
+// Pos returns position of the beginning of the file
+func (f *File) Pos() Position {
+	return Position{File: f.Name, Line: 1, Column: 1}
+}
+
+// Visitor is called by Walk for every node. if it returns nil, children of the node are not visited
+type Visitor interface {
+	Visit(node Node) Visitor
+}
+
+// Walk traverses syntax tree in source order: file, its lines, operands of every line
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *File:
+		for i := range n.Lines {
+			Walk(v, &n.Lines[i])
+		}
+	case *Line:
+		for i := range n.Operands {
+			Walk(v, &n.Operands[i])
+		}
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses syntax tree like Walk calling f for every node. children are skipped if f returns false
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Pos returns position of the first token of the line. blank lines start at column 1
+func (l *Line) Pos() Position {
+	for _, column := range []int{l.labelColumn, l.opcodeColumn, l.commentColumn} {
+		if column > 0 {
+			return l.position(column)
+		}
+	}
+	return l.position(1)
+}
+
+// LabelPos returns position of the label. column is 0 if there is no label
+func (l *Line) LabelPos() Position {
+	return l.position(l.labelColumn)
+}
+
+// OpcodePos returns position of the opcode. column is 0 if there is no opcode
+func (l *Line) OpcodePos() Position {
+	return l.position(l.opcodeColumn)
+}
+
+// CommentPos returns position of the semicolon starting the comment. column is 0 if there is no comment
+func (l *Line) CommentPos() Position {
+	return l.position(l.commentColumn)
+}
+
+func (l *Line) position(column int) Position {
+	return Position{File: l.pos.file, Line: l.pos.line, Column: column}
+}
+
+// Source returns the line as it was written
+func (l *Line) Source() string {
+	return l.source
+}
+
+// HasComment tells if the line has a comment. Comment is empty for a bare semicolon
+func (l *Line) HasComment() bool {
+	return l.hasComment
+}
+
+// LabelText returns the label as it was written. Label is always in upper case
+func (l *Line) LabelText() string {
+	return l.label(Formatter{Source: true})
+}
+
+// OperandKind tells which value an operand holds
+type OperandKind int
+
+const (
+	OperandInvalid OperandKind = iota
+	OperandRegister
+	OperandNumber
+	OperandString
+	OperandLabel
+	OperandExpression // condition of .IF
+)
+
+// Kind returns kind of the operand value
+func (o *Operand) Kind() OperandKind {
+	switch {
+	case o.isRegister():
+		return OperandRegister
+	case o.isNumber():
+		return OperandNumber
+	case o.isString():
+		return OperandString
+	case o.isLabel():
+		return OperandLabel
+	case o.isExpression():
+		return OperandExpression
+	}
+	return OperandInvalid
+}
+
+// Pos returns position of the operand in the source
+func (o *Operand) Pos() Position {
+	return o.pos
+}
+
+// Text returns the operand as it was written. it is empty for operands made by the assembler
+func (o *Operand) Text() string {
+	return o.text
+}
+
+// Register returns number of the register: RegR0-RegR7
+func (o *Operand) Register() (Word, bool) {
+	if !o.isRegister() {
+		return 0, false
+	}
+	return makeRegisterFromString(*o.register), true
+}
+
+// Number returns value of a numeric literal. negative numbers are in two's complement
+func (o *Operand) Number() (Word, bool) {
+	if !o.isNumber() {
+		return 0, false
+	}
+	return *o.number, true
+}
+
+// StringValue returns unquoted string with escapes replaced
+func (o *Operand) StringValue() (string, bool) {
+	if !o.isString() {
+		return "", false
+	}
+	return *o.string, true
+}
+
+// Label returns referenced label in upper case
+func (o *Operand) Label() (string, bool) {
+	if !o.isLabel() {
+		return "", false
+	}
+	return *o.label, true
+}
+
+// Expression returns condition of .IF as it was written
+func (o *Operand) Expression() (string, bool) {
+	if !o.isExpression() {
+		return "", false
+	}
+	return *o.expression, true
+}
@@ -0,0 +1,107 @@
+package lc3
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseSource(t *testing.T) {
+	const code = "; first\n" +
+		"loop\tadd r1, R1, x-1 ; again\n" +
+		"  .stringz \"a\\tb\"\n" +
+		".if DEFINED(X) && X > 2\n" +
+		".endif\n"
+
+	file, err := (&Assembler{}).ParseSource("main.asm", strings.NewReader(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Lines) != 6 {
+		t.Fatalf("expected 6 lines, got %d", len(file.Lines))
+	}
+
+	line := &file.Lines[1]
+	if line.Label != "LOOP" || line.LabelText() != "loop" || line.Opcode != "ADD" || line.Comment != " again" {
+		t.Errorf("unexpected line %+v", line)
+	}
+	if pos := line.OpcodePos(); pos.String() != "main.asm:2:6" {
+		t.Errorf("unexpected opcode position %s", pos)
+	}
+	if pos := line.CommentPos(); pos.Column != 22 {
+		t.Errorf("unexpected comment position %s", pos)
+	}
+
+	type operand struct {
+		kind   OperandKind
+		text   string
+		column int
+	}
+	expected := []operand{{OperandRegister, "r1", 10}, {OperandRegister, "R1", 14}, {OperandNumber, "x-1", 18}}
+	for i, e := range expected {
+		o := &line.Operands[i]
+		if o.Kind() != e.kind || o.Text() != e.text || o.Pos().Column != e.column {
+			t.Errorf("%d: unexpected operand %s of kind %d at %s", i, o.Text(), o.Kind(), o.Pos())
+		}
+	}
+	if r, ok := line.Operands[0].Register(); !ok || r != RegR1 {
+		t.Errorf("unexpected register %d", r)
+	}
+	if n, ok := line.Operands[2].Number(); !ok || n != MakeNegative(-1) {
+		t.Errorf("unexpected number %d", n)
+	}
+	if s, ok := file.Lines[2].Operands[0].StringValue(); !ok || s != "a\tb" || file.Lines[2].Operands[0].Text() != `"a\tb"` {
+		t.Errorf("unexpected string %q", s)
+	}
+	if e, ok := file.Lines[3].Operands[0].Expression(); !ok || e != "DEFINED(X) && X > 2" {
+		t.Errorf("unexpected expression %q", e)
+	}
+	if !file.Lines[0].HasComment() || file.Lines[0].Pos().Column != 1 || file.Lines[2].Pos().Column != 3 {
+		t.Errorf("unexpected comment line")
+	}
+
+	// count nodes and skip operands of .IF
+	lines, operands := 0, 0
+	Inspect(file, func(node Node) bool {
+		switch n := node.(type) {
+		case *Line:
+			lines++
+			return n.Opcode != stropIf
+		case *Operand:
+			operands++
+		}
+		return true
+	})
+	if lines != 6 || operands != 4 {
+		t.Errorf("unexpected number of visited nodes: %d lines, %d operands", lines, operands)
+	}
+}
+
+func Test_ParseSourceErrors(t *testing.T) {
+	_, err := (&Assembler{}).ParseSource("", strings.NewReader("add r1, r1, #1\nfoo bar\n"))
+	if err == nil || err.Error() != "opcode expected at line 2:5" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// half typed operands
+	for source, expected := range map[string]string{
+		"add r0, r0, #":  "digits expected in # at line 1:13",
+		"ld r0, x":       "digits expected in x at line 1:8",
+		"ld r0, X ; end": "digits expected in X at line 1:8",
+		"add r0, r0, #-": "digits expected in #- at line 1:13",
+		"ld r0, x-":      "digits expected in x- at line 1:8",
+		"add r0, , r1":   "operand expected at line 1:9",
+	} {
+		if _, err := (&Assembler{}).ParseSource("", strings.NewReader(source)); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", source, expected, err)
+		}
+	}
+
+	// text after .END is not parsed
+	file, err := (&Assembler{}).ParseSource("", strings.NewReader(".end\nfoo bar\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Lines[1].Source() != "foo bar" {
+		t.Errorf("unexpected line after .END %q", file.Lines[1].Source())
+	}
+}
@@ -0,0 +1,105 @@
+package lc3
+
+import (
+	"strings"
+	"testing"
+)
+
+// benchmark programs loop forever, an operation of a benchmark is one instruction
+var benchmarkPrograms = []struct {
+	name string
+	code string
+}{
+	{"alu", `
+			.orig x3000
+			loop	and r0, r0, #0
+					add r1, r0, #7
+					not r2, r1
+					add r0, r0, r2
+					and r3, r0, r1
+					add r1, r1, #-1
+					brp loop
+					brnzp loop
+			.end`},
+	{"memory", `
+			.orig x3000
+			loop	lea r1, src
+					lea r2, dst
+					and r3, r3, #0
+					add r3, r3, #8
+			copy	ldr r0, r1, #0
+					str r0, r2, #0
+					add r1, r1, #1
+					add r2, r2, #1
+					add r3, r3, #-1
+					brp copy
+					ldi r4, ptr
+					sti r4, ptr
+					ld r5, ptr
+					st r5, ptr
+					brnzp loop
+			ptr		.fill dst
+			src		.fill #1
+					.fill #2
+					.fill #3
+					.fill #4
+					.fill #5
+					.fill #6
+					.fill #7
+					.fill #8
+			dst		.fill #0
+			.end`},
+	{"calls", `
+			.orig x3000
+			loop	jsr double
+					lea r1, double
+					jsrr r1
+					brnzp loop
+			double	add r0, r0, r0
+					ret
+			.end`},
+}
+
+var benchmarkEngines = []Engine{EngineInterpreter, EngineDecodeCache, EngineBlocks}
+
+func newBenchmarkVM(b *testing.B, code string, engine Engine) *VM {
+	program, err := (&Assembler{}).Assemble("bench.asm", strings.NewReader(code))
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := program.NewVMWithEngine(engine)
+	m.Start()
+	return m
+}
+
+// executes instructions one by one
+func Benchmark_Step(b *testing.B) {
+	for _, engine := range benchmarkEngines {
+		for _, p := range benchmarkPrograms {
+			b.Run(engine.String()+"/"+p.name, func(b *testing.B) {
+				m := newBenchmarkVM(b, p.code, engine)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := m.Step(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// executes all instructions by one call
+func Benchmark_Run(b *testing.B) {
+	for _, engine := range benchmarkEngines {
+		for _, p := range benchmarkPrograms {
+			b.Run(engine.String()+"/"+p.name, func(b *testing.B) {
+				m := newBenchmarkVM(b, p.code, engine)
+				b.ResetTimer()
+				if err := m.Run(uint(b.N)); err != nil {
+					b.Fatal(err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package lc3
+
+// the longest compiled block. it bounds the search of blocks containing a written word
+const maxBlockLength = 64
+
+// compiled instruction. PC already points to the next instruction when it is called
+type operation func(m *VM) error
+
+// basic block: instructions executed one after another. the last one can jump
+type block struct {
+	operations []operation
+}
+
+// compiled blocks by the first address
+type blockCache struct {
+	blocks []*block
+	// the word belongs to a compiled block
+	compiled []bool
+	// a block is dropped by a write since the last check. the running block must not continue
+	dropped bool
+}
+
+func newBlockCache(memorySize int) *blockCache {
+	size := memorySize
+	if size > MrKbsr {
+		size = MrKbsr
+	}
+	return &blockCache{
+		blocks:   make([]*block, size),
+		compiled: make([]bool, size),
+	}
+}
+
+// drop blocks containing written address
+func (c *blockCache) invalidate(address Word) {
+	if int(address) >= len(c.compiled) || !c.compiled[address] {
+		return
+	}
+	for start := int(address); start >= 0 && start > int(address)-maxBlockLength; start-- {
+		if b := c.blocks[start]; b != nil && start+len(b.operations) > int(address) {
+			c.blocks[start] = nil
+			c.dropped = true
+		}
+	}
+}
+
+// checks if instruction ends a block
+func endsBlock(i *decoded) bool {
+	switch i.opcode {
+	case OpBr:
+		// BR without flags never jumps
+		return i.dr != 0
+	case OpJsr, OpJmp, OpTrap, OpRti, OpRes:
+		return true
+	}
+	return false
+}
+
+// compile block starting at address
+func (m *VM) compile(address Word) *block {
+	c := m.blocks
+	b := &block{}
+	for a := int(address); a < len(c.blocks) && len(b.operations) < maxBlockLength; a++ {
+		var i decoded
+		i.decode(m.memory[a])
+		b.operations = append(b.operations, compileInstruction(Word(a), &i))
+		c.compiled[a] = true
+		if endsBlock(&i) {
+			break
+		}
+	}
+	c.blocks[address] = b
+	return b
+}
+
+// Step of the block engine. executes the first instruction of the block at PC
+func (m *VM) stepBlock() error {
+	pc := m.registers[RegPC]
+	if int(pc) >= len(m.blocks.blocks) {
+		return m.stepDecoded()
+	}
+	b := m.blocks.blocks[pc]
+	if b == nil {
+		b = m.compile(pc)
+	}
+	m.instructionsExecuted++
+	m.registers[RegPC]++
+	return b.operations[0](m)
+}
+
+// execute blocks until the program stops, an instruction fails or limit instructions are executed.
+// zero limit means no limit. a block is left after an instruction writing into a compiled block.
+// interrupts aren't modelled, a check of pending interrupts would go between blocks
+func (m *VM) runBlocks(limit uint) error {
+	c := m.blocks
+	start := m.instructionsExecuted
+	for m.running {
+		rest := ^uint(0)
+		if limit > 0 {
+			if rest = limit - (m.instructionsExecuted - start); rest == 0 {
+				return nil
+			}
+		}
+
+		pc := m.registers[RegPC]
+		if int(pc) >= len(c.blocks) {
+			if err := m.stepDecoded(); err != nil {
+				return err
+			}
+			continue
+		}
+		b := c.blocks[pc]
+		if b == nil {
+			b = m.compile(pc)
+		}
+		operations := b.operations
+		if uint(len(operations)) > rest {
+			operations = operations[:rest]
+		}
+
+		c.dropped = false
+		for _, operation := range operations {
+			m.instructionsExecuted++
+			m.registers[RegPC]++
+			if err := operation(m); err != nil {
+				return err
+			}
+			if c.dropped || !m.running {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// compile instruction at address into a closure. PC-relative addresses are computed at compile time
+func compileInstruction(address Word, i *decoded) operation {
+	dr, sr1, sr2 := i.dr, i.sr1, i.sr2
+	next := address + 1
+	target := next + i.offset
+	offset := i.offset
+
+	switch i.opcode {
+	case OpBr:
+		flags := Word(i.dr)
+		return func(m *VM) error {
+			if flags&m.registers[RegCond] != 0 {
+				m.registers[RegPC] = target
+			}
+			return nil
+		}
+	case OpAdd:
+		if i.immediate {
+			return func(m *VM) error {
+				m.registers[dr] = m.registers[sr1] + offset
+				m.setFlags(m.registers[dr])
+				return nil
+			}
+		}
+		return func(m *VM) error {
+			m.registers[dr] = m.registers[sr1] + m.registers[sr2]
+			m.setFlags(m.registers[dr])
+			return nil
+		}
+	case OpAnd:
+		if i.immediate {
+			return func(m *VM) error {
+				m.registers[dr] = m.registers[sr1] & offset
+				m.setFlags(m.registers[dr])
+				return nil
+			}
+		}
+		return func(m *VM) error {
+			m.registers[dr] = m.registers[sr1] & m.registers[sr2]
+			m.setFlags(m.registers[dr])
+			return nil
+		}
+	case OpNot:
+		return func(m *VM) error {
+			m.registers[dr] = ^m.registers[sr1]
+			m.setFlags(m.registers[dr])
+			return nil
+		}
+	case OpLea:
+		return func(m *VM) error {
+			m.registers[dr] = target
+			m.setFlags(target)
+			return nil
+		}
+	case OpLd:
+		return func(m *VM) error {
+			m.registers[dr] = m.load(target)
+			m.setFlags(m.registers[dr])
+			return nil
+		}
+	case OpLdi:
+		return func(m *VM) error {
+			m.registers[dr] = m.load(m.load(target))
+			m.setFlags(m.registers[dr])
+			return nil
+		}
+	case OpLdr:
+		return func(m *VM) error {
+			m.registers[dr] = m.load(m.registers[sr1] + offset)
+			m.setFlags(m.registers[dr])
+			return nil
+		}
+	case OpSt:
+		return func(m *VM) error {
+			m.store(target, m.registers[dr])
+			return m.complete()
+		}
+	case OpSti:
+		return func(m *VM) error {
+			m.store(m.load(target), m.registers[dr])
+			return m.complete()
+		}
+	case OpStr:
+		return func(m *VM) error {
+			m.store(m.registers[sr1]+offset, m.registers[dr])
+			return m.complete()
+		}
+	case OpJsr:
+		if i.immediate {
+			return func(m *VM) error {
+				m.registers[RegR7] = next
+				m.registers[RegPC] = target
+				return nil
+			}
+		}
+		return func(m *VM) error {
+			m.registers[RegR7] = next
+			m.registers[RegPC] = m.registers[sr1]
+			return nil
+		}
+	case OpJmp:
+		return func(m *VM) error {
+			m.registers[RegPC] = m.registers[sr1]
+			return nil
+		}
+	case OpTrap:
+		return func(m *VM) error {
+			return m.trap(offset)
+		}
+	}
+
+	// RTI and the reserved opcode
+	return func(m *VM) error {
+		m.retry()
+		return ErrBadInstruction
+	}
+}
@@ -180,6 +180,14 @@ func NewStr(sr, baseR, offset6 Word) Word {
 	return ret
 }
 
+func NewRti() Word {
+	var ret Word = OpRti
+
+	ret <<= 12 // empty bits
+
+	return ret
+}
+
 func NewNot(dr, sr Word) Word {
 	var ret Word = OpNot
 
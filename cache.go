@@ -0,0 +1,235 @@
+package lc3
+
+// instruction decoded for execution
+type decoded struct {
+	opcode uint8
+	// DR, or SR of stores, or n, z, p flags of BR
+	dr uint8
+	// SR1 or base register
+	sr1 uint8
+	sr2 uint8
+	// ADD and AND use offset as imm5, JSR uses PCoffset11
+	immediate bool
+	// entry of the decode cache is filled
+	valid bool
+	// sign extended imm5, offset6, PCoffset9, PCoffset11 or trapvect8
+	offset Word
+}
+
+// fill fields of d by instruction
+func (d *decoded) decode(instruction Word) {
+	d.opcode = uint8(instruction >> 12)
+	d.dr = uint8(instruction>>9) & 7
+	d.sr1 = uint8(instruction>>6) & 7
+	d.sr2 = uint8(instruction) & 7
+	d.immediate = false
+	d.offset = 0
+	switch d.opcode {
+	case OpBr, OpLd, OpSt, OpLdi, OpSti, OpLea:
+		d.offset = signExtend(instruction&0x1FF, 9)
+	case OpAdd, OpAnd:
+		d.immediate = instruction&0x20 != 0
+		d.offset = signExtend(instruction&0x1F, 5)
+	case OpJsr:
+		d.immediate = instruction&0x800 != 0
+		d.offset = signExtend(instruction&0x7FF, 11)
+	case OpLdr, OpStr:
+		d.offset = signExtend(instruction&0x3F, 6)
+	case OpTrap:
+		d.offset = instruction & 0xFF
+	}
+	d.valid = true
+}
+
+// SetDecodeCache enables or disables the decode cache. with the cache every instruction is decoded once
+// and kept until its word is written. memory mapped device registers are never cached.
+// Run executes cached instructions in a loop without the overhead of Step
+func (m *VM) SetDecodeCache(enabled bool) {
+	if !enabled {
+		m.decoded = nil
+		return
+	}
+	if m.decoded == nil {
+		size := len(m.memory)
+		if size > MrKbsr {
+			size = MrKbsr
+		}
+		m.decoded = make([]decoded, size)
+	}
+}
+
+// drop cached instruction at written address
+func (m *VM) invalidate(address Word) {
+	if int(address) < len(m.decoded) {
+		m.decoded[address].valid = false
+	}
+}
+
+// Step executing instructions of the decode cache
+func (m *VM) stepDecoded() error {
+	m.instructionsExecuted++
+
+	// instruction at PC. device registers are read as usual
+	pc := m.registers[RegPC]
+	i := &m.current
+	if int(pc) < len(m.decoded) {
+		if i = &m.decoded[pc]; !i.valid {
+			i.decode(m.memory[pc])
+		}
+	} else {
+		i.decode(m.ReadMem(pc))
+	}
+
+	// advance PC immediately. all instructions use relative PC
+	m.registers[RegPC]++
+	return m.executeDecoded(i)
+}
+
+// execute instructions of the decode cache until the program stops, an instruction fails
+// or limit instructions are executed. zero limit means no limit. instructions which can't access
+// memory are executed in the loop, others by executeDecoded
+func (m *VM) runDecoded(limit uint) error {
+	r := &m.registers
+	cache := m.decoded
+	for n := uint(0); m.running && (limit == 0 || n < limit); n++ {
+		pc := r[RegPC]
+		if int(pc) >= len(cache) {
+			if err := m.stepDecoded(); err != nil {
+				return err
+			}
+			continue
+		}
+		i := &cache[pc]
+		if !i.valid {
+			i.decode(m.memory[pc])
+		}
+		m.instructionsExecuted++
+		pc++
+		r[RegPC] = pc
+
+		// registers are masked to let the compiler drop bounds checks
+		switch i.opcode {
+		case OpBr:
+			if Word(i.dr)&r[RegCond] != 0 {
+				r[RegPC] = pc + i.offset
+			}
+		case OpAdd:
+			value := r[i.sr1&7] + i.offset
+			if !i.immediate {
+				value = r[i.sr1&7] + r[i.sr2&7]
+			}
+			r[i.dr&7] = value
+			m.setFlags(value)
+		case OpAnd:
+			value := r[i.sr1&7] & i.offset
+			if !i.immediate {
+				value = r[i.sr1&7] & r[i.sr2&7]
+			}
+			r[i.dr&7] = value
+			m.setFlags(value)
+		case OpNot:
+			value := ^r[i.sr1&7]
+			r[i.dr&7] = value
+			m.setFlags(value)
+		case OpLea:
+			value := pc + i.offset
+			r[i.dr&7] = value
+			m.setFlags(value)
+		case OpLd:
+			value := m.load(pc + i.offset)
+			r[i.dr&7] = value
+			m.setFlags(value)
+		case OpLdr:
+			value := m.load(r[i.sr1&7] + i.offset)
+			r[i.dr&7] = value
+			m.setFlags(value)
+		case OpJsr:
+			// R7 is written before JSRR reads the base register
+			r[RegR7] = pc
+			if i.immediate {
+				r[RegPC] = pc + i.offset
+			} else {
+				r[RegPC] = r[i.sr1&7]
+			}
+		case OpJmp:
+			r[RegPC] = r[i.sr1&7]
+		default:
+			if err := m.executeDecoded(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// execute decoded instruction. PC already points to the next instruction
+func (m *VM) executeDecoded(i *decoded) error {
+	switch i.opcode {
+	case OpBr:
+		if Word(i.dr)&m.registers[RegCond] != 0 {
+			m.registers[RegPC] += i.offset
+		}
+		return nil
+	case OpAdd:
+		if i.immediate {
+			m.registers[i.dr] = m.registers[i.sr1] + i.offset
+		} else {
+			m.registers[i.dr] = m.registers[i.sr1] + m.registers[i.sr2]
+		}
+		m.setFlags(m.registers[i.dr])
+		return nil
+	case OpLd:
+		m.registers[i.dr] = m.load(m.registers[RegPC] + i.offset)
+		m.setFlags(m.registers[i.dr])
+		return nil
+	case OpSt:
+		m.store(m.registers[RegPC]+i.offset, m.registers[i.dr])
+	case OpJsr:
+		m.registers[RegR7] = m.registers[RegPC]
+		if i.immediate {
+			m.registers[RegPC] += i.offset
+		} else {
+			m.registers[RegPC] = m.registers[i.sr1]
+		}
+		return nil
+	case OpAnd:
+		if i.immediate {
+			m.registers[i.dr] = m.registers[i.sr1] & i.offset
+		} else {
+			m.registers[i.dr] = m.registers[i.sr1] & m.registers[i.sr2]
+		}
+		m.setFlags(m.registers[i.dr])
+		return nil
+	case OpLdr:
+		m.registers[i.dr] = m.load(m.registers[i.sr1] + i.offset)
+		m.setFlags(m.registers[i.dr])
+		return nil
+	case OpStr:
+		m.store(m.registers[i.sr1]+i.offset, m.registers[i.dr])
+	case OpRti, OpRes:
+		m.retry()
+		return ErrBadInstruction
+	case OpNot:
+		m.registers[i.dr] = ^m.registers[i.sr1]
+		m.setFlags(m.registers[i.dr])
+		return nil
+	case OpLdi:
+		m.registers[i.dr] = m.load(m.load(m.registers[RegPC] + i.offset))
+		m.setFlags(m.registers[i.dr])
+		return nil
+	case OpSti:
+		m.store(m.load(m.registers[RegPC]+i.offset), m.registers[i.dr])
+	case OpJmp:
+		m.registers[RegPC] = m.registers[i.sr1]
+		return nil
+	case OpLea:
+		m.registers[i.dr] = m.registers[RegPC] + i.offset
+		m.setFlags(m.registers[i.dr])
+		return nil
+	case OpTrap:
+		return m.trap(i.offset)
+	}
+
+	// only stores can write into protected code
+	return m.complete()
+}
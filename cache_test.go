@@ -0,0 +1,65 @@
+package lc3
+
+import (
+	"strings"
+	"testing"
+)
+
+// overwrites ADD in the loop by NOP, then by the ADD again
+const selfModifyingProgram = `
+			.orig x3000
+			loop	add r1, r1, #1
+					ld r0, nop
+					st r0, loop
+					add r2, r2, #1
+					ld r0, inc
+					st r0, loop
+					brnzp loop
+			nop		.fill x0000
+			inc		add r1, r1, #1
+			.end`
+
+func Test_DecodeCache(t *testing.T) {
+	programs := append(benchmarkPrograms, struct {
+		name string
+		code string
+	}{"self-modifying", selfModifyingProgram})
+
+	for _, p := range programs {
+		program, err := (&Assembler{}).Assemble(p.name+".asm", strings.NewReader(p.code))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := program.NewVM()
+		expected.Start()
+		cached := program.NewVM()
+		cached.SetDecodeCache(true)
+		cached.Start()
+
+		for i := 0; i < 1000; i++ {
+			errExpected, errCached := expected.Step(), cached.Step()
+			if errExpected != errCached {
+				t.Fatalf("%s: step %d: expected error %v, got %v", p.name, i, errExpected, errCached)
+			}
+			if expected.registers != cached.registers {
+				t.Fatalf("%s: step %d: expected registers %v, got %v", p.name, i, expected.registers, cached.registers)
+			}
+		}
+	}
+}
+
+func Test_DecodeCacheWriteMem(t *testing.T) {
+	m := NewVM(WordMax + 1)
+	m.SetDecodeCache(true)
+	m.WriteMem(0x3000, NewAddImmediate(RegR0, RegR0, 1))
+	m.SetOrigin(0x3000)
+	m.Start()
+	m.Step()
+
+	m.WriteMem(0x3000, NewAddImmediate(RegR0, RegR0, 2))
+	m.SetRegister(RegPC, 0x3000)
+	m.Step()
+	if m.GetRegister(RegR0) != 3 {
+		t.Errorf("expected r0 = 3, got %d", m.GetRegister(RegR0))
+	}
+}
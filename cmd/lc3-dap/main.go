@@ -0,0 +1,20 @@
+// Command lc3-dap is a Debug Adapter Protocol server for LC-3 programs talking over stdin and stdout.
+//
+// The launch request takes path of the .asm file in "program", "stopOnEntry" and directories for
+// .INCLUDE files in "includePaths". Program output goes to the debug console, text typed in the console
+// while the program runs is its input.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pavel-krush/lc3/dap"
+)
+
+func main() {
+	if err := dap.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,103 @@
+// Command lc3-gdbserver runs an LC-3 program under GDB remote serial protocol.
+//
+// The program is stopped before its first instruction until a client continues it.
+// I/O traps use stdin and stdout of the server.
+//
+//	lc3-gdbserver [-listen host:port | -unix path] [-I dir]... program.asm|program.obj
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/debugger"
+	"github.com/pavel-krush/lc3/gdbstub"
+)
+
+type includePaths []string
+
+func (p *includePaths) String() string     { return strings.Join(*p, ",") }
+func (p *includePaths) Set(v string) error { *p = append(*p, v); return nil }
+
+var (
+	listen   = flag.String("listen", "localhost:1234", "TCP address to listen on")
+	unix     = flag.String("unix", "", "path of Unix socket to listen on instead of TCP")
+	includes includePaths
+)
+
+// console reading stdin and writing stdout. reading waits for input
+type console struct {
+	in  *bufio.Reader
+	out *bufio.Writer
+}
+
+func (c console) ReadChar() (byte, bool) {
+	c.out.Flush()
+	char, err := c.in.ReadByte()
+	return char, err == nil
+}
+
+func (c console) WriteChar(char byte) {
+	c.out.WriteByte(char)
+	if char == '\n' {
+		c.out.Flush()
+	}
+}
+
+func main() {
+	flag.Var(&includes, "I", "directory to search for .INCLUDE files. can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lc3-gdbserver [flags] program.asm|program.obj\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	program, err := load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	d := debugger.New(program)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	d.VM.Console = console{bufio.NewReader(os.Stdin), out}
+
+	network, address := "tcp", *listen
+	if *unix != "" {
+		network, address = "unix", *unix
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "listening on %s\n", l.Addr())
+
+	if err := gdbstub.NewServer(d).Serve(l); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func load(name string) (*lc3.Program, error) {
+	if strings.EqualFold(filepath.Ext(name), ".obj") {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return lc3.ReadObj(file)
+	}
+	return (&lc3.Assembler{IncludePaths: includes}).AssembleFile(name)
+}
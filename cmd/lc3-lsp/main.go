@@ -0,0 +1,18 @@
+// Command lc3-lsp is a Language Server Protocol server for LC-3 assembly talking over stdin and stdout.
+//
+// Directories for .INCLUDE files can be passed by the client in initializationOptions.includePaths.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pavel-krush/lc3/lsp"
+)
+
+func main() {
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// warnings and if they are enabled by default
+var knownWarnings = map[string]bool{
+	"no-halt": true,
+	"unused":  false,
+}
+
+type diagnostic struct {
+	File string `json:"file"`
+	// zero if unknown
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+	// error or warning
+	Severity string `json:"severity"`
+	// name of the warning
+	Warning string `json:"warning,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d diagnostic) String() string {
+	position := d.File
+	if d.Line > 0 {
+		position += fmt.Sprintf(":%d", d.Line)
+	}
+	if d.Column > 0 {
+		position += fmt.Sprintf(":%d", d.Column)
+	}
+	ret := fmt.Sprintf("%s: %s: %s", position, d.Severity, d.Message)
+	if d.Warning != "" {
+		ret += fmt.Sprintf(" [-W %s]", d.Warning)
+	}
+	return ret
+}
+
+// print diagnostic to stderr unless JSON is printed
+func printDiagnostic(d diagnostic) {
+	if !*jsonMode {
+		fmt.Fprintln(os.Stderr, d)
+	}
+}
+
+// parse -W flags into enabled warnings
+func parseWarnings(flags []string) (map[string]bool, bool, error) {
+	enabled := make(map[string]bool)
+	for name, on := range knownWarnings {
+		enabled[name] = on
+	}
+	werror := false
+
+	for _, list := range flags {
+		for _, w := range strings.Split(list, ",") {
+			switch {
+			case w == "all" || w == "none":
+				for name := range enabled {
+					enabled[name] = w == "all"
+				}
+			case w == "error":
+				werror = true
+			case w == "no-error":
+				werror = false
+			default:
+				on := true
+				if _, ok := knownWarnings[w]; !ok && strings.HasPrefix(w, "no-") {
+					on, w = false, w[len("no-"):]
+				}
+				if _, ok := knownWarnings[w]; !ok {
+					return nil, false, fmt.Errorf("unknown warning %s", w)
+				}
+				enabled[w] = on
+			}
+		}
+	}
+	return enabled, werror, nil
+}
+
+// diagnostic at the position of assembler error
+func errorDiagnostic(name string, err error) diagnostic {
+	ret := diagnostic{File: name, Severity: severityError, Message: err.Error()}
+	var e *lc3.Error
+	if errors.As(err, &e) {
+		if e.Pos.File != "" {
+			ret.File = e.Pos.File
+		}
+		ret.Line, ret.Column, ret.Message = e.Pos.Line, e.Pos.Column, e.Msg
+		if trace := e.Trace(); trace != "" {
+			ret.Message += " " + trace
+		}
+	}
+	return ret
+}
+
+// warnings of assembled program
+func check(assembler *lc3.Assembler, name string, program *lc3.Program, enabled map[string]bool) []diagnostic {
+	var ret []diagnostic
+
+	if enabled["no-halt"] && !hasHalt(program) {
+		ret = append(ret, diagnostic{File: name, Severity: severityWarning, Warning: "no-halt", Message: "program has no HALT instruction"})
+	}
+	if enabled["unused"] {
+		ret = append(ret, unusedLabels(assembler, name, program)...)
+	}
+	return ret
+}
+
+func hasHalt(program *lc3.Program) bool {
+	for _, line := range program.Lines {
+		for i := lc3.Word(0); line.Code && i < line.Size; i++ {
+			if program.WordAt(line.Address+i) == 0xF000|lc3.TrapVectHalt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// labels of the program which are not referenced from any of its files
+func unusedLabels(assembler *lc3.Assembler, name string, program *lc3.Program) []diagnostic {
+	files := []string{name}
+	seen := map[string]bool{name: true}
+	for _, line := range program.Lines {
+		if !seen[line.File] {
+			seen[line.File] = true
+			files = append(files, line.File)
+		}
+	}
+
+	var definitions []lc3.LabelReference
+	referenced := make(map[string]bool)
+	for _, file := range files {
+		source, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		parsed, err := assembler.ParseSource(file, source)
+		source.Close()
+		if err != nil {
+			continue
+		}
+		for _, ref := range parsed.LabelReferences() {
+			if ref.Definition {
+				definitions = append(definitions, ref)
+			} else {
+				referenced[ref.Name] = true
+			}
+		}
+	}
+
+	var ret []diagnostic
+	for _, def := range definitions {
+		// macro and anonymous labels, labels of inactive conditional blocks
+		if _, ok := program.Symbol(def.Name); !ok || strings.Contains(def.Name, "@") || referenced[def.Name] {
+			continue
+		}
+		ret = append(ret, diagnostic{
+			File:     def.Pos.File,
+			Line:     def.Pos.Line,
+			Column:   def.Pos.Column,
+			Severity: severityWarning,
+			Warning:  "unused",
+			Message:  fmt.Sprintf("label %s is never referenced", def.Text),
+		})
+	}
+	return ret
+}
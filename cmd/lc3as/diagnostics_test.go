@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+func Test_ParseWarnings(t *testing.T) {
+	type testCase struct {
+		flags   []string
+		enabled map[string]bool
+		werror  bool
+		error   string
+	}
+
+	testData := []testCase{
+		{nil, map[string]bool{"no-halt": true, "unused": false}, false, ""},
+		{[]string{"unused"}, map[string]bool{"no-halt": true, "unused": true}, false, ""},
+		{[]string{"no-no-halt"}, map[string]bool{"no-halt": false, "unused": false}, false, ""},
+		{[]string{"all,no-unused"}, map[string]bool{"no-halt": true, "unused": false}, false, ""},
+		{[]string{"none", "unused"}, map[string]bool{"no-halt": false, "unused": true}, false, ""},
+		{[]string{"error"}, map[string]bool{"no-halt": true, "unused": false}, true, ""},
+		{[]string{"error", "no-error"}, map[string]bool{"no-halt": true, "unused": false}, false, ""},
+		{[]string{"unused,nope"}, nil, false, "unknown warning nope"},
+		{[]string{"no-nope"}, nil, false, "unknown warning nope"},
+	}
+
+	for i, test := range testData {
+		enabled, werror, err := parseWarnings(test.flags)
+		if test.error != "" {
+			if err == nil || err.Error() != test.error {
+				t.Errorf("%d: expected error %q, got %v", i, test.error, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(enabled, test.enabled) || werror != test.werror {
+			t.Errorf("%d: expected %v with werror %v, got %v with werror %v", i, test.enabled, test.werror, enabled, werror)
+		}
+	}
+}
+
+func Test_ErrorDiagnostic(t *testing.T) {
+	type testCase struct {
+		err      error
+		expected diagnostic
+	}
+
+	testData := []testCase{
+		{
+			&lc3.Error{Pos: lc3.Position{File: "a.asm", Line: 2}, Msg: "unknown label FOO"},
+			diagnostic{File: "a.asm", Line: 2, Message: "unknown label FOO"},
+		},
+		{
+			&lc3.Error{Pos: lc3.Position{File: "my lib/io.asm", Line: 7, Column: 12}, Msg: "label AT at wrong place"},
+			diagnostic{File: "my lib/io.asm", Line: 7, Column: 12, Message: "label AT at wrong place"},
+		},
+		{
+			&lc3.Error{
+				Pos:   lc3.Position{File: "b.asm", Line: 2},
+				Msg:   "unknown opcode signature",
+				Calls: []lc3.MacroCall{{Macro: "M", Pos: lc3.Position{File: "b.asm", Line: 5}}},
+			},
+			diagnostic{File: "b.asm", Line: 2, Message: "unknown opcode signature in macro M, expanded at b.asm:5"},
+		},
+		{
+			&lc3.Error{Pos: lc3.Position{Line: 3}, Msg: "opcode expected"},
+			diagnostic{File: "main.asm", Line: 3, Message: "opcode expected"},
+		},
+		{errors.New("open missing.asm at x: no such file"), diagnostic{File: "main.asm", Message: "open missing.asm at x: no such file"}},
+	}
+
+	for i, test := range testData {
+		test.expected.Severity = severityError
+		if d := errorDiagnostic("main.asm", test.err); d != test.expected {
+			t.Errorf("%d: expected %+v, got %+v", i, test.expected, d)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Command lc3as assembles LC-3 programs and can replace the classic lc3as in course Makefiles.
+//
+// For every input file.asm it writes file.obj and file.sym next to it and prints the pass report
+// of the classic assembler. Listing, lc3convert .hex and .bin files are written on request.
+//
+//	lc3as [-o output] [-I dir]... [-D name[=value]]... [-W warning]... [-lst] [-hex] [-bin] [-sym=false] [-json] file.asm...
+//
+// -o sets output name of a single input, an extension is replaced by the ones of output files.
+// -D defines a symbol for .IF and .IFDEF, its value is 1 if omitted. negative values stay negative in conditions.
+//
+// Warnings are enabled with -W name and disabled with -W no-name. -W all and -W none switch all of them,
+// -W error treats warnings as errors. Known warnings:
+//
+//	no-halt  the program has no HALT instruction (enabled by default)
+//	unused   a label is never referenced
+//
+// With -json diagnostics are printed to stdout as a JSON array instead of the report.
+// Exit status is 0 on success, 1 if there are errors and 2 on wrong usage.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pkg/errors"
+)
+
+type listFlag []string
+
+func (p *listFlag) String() string     { return strings.Join(*p, ",") }
+func (p *listFlag) Set(v string) error { *p = append(*p, v); return nil }
+
+var (
+	output   = flag.String("o", "", "output `file` name. only one input is allowed")
+	listing  = flag.Bool("lst", false, "write listing to .lst file")
+	hex      = flag.Bool("hex", false, "write lc3convert hex text to .hex file")
+	bin      = flag.Bool("bin", false, "write lc3convert binary text to .bin file")
+	symbols  = flag.Bool("sym", true, "write symbol table to .sym file")
+	jsonMode = flag.Bool("json", false, "print diagnostics as JSON to stdout")
+	includes listFlag
+	defines  listFlag
+	warnings listFlag
+)
+
+func main() {
+	flag.Var(&includes, "I", "`directory` to search for .INCLUDE files. can be repeated")
+	flag.Var(&defines, "D", "define symbol `name[=value]` for .IF and .IFDEF. can be repeated")
+	flag.Var(&warnings, "W", "enable `warning`, disable it with no- prefix. all, none and error are accepted too. can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lc3as [flags] file.asm...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 || *output != "" && flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	assembler, err := newAssembler()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	enabled, werror, err := parseWarnings(warnings)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	status := 0
+	all := []diagnostic{}
+	for _, name := range flag.Args() {
+		diagnostics := assemble(assembler, name, enabled, werror)
+		all = append(all, diagnostics...)
+		if failed(diagnostics, werror) {
+			status = 1
+		}
+	}
+
+	if *jsonMode {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(all); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	os.Exit(status)
+}
+
+func newAssembler() (*lc3.Assembler, error) {
+	ret := &lc3.Assembler{IncludePaths: includes, Defines: make(map[string]int)}
+	for _, define := range defines {
+		name, value := define, "1"
+		if i := strings.Index(define, "="); i >= 0 {
+			name, value = define[:i], define[i+1:]
+		}
+		if name == "" {
+			return nil, errors.Errorf("invalid define %q", define)
+		}
+		number, err := lc3.ParseInt(value)
+		if err != nil {
+			return nil, errors.Errorf("invalid value of define %q", define)
+		}
+		ret.Defines[name] = number
+	}
+	return ret, nil
+}
+
+// assemble file, write outputs and print the report. diagnostics are returned
+func assemble(assembler *lc3.Assembler, name string, enabled map[string]bool, werror bool) []diagnostic {
+	report := func(format string, args ...interface{}) {
+		if !*jsonMode {
+			fmt.Printf(format, args...)
+		}
+	}
+
+	report("STARTING PASS 1\n")
+	program, err := assembler.AssembleFile(name)
+	if err != nil {
+		d := errorDiagnostic(name, err)
+		printDiagnostic(d)
+		report("1 errors found in first pass.\n")
+		return []diagnostic{d}
+	}
+	report("0 errors found in first pass.\n")
+	report("STARTING PASS 2\n")
+
+	diagnostics := check(assembler, name, program, enabled)
+	for i := range diagnostics {
+		if werror {
+			diagnostics[i].Severity = severityError
+		}
+		printDiagnostic(diagnostics[i])
+	}
+	if failed(diagnostics, werror) {
+		report("%d errors found in second pass.\n", len(diagnostics))
+		return diagnostics
+	}
+	report("0 errors found in second pass.\n")
+
+	if err := writeOutputs(program, outputBase(name)); err != nil {
+		d := diagnostic{File: name, Severity: severityError, Message: err.Error()}
+		printDiagnostic(d)
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+func failed(diagnostics []diagnostic, werror bool) bool {
+	for _, d := range diagnostics {
+		if d.Severity == severityError || werror {
+			return true
+		}
+	}
+	return false
+}
+
+// output file name without extension
+func outputBase(input string) string {
+	name := input
+	if *output != "" {
+		name = *output
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func writeOutputs(program *lc3.Program, base string) error {
+	outputs := []struct {
+		enabled bool
+		ext     string
+		write   func(*os.File) error
+	}{
+		{true, ".obj", func(f *os.File) error { return program.WriteObj(f) }},
+		{*symbols, ".sym", func(f *os.File) error { return program.WriteSymbols(f) }},
+		{*listing, ".lst", func(f *os.File) error { return program.WriteListing(f) }},
+		{*hex, ".hex", func(f *os.File) error { return program.WriteHex(f) }},
+		{*bin, ".bin", func(f *os.File) error { return program.WriteBin(f) }},
+	}
+
+	for _, o := range outputs {
+		if !o.enabled {
+			continue
+		}
+		file, err := os.Create(base + o.ext)
+		if err != nil {
+			return err
+		}
+		err = o.write(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return errors.Wrap(err, base+o.ext)
+		}
+	}
+	return nil
+}
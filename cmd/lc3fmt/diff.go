@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lines of context around changes
+const diffContext = 3
+
+type editKind int
+
+const (
+	editKeep editKind = iota
+	editDelete
+	editInsert
+)
+
+// line keeps its line break, only the last line of a file can have none
+type edit struct {
+	kind editKind
+	line string
+}
+
+// unifiedDiff returns difference between a and b in unified format or empty string if they are equal
+func unifiedDiff(name string, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	var ret strings.Builder
+	fmt.Fprintf(&ret, "--- %s.orig\n+++ %s\n", name, name)
+
+	// line numbers of the current edit in a and b
+	aLine, bLine := 1, 1
+	for i := 0; i < len(edits); {
+		if edits[i].kind == editKeep {
+			i++
+			aLine++
+			bLine++
+			continue
+		}
+
+		// hunk starts with context before the change and ends when there are
+		// more than 2*diffContext unchanged lines in a row
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(edits) {
+			if edits[end].kind != editKeep {
+				end++
+				continue
+			}
+			keep := end
+			for keep < len(edits) && edits[keep].kind == editKeep {
+				keep++
+			}
+			if keep == len(edits) || keep-end > 2*diffContext {
+				end += minInt(diffContext, keep-end)
+				break
+			}
+			end = keep
+		}
+
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		aCount, bCount := 0, 0
+		var hunk strings.Builder
+		for _, e := range edits[start:end] {
+			switch e.kind {
+			case editKeep:
+				hunk.WriteString(" ")
+				aCount++
+				bCount++
+			case editDelete:
+				hunk.WriteString("-")
+				aCount++
+			case editInsert:
+				hunk.WriteString("+")
+				bCount++
+			}
+			hunk.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				hunk.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		fmt.Fprintf(&ret, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		ret.WriteString(hunk.String())
+
+		aLine, bLine = aStart+aCount, bStart+bCount
+		i = end
+	}
+
+	return ret.String()
+}
+
+// range of lines in hunk header like diff -u: the line before an empty range, no count of one line
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// lines keep their line breaks, so a missing line break at the end of file is a difference
+func splitLines(s string) []string {
+	ret := strings.SplitAfter(s, "\n")
+	if ret[len(ret)-1] == "" {
+		ret = ret[:len(ret)-1]
+	}
+	return ret
+}
+
+// shortest edit script by the longest common subsequence
+func diffLines(a, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = maxInt(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ret []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ret = append(ret, edit{editKeep, a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			ret = append(ret, edit{editDelete, a[i]})
+			i++
+		default:
+			ret = append(ret, edit{editInsert, b[j]})
+			j++
+		}
+	}
+	return ret
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
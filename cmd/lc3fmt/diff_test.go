@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_DiffLines(t *testing.T) {
+	type testCase struct {
+		a, b     string
+		expected []edit
+	}
+
+	testData := []testCase{
+		{"a\nb\n", "a\nb\n", []edit{{editKeep, "a\n"}, {editKeep, "b\n"}}},
+		{"a\nb\nc\n", "a\nc\n", []edit{{editKeep, "a\n"}, {editDelete, "b\n"}, {editKeep, "c\n"}}},
+		{"a\nc\n", "a\nb\nc\n", []edit{{editKeep, "a\n"}, {editInsert, "b\n"}, {editKeep, "c\n"}}},
+		{"a\nb\n", "a\nx\n", []edit{{editKeep, "a\n"}, {editDelete, "b\n"}, {editInsert, "x\n"}}},
+		{"a\nb", "a\nb\n", []edit{{editKeep, "a\n"}, {editDelete, "b"}, {editInsert, "b\n"}}},
+		{"", "a\n", []edit{{editInsert, "a\n"}}},
+	}
+
+	for i, test := range testData {
+		if edits := diffLines(splitLines(test.a), splitLines(test.b)); !reflect.DeepEqual(edits, test.expected) {
+			t.Errorf("%d: expected %v, got %v", i, test.expected, edits)
+		}
+	}
+}
+
+// expected hunks are printed by diff -u
+func Test_UnifiedDiff(t *testing.T) {
+	// lines 1 to 20 with some lines replaced
+	numbers := func(replace map[int]string) string {
+		var b strings.Builder
+		for i := 1; i <= 20; i++ {
+			if line, ok := replace[i]; ok {
+				b.WriteString(line + "\n")
+			} else {
+				fmt.Fprintf(&b, "%d\n", i)
+			}
+		}
+		return b.String()
+	}
+
+	type testCase struct {
+		a, b     string
+		expected string
+	}
+
+	testData := []testCase{
+		{"a\n", "a\n", ""},
+		// changes 6 lines apart share a hunk
+		{numbers(nil), numbers(map[int]string{3: "x", 10: "y"}),
+			"@@ -1,13 +1,13 @@\n 1\n 2\n-3\n+x\n 4\n 5\n 6\n 7\n 8\n 9\n-10\n+y\n 11\n 12\n 13\n"},
+		// changes 7 lines apart are in separate hunks
+		{numbers(nil), numbers(map[int]string{3: "x", 11: "y"}),
+			"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+x\n 4\n 5\n 6\n@@ -8,7 +8,7 @@\n 8\n 9\n 10\n-11\n+y\n 12\n 13\n 14\n"},
+		// context is cut at the end of file
+		{"a\nb\nc\nd\ne\n", "a\nb\nc\nd\ne\nf\n", "@@ -3,3 +3,4 @@\n c\n d\n e\n+f\n"},
+		{"a\nb\nc\n", "a\nc\n", "@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{"a\nb", "a\nb\n", "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{"a\n", "b", "@@ -1 +1 @@\n-a\n+b\n\\ No newline at end of file\n"},
+		{"", "a\nb\n", "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+	}
+
+	for i, test := range testData {
+		expected := test.expected
+		if expected != "" {
+			expected = "--- main.asm.orig\n+++ main.asm\n" + expected
+		}
+		if diff := unifiedDiff("main.asm", test.a, test.b); diff != expected {
+			t.Errorf("%d: expected\n%s\ngot\n%s", i, expected, diff)
+		}
+	}
+}
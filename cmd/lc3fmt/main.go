@@ -0,0 +1,149 @@
+// Command lc3fmt formats LC-3 assembly sources.
+//
+// Without flags formatted sources are printed to stdout. Without files stdin is formatted.
+//
+//	lc3fmt [-w | -d | -l] [-lower] [-literals keep|hex|dec] [-I dir]... [file...]
+//
+// With -literals hex or dec immediates and offsets are printed as signed numbers. Operands of .ORIG, .FILL,
+// .BLKW and TRAP are addresses, words and vectors, they are printed unsigned unless written with a minus sign.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+type includePaths []string
+
+func (p *includePaths) String() string     { return strings.Join(*p, ",") }
+func (p *includePaths) Set(v string) error { *p = append(*p, v); return nil }
+
+var (
+	write    = flag.Bool("w", false, "write result to the source file instead of stdout")
+	diff     = flag.Bool("d", false, "print diffs instead of formatted sources. exit status is 1 if any file is not formatted")
+	list     = flag.Bool("l", false, "list files whose formatting differs")
+	lower    = flag.Bool("lower", false, "print mnemonics and registers in lower case")
+	literals = flag.String("literals", "keep", "style of numeric literals: keep (the base they are written in), hex or dec")
+	includes includePaths
+)
+
+func main() {
+	flag.Var(&includes, "I", "directory to search for .INCLUDE files. can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lc3fmt [flags] [file...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	formatter, err := newFormatter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	assembler := &lc3.Assembler{IncludePaths: includes}
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "cannot use -w with standard input")
+			os.Exit(2)
+		}
+		differs, err := process(assembler, formatter, "<stdin>", "", os.Stdin)
+		exit(err, differs)
+	}
+
+	status := 0
+	for _, name := range flag.Args() {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		differs, err := process(assembler, formatter, name, name, file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+		} else if differs && *diff && status == 0 {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+func newFormatter() (lc3.Formatter, error) {
+	ret := lc3.Formatter{UpperHex: true, Lowercase: *lower, Source: true}
+	switch *literals {
+	case "keep":
+		ret.SourceBase = true
+	case "hex":
+		ret.Mode = lc3.ModeHex
+		ret.Signed = true
+	case "dec":
+		ret.Mode = lc3.ModeDec
+		ret.Signed = true
+	default:
+		return ret, fmt.Errorf("unknown literal style %q", *literals)
+	}
+	return ret, nil
+}
+
+func exit(err error, differs bool) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if differs && *diff {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// format one source. name is printed in messages, path is used to resolve .INCLUDE and to write the result
+func process(assembler *lc3.Assembler, formatter lc3.Formatter, name string, path string, reader io.Reader) (bool, error) {
+	source, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return false, err
+	}
+
+	file, err := assembler.ParseSource(path, bytes.NewReader(source))
+	if err != nil {
+		return false, err
+	}
+
+	var formatted bytes.Buffer
+	if err := lc3.FormatSource(&formatted, file, formatter); err != nil {
+		return false, err
+	}
+
+	differs := !bytes.Equal(source, formatted.Bytes())
+
+	if *list && differs {
+		fmt.Println(name)
+	}
+	if *diff && differs {
+		os.Stdout.WriteString(unifiedDiff(name, string(source), formatted.String()))
+	}
+	if *write && differs {
+		info, err := os.Stat(path)
+		if err != nil {
+			return differs, err
+		}
+		if err := ioutil.WriteFile(path, formatted.Bytes(), info.Mode().Perm()); err != nil {
+			return differs, err
+		}
+	}
+	if !*list && !*diff && !*write {
+		os.Stdout.Write(formatted.Bytes())
+	}
+
+	return differs, nil
+}
@@ -0,0 +1,105 @@
+// Command lc3grade grades LC-3 submissions against a rubric, see grader.Rubric for its format.
+//
+//	lc3grade -rubric rubric.json [-j workers] [-format json|markdown] [-o dir] submission.asm...
+//
+// Reports are printed to stdout, a JSON array or Markdown documents one after another.
+// With -o every submission gets its own report file in dir named after the submission path.
+//
+// Exit status is 0 when all submissions are graded, even with zero score, and 2 on wrong usage or bad rubric.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pavel-krush/lc3/grader"
+)
+
+var (
+	rubricFile = flag.String("rubric", "", "rubric `file` in JSON")
+	workers    = flag.Int("j", runtime.NumCPU(), "number of submissions graded in parallel")
+	format     = flag.String("format", "json", "report format: json or markdown")
+	outputDir  = flag.String("o", "", "write a report file for every submission into `dir`")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lc3grade -rubric rubric.json [flags] submission.asm...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *rubricFile == "" || flag.NArg() == 0 || *format != "json" && *format != "markdown" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rubric, err := grader.ReadRubricFile(*rubricFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	reports := rubric.GradeAll(flag.Args(), *workers)
+	if *outputDir != "" {
+		err = writeFiles(reports)
+	} else {
+		err = writeStdout(reports)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func writeStdout(reports []*grader.Report) error {
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	}
+	for i, r := range reports {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := r.WriteMarkdown(os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFiles(reports []*grader.Report) error {
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		return err
+	}
+	ext := ".json"
+	if *format == "markdown" {
+		ext = ".md"
+	}
+
+	for _, r := range reports {
+		name := strings.TrimSuffix(filepath.ToSlash(filepath.Clean(r.Submission)), filepath.Ext(r.Submission))
+		name = strings.NewReplacer("/", "_", "..", "_").Replace(strings.TrimPrefix(name, "/"))
+		file, err := os.Create(filepath.Join(*outputDir, name+ext))
+		if err != nil {
+			return err
+		}
+		if *format == "json" {
+			err = r.WriteJSON(file)
+		} else {
+			err = r.WriteMarkdown(file)
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// console connects the VM to stdin and stdout. input is read in background into the keyboard buffer of the VM,
+// so programs can use GETC and IN or poll the keyboard status register
+type console struct {
+	keyboard chan lc3.Word
+	// closed after keyboard at the end of input
+	eof chan struct{}
+	out *bufio.Writer
+	// the program polls the keyboard after the end of input
+	starved bool
+}
+
+// attach console to started vm. keyboard buffer is closed at the end of input
+func attach(vm *lc3.VM, in io.Reader, out io.Writer) *console {
+	ret := &console{keyboard: vm.Stdin, eof: make(chan struct{}), out: bufio.NewWriter(out)}
+	vm.Console = ret
+	vm.AddObserver(ret)
+
+	go func() {
+		defer close(ret.eof)
+		defer close(ret.keyboard)
+		r := bufio.NewReader(in)
+		for {
+			char, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			ret.keyboard <- lc3.Word(char)
+		}
+	}()
+	return ret
+}
+
+// ReadChar waits for a key. false is returned at the end of input
+func (c *console) ReadChar() (byte, bool) {
+	c.out.Flush()
+	char, ok := <-c.keyboard
+	return byte(char), ok
+}
+
+func (c *console) WriteChar(char byte) {
+	c.out.WriteByte(char)
+	if char == '\n' {
+		c.out.Flush()
+	}
+}
+
+// output is flushed when the program polls the keyboard, so prompts are seen before the key is pressed
+func (c *console) Load(address lc3.Word, value lc3.Word) {
+	if address != lc3.MrKbsr {
+		return
+	}
+	c.out.Flush()
+	if value&(1<<15) != 0 {
+		return
+	}
+	select {
+	case <-c.eof:
+		// a key could come after KBSR was read
+		c.starved = len(c.keyboard) == 0
+	default:
+	}
+}
+
+func (c *console) Store(address lc3.Word, value lc3.Word) {}
+
+func (c *console) flush() {
+	c.out.Flush()
+}
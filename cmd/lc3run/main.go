@@ -0,0 +1,302 @@
+// Command lc3run runs an LC-3 program.
+//
+// The program is read from .asm source or .obj image. The keyboard and the display of the VM are connected
+// to stdin and stdout. When stdin is a terminal it is switched to raw mode, so keys are read as they are pressed
+// and aren't echoed. Otherwise input is read from a pipe or a file and a program waiting for input after its end
+// by a trap or by polling the keyboard status register fails with an exception.
+//
+//	lc3run [-max n] [-os image] [-entry address] [-check] [-stack-limit address] [-shadow] [-protect action] [-cycles] [-memory-latency n] [-device-latency n] [-trace] [-I dir]... program.asm|program.obj
+//
+// With -os the operating system image is loaded before the program and all traps, including standard ones,
+// jump through the trap vector table to its routines. The machine is stopped by clearing bit 15 of MCR.
+//
+// With -check violations of the calling convention are printed to stderr when the program stops,
+// see package convention. -stack-limit sets the lowest address of the stack for the check.
+//
+// With -shadow reads of uninitialised memory, e.g. of .BLKW words never written, and execution of data
+// or uninitialised words are printed to stderr when the program stops, see package shadow.
+//
+// With -protect instructions of the program source are write protected. Writes into them are printed to stderr
+// when the program stops with the writing instruction and the old and new instructions. The action is report
+// to let the program continue, stop to stop it after the write or exception to stop it before the write.
+//
+// With -cycles clock cycles of the program are counted by the timing model of package lc3 and printed
+// to stderr when the program stops. -memory-latency and -device-latency set cycles of memory and device
+// register accesses.
+//
+// With -trace every executed instruction is printed to stderr with its address and, with -cycles,
+// the cycles it took.
+//
+// Exit status is 0 when the program halts, 3 when the instruction limit is exceeded, 4 on an exception,
+// 1 if the program can't be loaded and 2 on wrong usage.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/convention"
+	"github.com/pavel-krush/lc3/shadow"
+	"github.com/pkg/errors"
+)
+
+const (
+	exitHalt      = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitTimeout   = 3
+	exitException = 4
+	// terminated by a signal
+	exitInterrupt = 130
+)
+
+type includePaths []string
+
+func (p *includePaths) String() string     { return strings.Join(*p, ",") }
+func (p *includePaths) Set(v string) error { *p = append(*p, v); return nil }
+
+var (
+	maxInstructions = flag.Uint("max", 0, "stop after executing this many instructions. 0 means no limit")
+	osImage         = flag.String("os", "", "operating system image (.asm or .obj) serving traps")
+	entry           = flag.String("entry", "", "address of the first instruction, e.g. x3000. default is the program entry")
+	check           = flag.Bool("check", false, "check calling convention and print violations")
+	stackLimit      = flag.String("stack-limit", "", "the lowest address of the stack checked by -check, e.g. x3F00")
+	shadowMemory    = flag.Bool("shadow", false, "track uninitialised memory and print its uses")
+	protect         = flag.String("protect", "", "write protect code and `action` on writes into it: report, stop or exception")
+	cycles          = flag.Bool("cycles", false, "count clock cycles and print them")
+	memoryLatency   = flag.Uint("memory-latency", lc3.DefaultTiming.MemoryLatency, "cycles of a memory access counted by -cycles")
+	deviceLatency   = flag.Uint("device-latency", lc3.DefaultTiming.DeviceLatency, "cycles of a device register access counted by -cycles")
+	trace           = flag.Bool("trace", false, "print executed instructions")
+	includes        includePaths
+)
+
+func main() {
+	flag.Var(&includes, "I", "directory to search for .INCLUDE files. can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lc3run [flags] program.asm|program.obj\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	vm, program, system, err := newVM(flag.Arg(0))
+	if err == nil && *protect != "" {
+		err = protectCode(vm, program, *protect)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if _, ok := errors.Cause(err).(usageError); ok {
+			os.Exit(exitUsage)
+		}
+		os.Exit(exitError)
+	}
+	if *cycles {
+		vm.SetTiming(&lc3.Timing{MemoryLatency: *memoryLatency, DeviceLatency: *deviceLatency})
+	}
+	vm.Start()
+
+	restore := func() {}
+	if isTerminal(os.Stdin.Fd()) {
+		if r, err := makeRaw(os.Stdin.Fd()); err == nil {
+			restore = r
+		}
+	}
+	console := attach(vm, os.Stdin, os.Stdout)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	// the main goroutine writes the output, so it is not flushed here
+	go func() {
+		<-signals
+		restore()
+		os.Exit(exitInterrupt)
+	}()
+
+	step := vm.Step
+	var checker *convention.Checker
+	if *check {
+		checker = convention.New(vm, program)
+		if *stackLimit != "" {
+			if checker.StackLimit, err = parseAddress(*stackLimit); err != nil {
+				restore()
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitUsage)
+			}
+		}
+		step = checker.Step
+	}
+	var memory *shadow.Checker
+	if *shadowMemory {
+		memory = shadow.New(vm, program)
+		if system != nil {
+			memory.Add(system)
+		}
+		next := step
+		step = func() error {
+			memory.Fetch()
+			return next()
+		}
+	}
+
+	// a program polling the keyboard after the end of input would wait forever
+	polled := step
+	step = func() error {
+		if err := polled(); err != nil {
+			return err
+		}
+		if console.starved {
+			return lc3.ErrWaitingForInput
+		}
+		return nil
+	}
+
+	if *trace {
+		step = traced(vm, step)
+	}
+
+	status, message := run(vm, step, *maxInstructions)
+	console.flush()
+	restore()
+	if message != "" {
+		fmt.Fprintln(os.Stderr, message)
+	}
+	if checker != nil {
+		for _, v := range checker.Violations() {
+			fmt.Fprintln(os.Stderr, v)
+		}
+	}
+	if memory != nil {
+		for _, v := range memory.Violations() {
+			fmt.Fprintln(os.Stderr, v)
+		}
+	}
+	for _, w := range vm.CodeWrites() {
+		fmt.Fprintln(os.Stderr, w)
+	}
+	if *cycles {
+		fmt.Fprintf(os.Stderr, "%d instructions, %d cycles\n", vm.GetInstructionsExecuted(), vm.Cycles())
+	}
+	os.Exit(status)
+}
+
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// load program and optional OS image into a new VM. the image is nil without -os
+func newVM(name string) (*lc3.VM, *lc3.Program, *lc3.Program, error) {
+	program, err := load(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	vm := lc3.NewVM(lc3.WordMax + 1)
+	var system *lc3.Program
+	if *osImage != "" {
+		if system, err = load(*osImage); err != nil {
+			return nil, nil, nil, err
+		}
+		system.Load(vm)
+		vm.SystemTraps = true
+	}
+	program.Load(vm)
+
+	if *entry != "" {
+		address, err := parseAddress(*entry)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		vm.SetOrigin(address)
+	}
+	return vm, program, system, nil
+}
+
+func protectCode(vm *lc3.VM, program *lc3.Program, action string) error {
+	switch action {
+	case "report":
+		vm.CodeWriteAction = lc3.CodeWriteReport
+	case "stop":
+		vm.CodeWriteAction = lc3.CodeWriteStop
+	case "exception":
+		vm.CodeWriteAction = lc3.CodeWriteException
+	default:
+		return usageError(fmt.Sprintf("unknown -protect action %q", action))
+	}
+	program.ProtectCode(vm)
+	return nil
+}
+
+func load(name string) (*lc3.Program, error) {
+	if strings.EqualFold(filepath.Ext(name), ".obj") {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		program, err := lc3.ReadObj(file)
+		return program, errors.Wrap(err, name)
+	}
+	return (&lc3.Assembler{IncludePaths: includes}).AssembleFile(name)
+}
+
+// address written as xNNNN, 0xNNNN, #N or N
+func parseAddress(text string) (lc3.Word, error) {
+	address, err := lc3.ParseNumber(text)
+	if err != nil {
+		return 0, usageError(fmt.Sprintf("invalid address %q", text))
+	}
+	return address, nil
+}
+
+// print instructions executed by step with cycles they took
+func traced(vm *lc3.VM, step func() error) func() error {
+	return func() error {
+		pc := vm.GetRegister(lc3.RegPC)
+		instruction := vm.PeekMem(pc)
+		executed, before := vm.GetInstructionsExecuted(), vm.Cycles()
+		err := step()
+		if vm.GetInstructionsExecuted() == executed {
+			return err
+		}
+		text := lc3.EncodeInstructionAt(pc, instruction)
+		if *cycles {
+			fmt.Fprintf(os.Stderr, "x%04X  %-24s %d\n", pc, text, vm.Cycles()-before)
+		} else {
+			fmt.Fprintf(os.Stderr, "x%04X  %s\n", pc, text)
+		}
+		return err
+	}
+}
+
+// run the program by step until it stops and return exit status with a message for stderr
+func run(vm *lc3.VM, step func() error, limit uint) (int, string) {
+	for {
+		if limit > 0 && vm.GetInstructionsExecuted() >= limit {
+			return exitTimeout, fmt.Sprintf("instruction limit %d exceeded at x%04X", limit, vm.GetRegister(lc3.RegPC))
+		}
+
+		pc := vm.GetRegister(lc3.RegPC)
+		switch err := step(); err {
+		case nil:
+		case lc3.ErrNotRunning:
+			return exitHalt, ""
+		case lc3.ErrWaitingForInput:
+			return exitException, fmt.Sprintf("end of input while reading at x%04X", pc)
+		default:
+			return exitException, fmt.Sprintf("%v at x%04X", err, pc)
+		}
+
+		if !vm.IsRunning() {
+			return exitHalt, ""
+		}
+	}
+}
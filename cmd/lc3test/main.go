@@ -0,0 +1,120 @@
+// Command lc3test runs spec files of LC-3 programs and reports results as text, TAP or JUnit XML.
+//
+// Arguments are spec files or directories searched recursively for *.spec files.
+// file.spec tests file.asm next to it, see lc3test.Spec for the format.
+//
+//	lc3test [-format text|tap|junit] [-o report] [-I dir]... spec|dir...
+//
+// Exit status is 0 when all cases pass, 1 if some fail and 2 on wrong usage or unreadable specs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/lc3test"
+)
+
+type includePaths []string
+
+func (p *includePaths) String() string     { return strings.Join(*p, ",") }
+func (p *includePaths) Set(v string) error { *p = append(*p, v); return nil }
+
+var (
+	format   = flag.String("format", "text", "report format: text, tap or junit")
+	output   = flag.String("o", "", "write report to `file` instead of stdout")
+	includes includePaths
+)
+
+var writers = map[string]func(io.Writer, []lc3test.Report) error{
+	"text":  lc3test.WriteText,
+	"tap":   lc3test.WriteTAP,
+	"junit": lc3test.WriteJUnit,
+}
+
+func main() {
+	flag.Var(&includes, "I", "`directory` to search for .INCLUDE files. can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lc3test [flags] spec|dir...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	write, ok := writers[*format]
+	if flag.NArg() == 0 || !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	files, err := specFiles(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	assembler := &lc3.Assembler{IncludePaths: includes}
+	var reports []lc3test.Report
+	for _, name := range files {
+		spec, err := lc3test.ParseSpecFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		for _, c := range spec.Cases {
+			c.Assembler(assembler)
+		}
+		reports = append(reports, spec.Run()...)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	err = write(out, reports)
+	if closeErr := out.Close(); err == nil && *output != "" {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	for _, r := range reports {
+		if !r.Passed() {
+			os.Exit(1)
+		}
+	}
+}
+
+// spec files of arguments. directories are walked
+func specFiles(args []string) ([]string, error) {
+	var ret []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			ret = append(ret, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && filepath.Ext(path) == lc3test.SpecExt {
+				ret = append(ret, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
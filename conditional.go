@@ -0,0 +1,364 @@
+package lc3
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// open .IF, .IFDEF or .IFNDEF directive
+type condition struct {
+	opcode string
+	pos    position
+	// lines of the current branch are assembled
+	active bool
+	// one of the branches was already taken
+	taken bool
+	// the whole .IF is inside an active branch
+	parentActive bool
+	seenElse     bool
+}
+
+// handle conditional assembly directives.
+// returns false for lines that should be dropped: directives themselves and lines of inactive branches
+func (p *parser) conditional(line Line) (bool, error) {
+	parentActive := len(p.conditions) == 0 || p.conditions[len(p.conditions)-1].active
+
+	switch line.Opcode {
+	case stropIf, stropIfdef, stropIfndef:
+		if line.Label != "" {
+			return false, line.pos.errorf("label is not allowed before %s", line.Opcode)
+		}
+		value := false
+		if parentActive {
+			var err error
+			value, err = p.evalCondition(line)
+			if err != nil {
+				return false, line.pos.wrap(err)
+			}
+		}
+		p.conditions = append(p.conditions, condition{
+			opcode:       line.Opcode,
+			pos:          line.pos,
+			active:       parentActive && value,
+			taken:        value,
+			parentActive: parentActive,
+		})
+		return false, nil
+
+	case stropElse, stropEndif:
+		if line.Label != "" || len(line.Operands) > 0 {
+			return false, line.pos.errorf("unexpected input after %s", line.Opcode)
+		}
+		if len(p.conditions) <= p.conditionsBase {
+			return false, line.pos.errorf("%s without .IF", line.Opcode)
+		}
+		current := &p.conditions[len(p.conditions)-1]
+		if line.Opcode == stropEndif {
+			p.conditions = p.conditions[:len(p.conditions)-1]
+			return false, nil
+		}
+		if current.seenElse {
+			return false, line.pos.errorf("duplicate .ELSE")
+		}
+		current.seenElse = true
+		current.active = current.parentActive && !current.taken
+		current.taken = true
+		return false, nil
+	}
+
+	return parentActive, nil
+}
+
+func (p *parser) evalCondition(line Line) (bool, error) {
+	if line.Opcode == stropIf && (len(line.Operands) == 0 || *line.Operands[0].expression == "") {
+		return false, errors.Errorf("expression expected for .IF")
+	}
+	if len(line.Operands) != 1 {
+		return false, errors.Errorf("one operand expected for %s", line.Opcode)
+	}
+	operand := line.Operands[0]
+
+	switch line.Opcode {
+	case stropIfdef, stropIfndef:
+		if !operand.isLabel() {
+			return false, errors.Errorf("symbol name expected for %s", line.Opcode)
+		}
+		_, defined := p.defines[*operand.label]
+		return defined == (line.Opcode == stropIfdef), nil
+	}
+
+	value, err := evalExpression(*operand.expression, p.defines)
+	return value != 0, err
+}
+
+// operands which are values, not label references, by opcode
+var defineOperands = map[string]int{
+	stropAdd:  2,
+	stropAnd:  2,
+	stropLdr:  2,
+	stropStr:  2,
+	stropTrap: 0,
+	stropOrig: 0,
+	stropBlkw: 0,
+}
+
+// replace value operands named after a define by its value. labels with names of defines are kept
+func substituteDefines(lines []Line, defines map[string]int) {
+	if len(defines) == 0 {
+		return
+	}
+	for i := range lines {
+		line := &lines[i]
+		index, ok := defineOperands[line.Opcode]
+		if !ok || index >= len(line.Operands) || !line.Operands[index].isLabel() {
+			continue
+		}
+		if value, ok := defines[*line.Operands[index].label]; ok {
+			word := Word(value)
+			// syntax tree is not changed by substitution
+			line.Operands = append([]Operand(nil), line.Operands...)
+			line.Operands[index] = Operand{number: &word, pos: line.Operands[index].pos}
+		}
+	}
+}
+
+// binary operators by precedence, lowest first
+var binaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// evaluates integer expression of .IF.
+// operands are numbers (#10, x0A, 10), defined symbols and DEFINED(SYMBOL),
+// operators are the same as in C. non-zero value is true
+func evalExpression(expression string, defines map[string]int) (int, error) {
+	e := &expressionParser{input: expression, defines: defines}
+	value, err := e.parseBinary(0)
+	if err != nil {
+		return 0, err
+	}
+	e.skipSpaces()
+	if e.pos < len(e.input) {
+		return 0, errors.Errorf("unexpected %q in expression", e.input[e.pos:])
+	}
+	return value, nil
+}
+
+type expressionParser struct {
+	input   string
+	pos     int
+	defines map[string]int
+}
+
+func (e *expressionParser) skipSpaces() {
+	e.pos = eatSpaces(e.input, e.pos)
+}
+
+// all operator tokens. longer ones go first so "<" is not taken from "<<"
+var expressionOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"|", "^", "&", "<", ">", "+", "-", "*", "/", "%", "!", "~", "(", ")"}
+
+// consume op if it is the next token
+func (e *expressionParser) accept(op string) bool {
+	e.skipSpaces()
+	for _, token := range expressionOperators {
+		if strings.HasPrefix(e.input[e.pos:], token) {
+			if token != op {
+				return false
+			}
+			e.pos += len(op)
+			return true
+		}
+	}
+	return false
+}
+
+func (e *expressionParser) parseBinary(level int) (int, error) {
+	if level == len(binaryOperators) {
+		return e.parseUnary()
+	}
+
+	left, err := e.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+
+Operators:
+	for {
+		for _, op := range binaryOperators[level] {
+			if !e.accept(op) {
+				continue
+			}
+			right, err := e.parseBinary(level + 1)
+			if err != nil {
+				return 0, err
+			}
+			left, err = applyBinary(op, left, right)
+			if err != nil {
+				return 0, err
+			}
+			continue Operators
+		}
+		return left, nil
+	}
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func applyBinary(op string, left, right int) (int, error) {
+	switch op {
+	case "||":
+		return boolToInt(left != 0 || right != 0), nil
+	case "&&":
+		return boolToInt(left != 0 && right != 0), nil
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "&":
+		return left & right, nil
+	case "==":
+		return boolToInt(left == right), nil
+	case "!=":
+		return boolToInt(left != right), nil
+	case "<":
+		return boolToInt(left < right), nil
+	case "<=":
+		return boolToInt(left <= right), nil
+	case ">":
+		return boolToInt(left > right), nil
+	case ">=":
+		return boolToInt(left >= right), nil
+	case "<<":
+		return left << uint(right&15), nil
+	case ">>":
+		return left >> uint(right&15), nil
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if op == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	}
+	panic(op)
+}
+
+func (e *expressionParser) parseUnary() (int, error) {
+	switch {
+	case e.accept("!"):
+		value, err := e.parseUnary()
+		return boolToInt(value == 0), err
+	case e.accept("-"):
+		value, err := e.parseUnary()
+		return -value, err
+	case e.accept("~"):
+		value, err := e.parseUnary()
+		return ^value, err
+	case e.accept("("):
+		value, err := e.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		if !e.accept(")") {
+			return 0, errors.New("missing ) in expression")
+		}
+		return value, nil
+	}
+
+	return e.parsePrimary()
+}
+
+func isIdentifierChar(char byte) bool {
+	return char == '_' || char == '#' || char >= '0' && char <= '9' || char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z'
+}
+
+func (e *expressionParser) parsePrimary() (int, error) {
+	e.skipSpaces()
+	start := e.pos
+	// negative decimal literal #-10
+	if strings.HasPrefix(e.input[e.pos:], "#-") {
+		e.pos += 2
+	}
+	for e.pos < len(e.input) && isIdentifierChar(e.input[e.pos]) {
+		e.pos++
+	}
+	token := strings.ToUpper(e.input[start:e.pos])
+	if token == "" {
+		if e.pos == len(e.input) {
+			return 0, errors.New("unexpected end of expression")
+		}
+		return 0, errors.Errorf("unexpected %q in expression", e.input[e.pos:])
+	}
+
+	if token == "DEFINED" {
+		if !e.accept("(") {
+			return 0, errors.New("( expected after DEFINED")
+		}
+		e.skipSpaces()
+		start = e.pos
+		for e.pos < len(e.input) && isIdentifierChar(e.input[e.pos]) {
+			e.pos++
+		}
+		name := strings.ToUpper(e.input[start:e.pos])
+		if !e.accept(")") {
+			return 0, errors.New("missing ) in expression")
+		}
+		_, ok := e.defines[name]
+		return boolToInt(ok), nil
+	}
+
+	if value, ok, err := parseExpressionNumber(token); ok {
+		return value, err
+	}
+
+	value, ok := e.defines[token]
+	if !ok {
+		return 0, errors.Errorf("undefined symbol %s", token)
+	}
+	return value, nil
+}
+
+// numbers are decimal (#10 or 10) or hexadecimal (x0A). names like XYZ are not numbers
+func parseExpressionNumber(token string) (int, bool, error) {
+	base := 10
+	digits := token
+	switch {
+	case token[0] == '#':
+		digits = token[1:]
+	case token[0] >= '0' && token[0] <= '9':
+	case token[0] == 'X' && len(token) > 1 && strings.Trim(token[1:], "0123456789ABCDEF") == "":
+		base = 16
+		digits = token[1:]
+	default:
+		return 0, false, nil
+	}
+
+	value, err := strconv.ParseInt(digits, base, 32)
+	if err != nil {
+		return 0, true, errors.Errorf("bad number %s in expression", token)
+	}
+	return int(value), true, nil
+}
@@ -0,0 +1,168 @@
+package lc3
+
+import (
+	"strings"
+	"testing"
+)
+
+const conditionalTestCode = `
+		.ifdef debug
+				add r0, r0, #1
+			.if debug >= 2 && !defined(quiet)
+				add r0, r0, #2
+			.else
+				add r0, r0, #4
+			.endif
+		.else
+				add r0, r0, #8
+		.endif
+		.if 0
+			.if undefined_symbols_are_not_evaluated_here
+			.endif
+		.endif
+		.ifndef base
+				ld r1, base
+		.else
+				ld r1, #-1 ;r1 = value of base
+		.endif
+				halt
+		base	.fill #42`
+
+func Test_Conditional(t *testing.T) {
+	type testCase struct {
+		defines map[string]int
+		r0      Word
+		r1      Word
+	}
+
+	testData := []testCase{
+		{nil, 8, 42},
+		{map[string]int{"DEBUG": 1}, 5, 42},
+		{map[string]int{"debug": 2}, 3, 42},
+		{map[string]int{"DEBUG": 2, "QUIET": 0}, 5, 42},
+		{map[string]int{"BASE": 0x1234}, 8, NewLd(RegR1, MakeNegative(-1))},
+	}
+
+	for i := range testData {
+		a := &Assembler{Defines: testData[i].defines}
+		m, err := a.Parse("", strings.NewReader(conditionalTestCode))
+		if err != nil {
+			t.Errorf("%d: %s", i, err)
+			continue
+		}
+
+		m.Start()
+		for m.Step() == nil {
+		}
+
+		if m.GetRegister(RegR0) != testData[i].r0 || m.GetRegister(RegR1) != testData[i].r1 {
+			t.Errorf("%d: expected r0 = %d, r1 = %d, got %d, %d", i, testData[i].r0, testData[i].r1, m.GetRegister(RegR0), m.GetRegister(RegR1))
+		}
+	}
+}
+
+func Test_ConditionalLayout(t *testing.T) {
+	program, err := (&Assembler{Defines: map[string]int{"LAYOUT": 0x4000}}).Assemble("", strings.NewReader(`
+			.orig layout
+			halt`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if program.Entry != 0x4000 {
+		t.Errorf("expected entry x4000, got x%04X", program.Entry)
+	}
+}
+
+// defines are signed in conditions and replace only operands which are values
+func Test_Defines(t *testing.T) {
+	program, err := (&Assembler{Defines: map[string]int{"step": -1, "size": 3, "count": 7}}).Assemble("", strings.NewReader(`
+			.macro inc r, n
+					add r, r, n
+			.endm
+			.orig x3000
+			.if step < 0 && step == #-1
+					add r0, r0, step
+			.endif
+					inc r1, size
+					ld r2, count
+					brnzp done
+			count	.fill #9
+			buffer	.blkw size
+			done	halt
+			.end`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := program.NewVM()
+	m.Start()
+	for m.Step() == nil {
+	}
+	if m.GetRegister(RegR0) != 0xFFFF || m.GetRegister(RegR1) != 3 || m.GetRegister(RegR2) != 9 {
+		t.Errorf("expected r0 = -1, r1 = 3, r2 = 9, got %d, %d, %d", m.GetRegister(RegR0), m.GetRegister(RegR1), m.GetRegister(RegR2))
+	}
+	if address, _ := program.Symbol("DONE"); address != 0x3008 {
+		t.Errorf("expected DONE at x3008, got x%04X", address)
+	}
+}
+
+func Test_ConditionalErrors(t *testing.T) {
+	type testCase struct {
+		code  string
+		error string
+	}
+
+	testData := []testCase{
+		{".if 1\nhalt\n", "unterminated .IF at line 1"},
+		{".endif\n", ".ENDIF without .IF at line 1"},
+		{".if 0\n.else\n.else\n.endif\n", "duplicate .ELSE at line 3"},
+		{".if x + 1\n.endif\n", "undefined symbol X at line 1"},
+		{".if 1 +\n.endif\n", "unexpected end of expression at line 1"},
+		{".if (1\n.endif\n", "missing ) in expression at line 1"},
+		{".if 1 / 0\n.endif\n", "division by zero at line 1"},
+		{".if\n.endif\n", "expression expected for .IF at line 1"},
+		{"l .ifdef a\n.endif\n", "label is not allowed before .IFDEF at line 1"},
+	}
+
+	for i := range testData {
+		_, err := ParseAssembly(strings.NewReader(testData[i].code))
+		if err == nil {
+			t.Errorf("%d: expected error %q", i, testData[i].error)
+			continue
+		}
+		if !strings.Contains(err.Error(), testData[i].error) {
+			t.Errorf("%d: expected error %q, got %q", i, testData[i].error, err.Error())
+		}
+	}
+}
+
+func Test_evalExpression(t *testing.T) {
+	type testCase struct {
+		expression string
+		value      int
+	}
+
+	defines := map[string]int{"A": 3, "B": 0xFFFF}
+	testData := []testCase{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"#-10 + x0A", 0},
+		{"a << 2 | 1", 13},
+		{"a == 3 && b == xFFFF", 1},
+		{"a != 3 || !defined(c)", 1},
+		{"~0 & 7 ^ 2", 5},
+		{"-a % 2", -1},
+		{"a >= 3 && a <= 3 && a > 2 && a < 4", 1},
+	}
+
+	for i := range testData {
+		value, err := evalExpression(testData[i].expression, defines)
+		if err != nil {
+			t.Errorf("%d: %s", i, err)
+			continue
+		}
+		if value != testData[i].value {
+			t.Errorf("%d: %s = %d, expected %d", i, testData[i].expression, value, testData[i].value)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package convention checks at runtime that a program follows the LC-3 calling convention:
+// R6 is the stack pointer, R5 the frame pointer, R7 the return address, R0 the return value
+// and other registers are preserved by subroutines.
+//
+// The checker tracks calls made by JSR, JSRR and traps jumping through the vector table,
+// and returns to the instruction after the call. Violations are collected while the program
+// runs with Checker.Step instead of VM.Step.
+package convention
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// Kind of a violation
+type Kind int
+
+const (
+	// R6 on return differs from R6 before the call
+	UnbalancedStack Kind = iota
+	// a callee-saved register differs on return from its value before the call
+	ClobberedRegister
+	// R7 holding the return address is overwritten before it is saved
+	UnsavedReturnAddress
+	// write relative to R6 below the stack limit
+	StackOverflow
+)
+
+func (k Kind) String() string {
+	switch k {
+	case UnbalancedStack:
+		return "unbalanced stack"
+	case ClobberedRegister:
+		return "clobbered register"
+	case UnsavedReturnAddress:
+		return "unsaved return address"
+	case StackOverflow:
+		return "stack overflow"
+	}
+	return "unknown"
+}
+
+// Violation of the convention by an instruction. String formats it as file:line: LOCATION (xPC): message
+type Violation struct {
+	lc3.Diagnostic
+	Kind Kind
+}
+
+// subroutine call
+type frame struct {
+	entry lc3.Word
+	// execution continues here when the subroutine returns
+	ret lc3.Word
+	// R0-R7 before the call
+	registers [8]lc3.Word
+	// R7 is stored or copied since the call
+	saved bool
+	// overwriting of R7 is reported
+	reported bool
+}
+
+// Checker runs VM and records violations of the convention
+type Checker struct {
+	VM      *lc3.VM
+	Program *lc3.Program
+	// registers subroutines must preserve. R1-R5 by default. R6 is checked separately
+	CalleeSaved []int
+	// the lowest address of the stack. stores by STR relative to R6 below it are reported. zero disables the check
+	StackLimit lc3.Word
+
+	frames      []frame
+	violations  []Violation
+	diagnostics lc3.Diagnostics
+	// instruction being executed
+	pc          lc3.Word
+	instruction lc3.Instruction
+}
+
+// New checks vm running program. program is used for labels and source lines of violations
+func New(vm *lc3.VM, program *lc3.Program) *Checker {
+	ret := &Checker{
+		VM:          vm,
+		Program:     program,
+		CalleeSaved: []int{lc3.RegR1, lc3.RegR2, lc3.RegR3, lc3.RegR4, lc3.RegR5},
+	}
+	vm.AddObserver(ret)
+	return ret
+}
+
+// Violations returns violations in order they happened. every instruction is reported once for every kind
+func (c *Checker) Violations() []Violation {
+	return c.violations
+}
+
+// Step executes one instruction of the VM and checks it
+func (c *Checker) Step() error {
+	vm := c.VM
+	c.pc = vm.GetRegister(lc3.RegPC)
+	c.instruction = lc3.DecodeInstruction(vm.PeekMem(c.pc))
+	var registers [8]lc3.Word
+	for i := range registers {
+		registers[i] = vm.GetRegister(i)
+	}
+	c.checkReturnAddress(registers)
+
+	if err := vm.Step(); err != nil {
+		return err
+	}
+
+	next := vm.GetRegister(lc3.RegPC)
+	switch c.instruction.Opcode {
+	case lc3.OpJsr:
+		c.frames = append(c.frames, frame{entry: next, ret: c.pc + 1, registers: registers})
+	case lc3.OpTrap:
+		// built in traps don't jump
+		if next != c.pc+1 {
+			c.frames = append(c.frames, frame{entry: next, ret: c.pc + 1, registers: registers})
+		}
+	case lc3.OpJmp:
+		for i := len(c.frames) - 1; i >= 0; i-- {
+			if c.frames[i].ret == next {
+				c.checkReturn(c.frames[i])
+				c.frames = c.frames[:i]
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// report overwriting of R7 holding the return address of the current subroutine
+func (c *Checker) checkReturnAddress(registers [8]lc3.Word) {
+	if len(c.frames) == 0 {
+		return
+	}
+	top := &c.frames[len(c.frames)-1]
+	if top.saved || top.reported {
+		return
+	}
+
+	i := c.instruction
+	switch i.Opcode {
+	case lc3.OpSt, lc3.OpSti, lc3.OpStr:
+		top.saved = i.DR == lc3.RegR7
+		return
+	case lc3.OpAdd, lc3.OpAnd:
+		if i.SR1 == lc3.RegR7 || !i.ImmediateMode && i.SR2 == lc3.RegR7 {
+			top.saved = true
+			return
+		}
+	case lc3.OpNot:
+		if i.SR1 == lc3.RegR7 {
+			top.saved = true
+			return
+		}
+	}
+
+	if writesR7(i) && registers[lc3.RegR7] == top.ret {
+		top.reported = true
+		c.report(UnsavedReturnAddress, c.pc, fmt.Sprintf("R7 holding return address of %s is overwritten before it is saved", c.name(top.entry)))
+	}
+}
+
+// TRAP overwrites R7 on the real machine even if the VM serves it without jumping. HALT never returns
+func writesR7(i lc3.Instruction) bool {
+	switch i.Opcode {
+	case lc3.OpJsr:
+		return true
+	case lc3.OpTrap:
+		return i.Immediate != lc3.TrapVectHalt
+	case lc3.OpAdd, lc3.OpAnd, lc3.OpNot, lc3.OpLd, lc3.OpLdr, lc3.OpLdi, lc3.OpLea:
+		return i.DR == lc3.RegR7
+	}
+	return false
+}
+
+// compare registers on return from f with their values before the call
+func (c *Checker) checkReturn(f frame) {
+	vm := c.VM
+	name := c.name(f.entry)
+
+	if sp := vm.GetRegister(lc3.RegR6); sp != f.registers[lc3.RegR6] {
+		c.report(UnbalancedStack, c.pc, fmt.Sprintf("R6 is x%04X on return from %s, expected x%04X", sp, name, f.registers[lc3.RegR6]))
+	}
+
+	var changed []string
+	for _, register := range c.CalleeSaved {
+		if register == lc3.RegR6 || register == lc3.RegR7 {
+			continue
+		}
+		if value := vm.GetRegister(register); value != f.registers[register] {
+			changed = append(changed, fmt.Sprintf("R%d (x%04X to x%04X)", register, f.registers[register], value))
+		}
+	}
+	if len(changed) > 0 {
+		c.report(ClobberedRegister, c.pc, fmt.Sprintf("%s changed callee-saved %s", name, strings.Join(changed, ", ")))
+	}
+}
+
+func (c *Checker) Load(address lc3.Word, value lc3.Word) {}
+
+func (c *Checker) Store(address lc3.Word, value lc3.Word) {
+	i := c.instruction
+	if c.StackLimit != 0 && i.Opcode == lc3.OpStr && i.SR1 == lc3.RegR6 && address < c.StackLimit {
+		c.report(StackOverflow, c.pc, fmt.Sprintf("stack write at x%04X below the limit x%04X", address, c.StackLimit))
+	}
+}
+
+func (c *Checker) report(kind Kind, pc lc3.Word, message string) {
+	if d, ok := c.diagnostics.Report(c.Program, int(kind), pc, message); ok {
+		c.violations = append(c.violations, Violation{Diagnostic: d, Kind: kind})
+	}
+}
+
+// label of subroutine or its address
+func (c *Checker) name(address lc3.Word) string {
+	if location, ok := c.Program.Location(address); ok && !strings.Contains(location, "+") {
+		return location
+	}
+	return fmt.Sprintf("subroutine at x%04X", address)
+}
@@ -0,0 +1,92 @@
+package convention
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+const testProgram = `	.orig x3000
+main	ld r6, stack
+	jsr good
+	jsr leaky
+	jsr clobber
+	jsr nested
+	jsr deep
+	halt
+
+; saves and restores R1 and R7 on the stack
+good	add r6, r6, #-2
+	str r7, r6, #0
+	str r1, r6, #1
+	and r1, r1, #0
+	jsr leaf
+	ldr r1, r6, #1
+	ldr r7, r6, #0
+	add r6, r6, #2
+	ret
+
+leaf	ret
+
+; returns with one word left on the stack
+leaky	add r6, r6, #-1
+	str r1, r6, #0
+	ret
+
+; changes R2 and R3
+clobber	add r2, r2, #1
+	add r3, r3, #1
+	ret
+
+; calls leaf without saving R7, return is fixed by LEA to keep the program running
+nested	jsr leaf
+	lea r7, main
+	add r7, r7, #5
+	ret
+
+; pushes below the stack limit
+deep	add r6, r6, #-8
+	str r0, r6, #0
+	add r6, r6, #8
+	ret
+
+stack	.fill x4000
+	.end
+`
+
+func Test_Checker(t *testing.T) {
+	program, err := (&lc3.Assembler{}).Assemble("main.asm", strings.NewReader(testProgram))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := program.NewVM()
+	vm.Start()
+	c := New(vm, program)
+	c.StackLimit = 0x3FF8
+
+	for vm.IsRunning() {
+		if err := c.Step(); err != nil && err != lc3.ErrNotRunning {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		"main.asm:26: LEAKY+2 (x3013): R6 is x3FFF on return from LEAKY, expected x4000",
+		"main.asm:31: CLOBBER+2 (x3016): CLOBBER changed callee-saved R2 (x0000 to x0001), R3 (x0000 to x0001)",
+		"main.asm:34: NESTED (x3017): R7 holding return address of NESTED is overwritten before it is saved",
+		"main.asm:41: DEEP+1 (x301C): stack write at x3FF7 below the limit x3FF8",
+	}
+	violations := c.Violations()
+	if len(violations) != len(expected) {
+		t.Fatalf("expected %d violations, got %v", len(expected), violations)
+	}
+	for i := range expected {
+		if got := violations[i].String(); got != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], got)
+		}
+	}
+	if violations[0].Kind != UnbalancedStack || violations[3].Kind != StackOverflow {
+		t.Errorf("unexpected kinds %s and %s", violations[0].Kind, violations[3].Kind)
+	}
+}
@@ -0,0 +1,80 @@
+package dap
+
+import "sync"
+
+// console connects I/O traps of the program to the debug console.
+// output is sent by lines, input is typed in the debug console while the program is running
+type console struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// typed and not yet read characters
+	input []byte
+	// waiting for input is interrupted by pause
+	interrupted bool
+
+	// written by the VM goroutine only
+	output []byte
+	send   func(output string)
+}
+
+func newConsole(send func(output string)) *console {
+	ret := &console{send: send}
+	ret.cond = sync.NewCond(&ret.mu)
+	return ret
+}
+
+// ReadChar waits for input until it is typed or the program is paused
+func (c *console) ReadChar() (byte, bool) {
+	// show prompt before waiting
+	c.flush()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.input) == 0 && !c.interrupted {
+		c.cond.Wait()
+	}
+	if len(c.input) == 0 {
+		return 0, false
+	}
+	char := c.input[0]
+	c.input = c.input[1:]
+	return char, true
+}
+
+func (c *console) WriteChar(char byte) {
+	c.output = append(c.output, char)
+	if char == '\n' {
+		c.flush()
+	}
+}
+
+// send buffered output
+func (c *console) flush() {
+	if len(c.output) > 0 {
+		c.send(string(c.output))
+		c.output = nil
+	}
+}
+
+// type text in the console
+func (c *console) write(text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.input = append(c.input, text...)
+	c.cond.Broadcast()
+}
+
+// stop waiting for input
+func (c *console) interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interrupted = true
+	c.cond.Broadcast()
+}
+
+// allow waiting for input again
+func (c *console) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interrupted = false
+}
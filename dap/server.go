@@ -0,0 +1,408 @@
+// Package dap implements Debug Adapter Protocol for LC-3 programs.
+//
+// The adapter assembles the program given to launch request and runs it under control of the debugger package.
+// Breakpoints are set on source lines, stepping is done by source lines or by instructions when the client asks
+// for instruction granularity. Registers, the stack frame of every subroutine and memory are exposed as variables.
+// Program output is sent as output events. Text typed in the debug console while the program runs is its input,
+// while the program is stopped the console evaluates registers, labels and addresses.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/debugger"
+	"github.com/pkg/errors"
+)
+
+// the only thread of the program
+const threadID = 1
+
+// Server talks DAP over a pair of streams, usually stdin and stdout
+type Server struct {
+	reader *bufio.Reader
+
+	// guards writer and seq, events are sent by the goroutine running the program
+	writeMu sync.Mutex
+	writer  io.Writer
+	seq     int
+
+	debugger *debugger.Debugger
+	console  *console
+	// breakpoint addresses by source path
+	breakpoints map[string][]lc3.Word
+	stopOnEntry bool
+
+	// guards running and done
+	mu      sync.Mutex
+	running bool
+	// closed when the program stops
+	done chan struct{}
+
+	// children of variables by reference, valid until the program continues
+	variables []func() []Variable
+}
+
+// NewServer creates server reading requests from reader and writing responses and events to writer
+func NewServer(reader io.Reader, writer io.Writer) *Server {
+	return &Server{
+		reader:      bufio.NewReader(reader),
+		writer:      writer,
+		breakpoints: make(map[string][]lc3.Word),
+	}
+}
+
+// Run serves requests until disconnect request or the end of input
+func (s *Server) Run() error {
+	defer s.pause()
+
+	for {
+		body, err := readMessage(s.reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errors.Wrap(err, "malformed message")
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		// actions to do after the response is sent
+		var after []func()
+		result, err := s.handle(req, func(f func()) { after = append(after, f) })
+		resp := response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: result}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		if err := s.send(&resp.Seq, &resp); err != nil {
+			return err
+		}
+		for _, f := range after {
+			f()
+		}
+
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+// send message numbering it with seq
+func (s *Server) send(seq *int, message interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	*seq = s.seq
+	return writeMessage(s.writer, message)
+}
+
+// send event. errors are reported by the next response
+func (s *Server) event(name string, body interface{}) {
+	e := event{Type: "event", Event: name, Body: body}
+	_ = s.send(&e.Seq, &e)
+}
+
+func (s *Server) handle(req request, after func(func())) (interface{}, error) {
+	arguments := func(v interface{}) error {
+		if len(req.Arguments) == 0 {
+			return nil
+		}
+		return errors.Wrap(json.Unmarshal(req.Arguments, v), "invalid arguments")
+	}
+
+	switch req.Command {
+	case "initialize":
+		return Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsSteppingGranularity:      true,
+			SupportsTerminateRequest:         true,
+		}, nil
+
+	case "launch":
+		var args LaunchArguments
+		if err := arguments(&args); err != nil {
+			return nil, err
+		}
+		if err := s.launch(args); err != nil {
+			return nil, err
+		}
+		after(func() { s.event("initialized", nil) })
+		return nil, nil
+
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := arguments(&args); err != nil {
+			return nil, err
+		}
+		return s.setBreakpoints(args)
+
+	case "setExceptionBreakpoints":
+		return nil, nil
+
+	case "configurationDone":
+		if s.debugger == nil {
+			return nil, errors.New("program is not launched")
+		}
+		if s.stopOnEntry {
+			after(func() { s.stopped("entry", "", "") })
+		} else {
+			after(func() { s.resume(s.debugger.Continue) })
+		}
+		return nil, nil
+
+	case "threads":
+		return map[string]interface{}{"threads": []Thread{{ID: threadID, Name: "main"}}}, nil
+
+	case "continue", "next", "stepIn", "stepOut":
+		var args StepArguments
+		if err := arguments(&args); err != nil {
+			return nil, err
+		}
+		if err := s.checkStopped(); err != nil {
+			return nil, err
+		}
+		command := map[string]func() (debugger.StopReason, error){
+			"continue": s.debugger.Continue,
+			"next":     s.debugger.StepOver,
+			"stepIn":   s.debugger.StepIn,
+			"stepOut":  s.debugger.StepOut,
+		}[req.Command]
+		if args.Granularity == "instruction" && req.Command != "stepOut" && req.Command != "continue" {
+			command = s.debugger.StepInstruction
+		}
+		after(func() { s.resume(command) })
+		if req.Command == "continue" {
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+
+	case "pause":
+		s.pause()
+		return nil, nil
+
+	case "stackTrace":
+		if err := s.checkStopped(); err != nil {
+			return nil, err
+		}
+		frames := s.stackTrace()
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+
+	case "scopes":
+		var args ScopesArguments
+		if err := arguments(&args); err != nil {
+			return nil, err
+		}
+		if err := s.checkStopped(); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"scopes": s.scopes(args.FrameID)}, nil
+
+	case "variables":
+		var args VariablesArguments
+		if err := arguments(&args); err != nil {
+			return nil, err
+		}
+		if err := s.checkStopped(); err != nil {
+			return nil, err
+		}
+		if args.VariablesReference < 1 || args.VariablesReference > len(s.variables) {
+			return nil, errors.Errorf("unknown variables reference %d", args.VariablesReference)
+		}
+		return map[string]interface{}{"variables": s.variables[args.VariablesReference-1]()}, nil
+
+	case "evaluate":
+		var args EvaluateArguments
+		if err := arguments(&args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(args)
+
+	case "terminate":
+		s.pause()
+		if s.debugger != nil {
+			s.debugger.VM.Stop()
+		}
+		after(func() { s.event("terminated", nil) })
+		return nil, nil
+
+	case "disconnect":
+		s.pause()
+		return nil, nil
+	}
+
+	return nil, errors.Errorf("command %s is not supported", req.Command)
+}
+
+func (s *Server) launch(args LaunchArguments) error {
+	if args.Program == "" {
+		return errors.New("program is not specified")
+	}
+	path, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+
+	assembler := &lc3.Assembler{IncludePaths: args.IncludePaths}
+	program, err := assembler.AssembleFile(path)
+	if err != nil {
+		return err
+	}
+
+	s.debugger = debugger.New(program)
+	s.console = newConsole(func(output string) {
+		s.event("output", OutputEvent{Category: "stdout", Output: output})
+	})
+	s.debugger.VM.Console = s.console
+	s.stopOnEntry = args.StopOnEntry
+	return nil
+}
+
+func (s *Server) setBreakpoints(args SetBreakpointsArguments) (interface{}, error) {
+	if s.debugger == nil {
+		return nil, errors.New("program is not launched")
+	}
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range s.breakpoints[path] {
+		s.debugger.ClearBreakpoint(address)
+	}
+	s.breakpoints[path] = nil
+
+	ret := []Breakpoint{}
+	for _, bp := range args.Breakpoints {
+		address, line, ok := s.debugger.Address(path, bp.Line)
+		if !ok {
+			ret = append(ret, Breakpoint{Verified: false, Message: "no code at this line", Line: bp.Line})
+			continue
+		}
+		s.debugger.SetBreakpoint(address)
+		s.breakpoints[path] = append(s.breakpoints[path], address)
+		ret = append(ret, Breakpoint{Verified: true, Source: &args.Source, Line: line})
+	}
+	return map[string]interface{}{"breakpoints": ret}, nil
+}
+
+// check that the program is launched and stopped
+func (s *Server) checkStopped() error {
+	if s.debugger == nil {
+		return errors.New("program is not launched")
+	}
+	if s.isRunning() {
+		return errors.New("program is running")
+	}
+	if !s.debugger.VM.IsRunning() {
+		return errors.New("program has terminated")
+	}
+	return nil
+}
+
+func (s *Server) isRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+// run command in background and send stopped event when it is done
+func (s *Server) resume(command func() (debugger.StopReason, error)) {
+	s.mu.Lock()
+	s.running = true
+	s.done = make(chan struct{})
+	s.mu.Unlock()
+	s.variables = nil
+	s.console.resume()
+
+	go func() {
+		reason, err := command()
+		s.console.flush()
+
+		s.mu.Lock()
+		s.running = false
+		done := s.done
+		s.mu.Unlock()
+		// pause returns after the stopped event is sent
+		defer close(done)
+
+		switch reason {
+		case debugger.StopHalted:
+			s.event("exited", map[string]interface{}{"exitCode": 0})
+			s.event("terminated", nil)
+		case debugger.StopException:
+			s.stopped("exception", "bad instruction", err.Error())
+		case debugger.StopInput:
+			// input was interrupted
+			s.stopped("pause", "", "")
+		default:
+			s.stopped(reason.String(), "", "")
+		}
+	}()
+}
+
+func (s *Server) stopped(reason, description, text string) {
+	s.event("stopped", StoppedEvent{Reason: reason, Description: description, Text: text, ThreadID: threadID, AllThreadsStopped: true})
+}
+
+// stop running program and wait for it
+func (s *Server) pause() {
+	s.mu.Lock()
+	running, done := s.running, s.done
+	s.mu.Unlock()
+	if !running {
+		return
+	}
+	s.debugger.Pause()
+	s.console.interrupt()
+	<-done
+}
+
+// format address for display and memory references
+func address(a lc3.Word) string {
+	return fmt.Sprintf("x%04X", a)
+}
+
+// name of subroutine at entry
+func (s *Server) subroutine(entry lc3.Word) string {
+	if name, ok := s.debugger.Symbol(entry); ok {
+		return name
+	}
+	return address(entry)
+}
+
+// frames of the call stack, the innermost first. frame ids start at 1
+func (s *Server) stackTrace() []StackFrame {
+	calls := s.debugger.CallStack()
+	pc := s.debugger.PC()
+
+	var ret []StackFrame
+	for i := 0; i <= len(calls); i++ {
+		entry := s.debugger.Program.Entry
+		if i < len(calls) {
+			entry = calls[i].Entry
+		}
+		frame := StackFrame{ID: i + 1, Name: s.subroutine(entry), InstructionPointerReference: address(pc)}
+		if line, ok := s.debugger.Line(pc); ok {
+			frame.Source = &Source{Name: filepath.Base(line.File), Path: line.File}
+			frame.Line = line.Line
+			frame.Column = 1
+		}
+		ret = append(ret, frame)
+		if i < len(calls) {
+			pc = calls[i].Call
+		}
+	}
+	return ret
+}
@@ -0,0 +1,144 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// transcript is a recorded debug session: requests sent by the client and messages expected in reply.
+// seq of requests is assigned in order. expected messages match if they contain the expected fields,
+// arrays must have the same length. $DIR is replaced by the absolute path of testdata
+type transcript []struct {
+	Send   map[string]interface{} `json:"send"`
+	Expect map[string]interface{} `json:"expect"`
+}
+
+func Test_Transcripts(t *testing.T) {
+	dir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("no transcripts")
+	}
+
+	for _, name := range names {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			quoted, _ := json.Marshal(dir)
+			data = []byte(strings.ReplaceAll(string(data), "$DIR", strings.Trim(string(quoted), `"`)))
+			var steps transcript
+			if err := json.Unmarshal(data, &steps); err != nil {
+				t.Fatal(err)
+			}
+			replay(t, steps)
+		})
+	}
+}
+
+func replay(t *testing.T, steps transcript) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- NewServer(serverReader, serverWriter).Run()
+		serverWriter.Close()
+	}()
+
+	messages := make(chan map[string]interface{})
+	go func() {
+		defer close(messages)
+		reader := bufio.NewReader(clientReader)
+		for {
+			body, err := readMessage(reader)
+			if err != nil {
+				return
+			}
+			var message map[string]interface{}
+			if err := json.Unmarshal(body, &message); err != nil {
+				t.Error(err)
+				return
+			}
+			messages <- message
+		}
+	}()
+
+	seq := 0
+	for i, step := range steps {
+		if step.Send != nil {
+			seq++
+			step.Send["seq"] = seq
+			step.Send["type"] = "request"
+			if err := writeMessage(clientWriter, step.Send); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				t.Fatalf("step %d: server closed output, expected %v", i, step.Expect)
+			}
+			if !matches(step.Expect, message) {
+				actual, _ := json.Marshal(message)
+				expected, _ := json.Marshal(step.Expect)
+				t.Fatalf("step %d: expected\n%s\ngot\n%s", i, expected, actual)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("step %d: timeout waiting for %v", i, step.Expect)
+		}
+	}
+
+	clientWriter.Close()
+	if err := <-serverDone; err != nil {
+		t.Fatal(err)
+	}
+	for message := range messages {
+		actual, _ := json.Marshal(message)
+		t.Errorf("unexpected message %s", actual)
+	}
+}
+
+// actual contains every field of expected
+func matches(expected, actual interface{}) bool {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range e {
+			if !matches(value, a[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(e) {
+			return false
+		}
+		for i := range e {
+			if !matches(e[i], a[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(expected, actual)
+}
@@ -0,0 +1,4 @@
+	.orig x3000
+	and r0, r0, #0
+	.fill x8000
+	.end
@@ -0,0 +1,17 @@
+	.orig x3000
+main	ld r6, stack
+	lea r0, hello
+	puts
+	jsr double
+	getc
+	out
+	halt
+double	add r6, r6, #-1
+	str r7, r6, #0
+	add r1, r1, r1
+	ldr r7, r6, #0
+	add r6, r6, #1
+	ret
+stack	.fill xfe00
+hello	.stringz "hi\n"
+	.end
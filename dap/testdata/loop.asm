@@ -0,0 +1,5 @@
+	.orig x3000
+	and r0, r0, #0
+loop	add r0, r0, #1
+	brnzp loop
+	.end
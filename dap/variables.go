@@ -0,0 +1,177 @@
+package dap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pkg/errors"
+)
+
+// stack words shown for a frame at most
+const maxStackWords = 64
+
+// instructions are shown with signed immediates and absolute targets
+var formatter = lc3.Formatter{Signed: true, UpperHex: true, Targets: true}
+
+// word as hex and signed decimal
+func value(w lc3.Word) string {
+	return fmt.Sprintf("x%04X #%d", w, int16(w))
+}
+
+// allocate variables reference for children
+func (s *Server) reference(children func() []Variable) int {
+	s.variables = append(s.variables, children)
+	return len(s.variables)
+}
+
+func (s *Server) scopes(frameID int) []Scope {
+	ret := []Scope{{Name: "Registers", VariablesReference: s.reference(s.registers)}}
+
+	// frame occupies the stack from the stack pointer of the frame it called to the stack pointer at its own call
+	calls := s.debugger.CallStack()
+	if frameID >= 1 && frameID <= len(calls) {
+		top := s.debugger.VM.GetRegister(lc3.RegR6)
+		if frameID > 1 {
+			top = calls[frameID-2].StackPointer
+		}
+		bottom := calls[frameID-1].StackPointer
+		if top < bottom {
+			ret = append(ret, Scope{Name: "Stack", VariablesReference: s.reference(func() []Variable { return s.stack(top, bottom) })})
+		}
+	}
+
+	ret = append(ret,
+		Scope{Name: "Labels", VariablesReference: s.reference(s.labels)},
+		Scope{Name: "Memory", VariablesReference: s.reference(s.memory), Expensive: true},
+	)
+	return ret
+}
+
+// processor status register. the VM runs programs in user mode with priority 0, so only condition codes change
+func (s *Server) psr() lc3.Word {
+	return 1<<15 | s.debugger.VM.GetRegister(lc3.RegCond)
+}
+
+func (s *Server) registers() []Variable {
+	vm := s.debugger.VM
+	var ret []Variable
+	for i := lc3.RegR0; i <= lc3.RegR7; i++ {
+		ret = append(ret, Variable{Name: fmt.Sprintf("R%d", i), Value: value(vm.GetRegister(i))})
+	}
+	pc := vm.GetRegister(lc3.RegPC)
+	ret = append(ret,
+		Variable{Name: "PC", Value: address(pc), MemoryReference: address(pc)},
+		Variable{Name: "PSR", Value: fmt.Sprintf("x%04X [%s]", s.psr(), vm.GetRegister(lc3.RegCond).FlagsAsString())},
+	)
+	return ret
+}
+
+func (s *Server) stack(top, bottom lc3.Word) []Variable {
+	var ret []Variable
+	for a := top; a < bottom && len(ret) < maxStackWords; a++ {
+		ret = append(ret, Variable{Name: address(a), Value: value(s.debugger.VM.PeekMem(a)), MemoryReference: address(a)})
+	}
+	return ret
+}
+
+func (s *Server) labels() []Variable {
+	var ret []Variable
+	for _, symbol := range s.debugger.Program.Symbols {
+		ret = append(ret, s.word(symbol.Name+" "+address(symbol.Address), symbol.Address))
+	}
+	return ret
+}
+
+// sections of the program, every section is expanded to its words
+func (s *Server) memory() []Variable {
+	var ret []Variable
+	for _, section := range s.debugger.Program.Sections {
+		origin, size := section.Origin, len(section.Words)
+		if size == 0 {
+			continue
+		}
+		ret = append(ret, Variable{
+			Name:  fmt.Sprintf("%s-%s", address(origin), address(origin+lc3.Word(size-1))),
+			Value: fmt.Sprintf("%d words", size),
+			VariablesReference: s.reference(func() []Variable {
+				var words []Variable
+				for i := 0; i < size; i++ {
+					a := origin + lc3.Word(i)
+					words = append(words, s.word(address(a), a))
+				}
+				return words
+			}),
+			MemoryReference: address(origin),
+		})
+	}
+	return ret
+}
+
+// memory word at address. instructions are disassembled
+func (s *Server) word(name string, a lc3.Word) Variable {
+	w := s.debugger.VM.PeekMem(a)
+	ret := Variable{Name: name, Value: value(w), MemoryReference: address(a)}
+	if line, ok := s.debugger.Line(a); ok && line.Code {
+		ret.Value = fmt.Sprintf("x%04X %s", w, formatter.EncodeInstructionAt(a, w))
+	}
+	return ret
+}
+
+// input for the running program or an expression: register, label or address
+func (s *Server) evaluate(args EvaluateArguments) (interface{}, error) {
+	result := func(value string) interface{} {
+		return map[string]interface{}{"result": value, "variablesReference": 0}
+	}
+
+	if s.debugger == nil {
+		return nil, errors.New("program is not launched")
+	}
+	if s.isRunning() {
+		if args.Context != "repl" {
+			return nil, errors.New("program is running")
+		}
+		s.console.write(args.Expression + "\n")
+		return result(""), nil
+	}
+
+	expression := strings.ToUpper(strings.TrimSpace(args.Expression))
+	vm := s.debugger.VM
+	switch expression {
+	case "PC":
+		return result(address(vm.GetRegister(lc3.RegPC))), nil
+	case "PSR":
+		return result(address(s.psr())), nil
+	}
+	if len(expression) == 2 && expression[0] == 'R' && expression[1] >= '0' && expression[1] <= '7' {
+		return result(value(vm.GetRegister(int(expression[1] - '0')))), nil
+	}
+	if a, ok := s.debugger.Program.Symbol(expression); ok {
+		return result(s.word(expression, a).Value), nil
+	}
+	if a, ok := parseAddress(expression); ok {
+		return result(s.word(expression, a).Value), nil
+	}
+	return nil, errors.Errorf("can't evaluate %s", args.Expression)
+}
+
+// address written as xNNNN, 0xNNNN or #N
+func parseAddress(text string) (lc3.Word, bool) {
+	var n int64
+	var err error
+	switch {
+	case strings.HasPrefix(text, "0X"):
+		n, err = strconv.ParseInt(text[2:], 16, 32)
+	case strings.HasPrefix(text, "X"):
+		n, err = strconv.ParseInt(text[1:], 16, 32)
+	case strings.HasPrefix(text, "#"):
+		n, err = strconv.ParseInt(text[1:], 10, 32)
+	default:
+		return 0, false
+	}
+	if err != nil || n < 0 || n > lc3.WordMax {
+		return 0, false
+	}
+	return lc3.Word(n), true
+}
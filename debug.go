@@ -2,25 +2,34 @@ package lc3
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 )
 
+// Dump prints state of the VM to stdout
 func (m *VM) Dump(dumpMemory bool) {
+	m.DumpTo(os.Stdout, targetsFormatter, dumpMemory)
+}
+
+// DumpTo prints state of the VM to w. instructions are printed with f.
+// memory is read without side effects, so the VM can be dumped while it is stopped by a debugger
+func (m *VM) DumpTo(w io.Writer, f Formatter, dumpMemory bool) {
 	const instructionsPerLine = 8
-	fmt.Printf("Executed:  %d\n", m.instructionsExecuted)
+	fmt.Fprintf(w, "Executed:  %d\n", m.instructionsExecuted)
 
-	fmt.Printf("Registers: ")
-	fmt.Printf("PC %s ", m.registers[RegPC].AsString())
-	fmt.Printf("Flags [%s] ", m.registers[RegCond].FlagsAsString())
+	fmt.Fprintf(w, "Registers: ")
+	fmt.Fprintf(w, "PC %s ", m.registers[RegPC].AsString())
+	fmt.Fprintf(w, "Flags [%s] ", m.registers[RegCond].FlagsAsString())
 
 	for i := 0; i < 8; i++ {
-		fmt.Printf("r%d=%s ", i, m.registers[i].AsString())
+		fmt.Fprintf(w, "r%d=%s ", i, m.registers[i].AsString())
 	}
-	fmt.Printf("\n")
+	fmt.Fprintf(w, "\n")
 
-	instruction := m.getCurrentInstruction()
-	fmt.Printf("Instruction: %s; %s\n", EncodeInstruction(instruction), instruction.AsString())
+	instruction := m.PeekMem(m.registers[RegPC])
+	fmt.Fprintf(w, "Instruction: %s; %s\n", f.EncodeInstructionAt(m.registers[RegPC], instruction), instruction.AsString())
 
 	if dumpMemory {
 		var address Word
@@ -31,7 +40,7 @@ func (m *VM) Dump(dumpMemory bool) {
 			isEmpty := true
 			var j Word
 			for j = 0; j < instructionsPerLine; j++ {
-				word := m.ReadMem(address + j)
+				word := m.PeekMem(address + j)
 				if word != 0 {
 					isEmpty = false
 				}
@@ -44,25 +53,25 @@ func (m *VM) Dump(dumpMemory bool) {
 						continue
 					}
 					emptyStreak = true
-					fmt.Printf("   *\n")
+					fmt.Fprintf(w, "   *\n")
 					continue
 				} else {
 					emptyStreak = false
 				}
 			}
 
-			fmt.Printf("0x%04X  ", address)
+			fmt.Fprintf(w, "0x%04X  ", address)
 
 			for _, word := range words {
-				fmt.Printf("%02X %02X  ", word>>8&0xff, word&0xff)
+				fmt.Fprintf(w, "%02X %02X  ", word>>8&0xff, word&0xff)
 			}
 
 			for _, word := range words {
 				out := func(c byte) {
 					if strconv.IsPrint(rune(c)) {
-						fmt.Printf("%c", c)
+						fmt.Fprintf(w, "%c", c)
 					} else {
-						fmt.Printf(".")
+						fmt.Fprintf(w, ".")
 					}
 				}
 
@@ -70,14 +79,14 @@ func (m *VM) Dump(dumpMemory bool) {
 				out(byte(word & 0xff))
 			}
 
-			fmt.Printf(" ")
+			fmt.Fprintf(w, " ")
 
 			var asmInst []string
-			for _, word := range words {
-				asmInst = append(asmInst, EncodeInstruction(word))
+			for j, word := range words {
+				asmInst = append(asmInst, f.EncodeInstructionAt(address+Word(j), word))
 			}
 
-			fmt.Printf("%s\n", strings.Join(asmInst, "; "))
+			fmt.Fprintf(w, "%s\n", strings.Join(asmInst, "; "))
 			allowEmpty = true
 		}
 	}
@@ -0,0 +1,360 @@
+// Package debugger runs LC-3 programs under control of a debugger front end.
+//
+// It stops at breakpoints and watchpoints, steps by instructions and source lines, steps over and out of subroutines
+// and keeps a call stack. Calls are tracked as they are executed: JSR, JSRR and TRAP to a service routine
+// push a frame, a jump to the return address of a frame pops it and every frame above it.
+// R6 is assumed to be the stack pointer, its value at every call is recorded in the frame.
+package debugger
+
+import (
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// StopReason tells why execution stopped
+type StopReason int
+
+const (
+	StopStep       StopReason = iota // step is complete
+	StopBreakpoint                   // breakpoint is hit
+	StopPause                        // Pause was called
+	StopHalted                       // program is halted
+	StopInput                        // program waits for input
+	StopException                    // instruction can't be executed
+	StopWatchpoint                   // watched memory or protected code is written
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopPause:
+		return "pause"
+	case StopHalted:
+		return "halted"
+	case StopInput:
+		return "input"
+	case StopException:
+		return "exception"
+	case StopWatchpoint:
+		return "watchpoint"
+	}
+	return "unknown"
+}
+
+// WatchKind tells which memory accesses are watched
+type WatchKind int
+
+const (
+	WatchWrite WatchKind = 1 << iota
+	WatchRead
+	WatchAccess = WatchRead | WatchWrite
+)
+
+// Watchpoint is a watched memory access
+type Watchpoint struct {
+	Address lc3.Word
+	// WatchRead or WatchWrite
+	Kind WatchKind
+}
+
+// Frame is a subroutine call
+type Frame struct {
+	// address of the subroutine
+	Entry lc3.Word
+	// address of the calling instruction
+	Call lc3.Word
+	// execution continues here when the subroutine returns
+	Return lc3.Word
+	// R6 before the call
+	StackPointer lc3.Word
+}
+
+// Debugger controls a VM running a program
+type Debugger struct {
+	VM      *lc3.VM
+	Program *lc3.Program
+
+	breakpoints map[lc3.Word]bool
+	watchpoints map[lc3.Word]WatchKind
+	// watched access made by the last instruction
+	hit *Watchpoint
+	// calls made by the program, the outermost first
+	frames []Frame
+	// set by Pause
+	paused int32
+	// set by ProtectCode
+	protect *lc3.CodeWriteAction
+}
+
+// New loads program into a new VM and starts it. the program stops before the first instruction
+func New(program *lc3.Program) *Debugger {
+	ret := &Debugger{
+		VM:          program.NewVM(),
+		Program:     program,
+		breakpoints: make(map[lc3.Word]bool),
+		watchpoints: make(map[lc3.Word]WatchKind),
+	}
+	ret.VM.AddObserver(watcher{ret})
+	ret.VM.Start()
+	return ret
+}
+
+// Restart reloads the program and starts it again. breakpoints are kept
+func (d *Debugger) Restart() {
+	console := d.VM.Console
+	d.VM = d.Program.NewVM()
+	d.VM.Console = console
+	d.VM.AddObserver(watcher{d})
+	if d.protect != nil {
+		d.ProtectCode(*d.protect)
+	}
+	d.VM.Start()
+	d.frames = nil
+}
+
+// ProtectCode marks instructions of the program as code, writes into them are handled by action.
+// with lc3.CodeWriteStop execution stops by StopWatchpoint. the protection is kept by Restart
+func (d *Debugger) ProtectCode(action lc3.CodeWriteAction) {
+	d.protect = &action
+	d.VM.CodeWriteAction = action
+	d.Program.ProtectCode(d.VM)
+}
+
+// SetBreakpoint stops execution before the instruction at address
+func (d *Debugger) SetBreakpoint(address lc3.Word) {
+	d.breakpoints[address] = true
+}
+
+func (d *Debugger) ClearBreakpoint(address lc3.Word) {
+	delete(d.breakpoints, address)
+}
+
+func (d *Debugger) ClearBreakpoints() {
+	d.breakpoints = make(map[lc3.Word]bool)
+}
+
+// Breakpoints returns addresses of breakpoints in ascending order
+func (d *Debugger) Breakpoints() []lc3.Word {
+	ret := make([]lc3.Word, 0, len(d.breakpoints))
+	for address := range d.breakpoints {
+		ret = append(ret, address)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// SetWatchpoint stops execution after instructions accessing memory at address
+func (d *Debugger) SetWatchpoint(address lc3.Word, kind WatchKind) {
+	d.watchpoints[address] |= kind
+}
+
+func (d *Debugger) ClearWatchpoint(address lc3.Word, kind WatchKind) {
+	if d.watchpoints[address] &^= kind; d.watchpoints[address] == 0 {
+		delete(d.watchpoints, address)
+	}
+}
+
+// Watchpoint returns access which stopped execution with StopWatchpoint
+func (d *Debugger) Watchpoint() (Watchpoint, bool) {
+	if d.hit == nil {
+		return Watchpoint{}, false
+	}
+	return *d.hit, true
+}
+
+// watcher checks memory accesses of the VM against watchpoints
+type watcher struct {
+	d *Debugger
+}
+
+func (w watcher) Load(address lc3.Word, value lc3.Word) {
+	w.access(address, WatchRead)
+}
+
+func (w watcher) Store(address lc3.Word, value lc3.Word) {
+	w.access(address, WatchWrite)
+}
+
+func (w watcher) access(address lc3.Word, kind WatchKind) {
+	if w.d.hit == nil && w.d.watchpoints[address]&kind != 0 {
+		w.d.hit = &Watchpoint{Address: address, Kind: kind}
+	}
+}
+
+// Pause stops running Continue or a step. it is safe to call from another goroutine
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.paused, 1)
+}
+
+// PC returns address of the next instruction
+func (d *Debugger) PC() lc3.Word {
+	return d.VM.GetRegister(lc3.RegPC)
+}
+
+// execute one instruction and track calls and returns
+func (d *Debugger) step() error {
+	pc := d.PC()
+	instruction := lc3.DecodeInstruction(d.VM.PeekMem(pc))
+	sp := d.VM.GetRegister(lc3.RegR6)
+	d.hit = nil
+
+	if err := d.VM.Step(); err != nil {
+		return err
+	}
+
+	next := d.PC()
+	switch instruction.Opcode {
+	case lc3.OpJsr:
+		d.frames = append(d.frames, Frame{Entry: next, Call: pc, Return: pc + 1, StackPointer: sp})
+	case lc3.OpTrap:
+		// built in traps don't jump
+		if next != pc+1 {
+			d.frames = append(d.frames, Frame{Entry: next, Call: pc, Return: pc + 1, StackPointer: sp})
+		}
+	case lc3.OpJmp:
+		for i := len(d.frames) - 1; i >= 0; i-- {
+			if d.frames[i].Return == next {
+				d.frames = d.frames[:i]
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// run executes instructions until stop returns true, a breakpoint or a watchpoint is hit or the program stops
+func (d *Debugger) run(stop func() bool) (StopReason, error) {
+	defer atomic.StoreInt32(&d.paused, 0)
+
+	for {
+		if atomic.LoadInt32(&d.paused) != 0 {
+			return StopPause, nil
+		}
+
+		switch err := d.step(); err {
+		case nil:
+		case lc3.ErrNotRunning:
+			return StopHalted, nil
+		case lc3.ErrCodeWritten:
+			writes := d.VM.CodeWrites()
+			d.hit = &Watchpoint{Address: writes[len(writes)-1].Address, Kind: WatchWrite}
+			return StopWatchpoint, nil
+		case lc3.ErrWaitingForInput:
+			if atomic.LoadInt32(&d.paused) != 0 {
+				return StopPause, nil
+			}
+			return StopInput, nil
+		default:
+			return StopException, err
+		}
+
+		if !d.VM.IsRunning() {
+			return StopHalted, nil
+		}
+		if d.hit != nil {
+			return StopWatchpoint, nil
+		}
+		if d.breakpoints[d.PC()] {
+			return StopBreakpoint, nil
+		}
+		if stop() {
+			return StopStep, nil
+		}
+	}
+}
+
+// StepInstruction executes one instruction
+func (d *Debugger) StepInstruction() (StopReason, error) {
+	return d.run(func() bool { return true })
+}
+
+// Continue runs the program until a breakpoint or the end
+func (d *Debugger) Continue() (StopReason, error) {
+	return d.run(func() bool { return false })
+}
+
+// StepIn runs the program to the next source line. it enters subroutines.
+// addresses without source, e.g. service routines of the operating system, are run through
+func (d *Debugger) StepIn() (StopReason, error) {
+	return d.stepLine(func() bool { return true })
+}
+
+// StepOver runs the program to the next source line of the current subroutine or its callers
+func (d *Debugger) StepOver() (StopReason, error) {
+	depth := len(d.frames)
+	return d.stepLine(func() bool { return len(d.frames) <= depth })
+}
+
+// StepOut runs the program until the current subroutine returns
+func (d *Debugger) StepOut() (StopReason, error) {
+	depth := len(d.frames)
+	if depth == 0 {
+		return d.Continue()
+	}
+	return d.run(func() bool { return len(d.frames) < depth })
+}
+
+// run until another line starts or the current line is executed again
+func (d *Debugger) stepLine(here func() bool) (StopReason, error) {
+	start, _ := d.Line(d.PC())
+	return d.run(func() bool {
+		if !here() {
+			return false
+		}
+		line, ok := d.Line(d.PC())
+		if !ok {
+			return false
+		}
+		return line.File != start.File || line.Line != start.Line || d.PC() == line.Address
+	})
+}
+
+// CallStack returns frames of subroutine calls, the innermost first
+func (d *Debugger) CallStack() []Frame {
+	ret := make([]Frame, len(d.frames))
+	for i := range d.frames {
+		ret[len(d.frames)-1-i] = d.frames[i]
+	}
+	return ret
+}
+
+// Line returns the source line which produced word at address
+func (d *Debugger) Line(address lc3.Word) (lc3.SourceLine, bool) {
+	return d.Program.Line(address)
+}
+
+// Address returns address of the first instruction of source line or the closest line after it in the same file.
+// the line the address belongs to is returned too
+func (d *Debugger) Address(file string, line int) (lc3.Word, int, bool) {
+	file = filepath.Clean(file)
+	var best *lc3.SourceLine
+	for i := range d.Program.Lines {
+		source := &d.Program.Lines[i]
+		if !source.Code || source.Size == 0 || source.Line < line || filepath.Clean(source.File) != file {
+			continue
+		}
+		if best == nil || source.Line < best.Line || source.Line == best.Line && source.Address < best.Address {
+			best = source
+		}
+	}
+	if best == nil {
+		return 0, 0, false
+	}
+	return best.Address, best.Line, true
+}
+
+// Symbol returns label at address
+func (d *Debugger) Symbol(address lc3.Word) (string, bool) {
+	for _, symbol := range d.Program.Symbols {
+		if symbol.Address == address {
+			return symbol.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,166 @@
+package debugger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+const testProgram = `	.orig x3000
+main	ld r6, stack
+	jsr outer
+	add r0, r0, #1
+	halt
+outer	add r6, r6, #-1
+	str r7, r6, #0
+	jsr inner
+	ldr r7, r6, #0
+	add r6, r6, #1
+	ret
+inner	add r1, r1, #1
+	ret
+stack	.fill xfe00
+	.end
+`
+
+func newTestDebugger(t *testing.T) *Debugger {
+	program, err := (&lc3.Assembler{}).Assemble("main.asm", strings.NewReader(testProgram))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(program)
+}
+
+func expectStop(t *testing.T, d *Debugger, reason StopReason, err error, expectedReason StopReason, pc lc3.Word, depth int) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != expectedReason {
+		t.Fatalf("expected stop by %s, got %s", expectedReason, reason)
+	}
+	if d.PC() != pc {
+		t.Fatalf("expected PC %s, got %s", pc.AsString(), d.PC().AsString())
+	}
+	if len(d.CallStack()) != depth {
+		t.Fatalf("expected %d frames, got %v", depth, d.CallStack())
+	}
+}
+
+func Test_Stepping(t *testing.T) {
+	d := newTestDebugger(t)
+
+	reason, err := d.StepIn()
+	expectStop(t, d, reason, err, StopStep, 0x3001, 0)
+	reason, err = d.StepOver()
+	expectStop(t, d, reason, err, StopStep, 0x3002, 0)
+
+	d.Restart()
+	d.SetBreakpoint(0x300A)
+	reason, err = d.Continue()
+	expectStop(t, d, reason, err, StopBreakpoint, 0x300A, 2)
+
+	calls := d.CallStack()
+	if calls[0] != (Frame{Entry: 0x300A, Call: 0x3006, Return: 0x3007, StackPointer: 0xFDFF}) {
+		t.Errorf("unexpected inner frame %+v", calls[0])
+	}
+	if calls[1] != (Frame{Entry: 0x3004, Call: 0x3001, Return: 0x3002, StackPointer: 0xFE00}) {
+		t.Errorf("unexpected outer frame %+v", calls[1])
+	}
+
+	reason, err = d.StepOut()
+	expectStop(t, d, reason, err, StopStep, 0x3007, 1)
+	reason, err = d.StepOut()
+	expectStop(t, d, reason, err, StopStep, 0x3002, 0)
+
+	d.ClearBreakpoints()
+	reason, err = d.Continue()
+	expectStop(t, d, reason, err, StopHalted, 0x3004, 0)
+	if r0 := d.VM.GetRegister(lc3.RegR0); r0 != 1 {
+		t.Errorf("expected R0 = 1, got %s", r0.AsString())
+	}
+}
+
+func Test_Pause(t *testing.T) {
+	program, err := (&lc3.Assembler{}).Assemble("loop.asm", strings.NewReader("\t.orig x3000\nloop\tbrnzp loop\n\t.end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(program)
+	d.Pause()
+	reason, err := d.Continue()
+	expectStop(t, d, reason, err, StopPause, 0x3000, 0)
+}
+
+func Test_SourceLines(t *testing.T) {
+	d := newTestDebugger(t)
+
+	address, line, ok := d.Address("main.asm", 1)
+	if !ok || address != 0x3000 || line != 2 {
+		t.Errorf("expected line 2 at x3000, got line %d at %s", line, address.AsString())
+	}
+	if _, _, ok := d.Address("main.asm", 14); ok {
+		t.Errorf("no code is expected after line 14")
+	}
+
+	source, ok := d.Line(0x3006)
+	if !ok || source.Line != 8 {
+		t.Errorf("expected line 8 at x3006, got %+v", source)
+	}
+	if name, _ := d.Symbol(0x300A); name != "INNER" {
+		t.Errorf("expected INNER at x300A, got %q", name)
+	}
+}
+
+func Test_Watchpoints(t *testing.T) {
+	d := newTestDebugger(t)
+	d.SetWatchpoint(0xFDFF, WatchWrite)
+	d.SetWatchpoint(0x300C, WatchRead)
+
+	reason, err := d.Continue()
+	expectStop(t, d, reason, err, StopWatchpoint, 0x3001, 0)
+	if w, ok := d.Watchpoint(); !ok || w != (Watchpoint{0x300C, WatchRead}) {
+		t.Errorf("expected read of x300C, got %+v", w)
+	}
+
+	reason, err = d.Continue()
+	expectStop(t, d, reason, err, StopWatchpoint, 0x3006, 1)
+	if w, _ := d.Watchpoint(); w != (Watchpoint{0xFDFF, WatchWrite}) {
+		t.Errorf("expected write of xFDFF, got %+v", w)
+	}
+
+	// reading the saved return address is not watched
+	d.ClearWatchpoint(0x300C, WatchAccess)
+	reason, err = d.Continue()
+	expectStop(t, d, reason, err, StopHalted, 0x3004, 0)
+}
+
+func Test_ProtectCode(t *testing.T) {
+	program, err := (&lc3.Assembler{}).Assemble("main.asm", strings.NewReader(`	.orig x3000
+	ld r0, word
+	st r0, main
+main	add r1, r1, #1
+	halt
+word	.fill x0000
+	.end
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(program)
+	d.ProtectCode(lc3.CodeWriteStop)
+
+	reason, err := d.Continue()
+	expectStop(t, d, reason, err, StopWatchpoint, 0x3002, 0)
+	if w, _ := d.Watchpoint(); w != (Watchpoint{0x3002, WatchWrite}) {
+		t.Errorf("expected write of x3002, got %+v", w)
+	}
+	reason, err = d.Continue()
+	expectStop(t, d, reason, err, StopHalted, 0x3004, 0)
+
+	// protection is kept
+	d.Restart()
+	reason, err = d.Continue()
+	expectStop(t, d, reason, err, StopWatchpoint, 0x3002, 0)
+}
@@ -31,3 +31,126 @@ func getNBits(x Word, from uint, bits uint) Word {
 func getNBitsExtended(x Word, from uint, bits uint) Word {
 	return signExtend(getNBits(x, from, bits), bits)
 }
+
+// Instruction is an instruction word split into its fields
+type Instruction struct {
+	Opcode Word
+	// destination register. source register of ST, STI and STR
+	DR Word
+	// first source register. base register of LDR, STR, JMP and JSRR
+	SR1 Word
+	// second source register of ADD and AND
+	SR2 Word
+	// ADD and AND use Immediate instead of SR2, JSR uses Immediate instead of base register
+	ImmediateMode bool
+	// sign extended imm5, offset6, PCoffset9, PCoffset11 or zero extended trapvect8
+	Immediate Word
+	// n, z, p flags of BR
+	Flags Word
+}
+
+// DecodeInstruction splits instruction into fields
+func DecodeInstruction(instruction Word) Instruction {
+	ret := Instruction{
+		Opcode: getOpcode(instruction),
+		DR:     getNBits(instruction, 9, 3),
+		SR1:    getNBits(instruction, 6, 3),
+		SR2:    getNBits(instruction, 0, 3),
+	}
+
+	switch ret.Opcode {
+	case OpBr:
+		ret.Flags = ret.DR
+		ret.Immediate = getNBitsExtended(instruction, 0, 9)
+	case OpAdd, OpAnd:
+		ret.ImmediateMode = getNBits(instruction, 5, 1) == 1
+		ret.Immediate = getNBitsExtended(instruction, 0, 5)
+	case OpLd, OpSt, OpLdi, OpSti, OpLea:
+		ret.Immediate = getNBitsExtended(instruction, 0, 9)
+	case OpJsr:
+		ret.ImmediateMode = getNBits(instruction, 11, 1) == 1
+		ret.Immediate = getNBitsExtended(instruction, 0, 11)
+	case OpLdr, OpStr:
+		ret.Immediate = getNBitsExtended(instruction, 0, 6)
+	case OpTrap:
+		ret.Immediate = getNBits(instruction, 0, 8)
+	}
+
+	return ret
+}
+
+// IsPCRelative checks if instruction refers to memory relative to PC
+func (i Instruction) IsPCRelative() bool {
+	switch i.Opcode {
+	case OpBr, OpLd, OpSt, OpLdi, OpSti, OpLea:
+		return true
+	case OpJsr:
+		return i.ImmediateMode
+	}
+	return false
+}
+
+// Target returns the address PC-relative instruction at address refers to
+func (i Instruction) Target(address Word) (Word, bool) {
+	if !i.IsPCRelative() {
+		return 0, false
+	}
+	return address + 1 + i.Immediate, true
+}
+
+// Encode builds instruction word from fields.
+// the result differs from the decoded word if it had non-zero unused bits or no BR flags,
+// such words can't be written by the assembler as instructions
+func (i Instruction) Encode() (Word, bool) {
+	switch i.Opcode {
+	case OpBr:
+		if i.Flags == 0 {
+			return 0, false
+		}
+		return NewBR(i.Flags, i.Immediate), true
+	case OpAdd:
+		if i.ImmediateMode {
+			return NewAddImmediate(i.DR, i.SR1, i.Immediate), true
+		}
+		return NewAddRegister(i.DR, i.SR1, i.SR2), true
+	case OpLd:
+		return NewLd(i.DR, i.Immediate), true
+	case OpSt:
+		return NewSt(i.DR, i.Immediate), true
+	case OpJsr:
+		if i.ImmediateMode {
+			return NewJsr(i.Immediate), true
+		}
+		return NewJsrr(i.SR1), true
+	case OpAnd:
+		if i.ImmediateMode {
+			return NewAndImmediate(i.DR, i.SR1, i.Immediate), true
+		}
+		return NewAndRegister(i.DR, i.SR1, i.SR2), true
+	case OpLdr:
+		return NewLdr(i.DR, i.SR1, i.Immediate), true
+	case OpStr:
+		return NewStr(i.DR, i.SR1, i.Immediate), true
+	case OpRti:
+		return NewRti(), true
+	case OpNot:
+		return NewNot(i.DR, i.SR1), true
+	case OpLdi:
+		return NewLdi(i.DR, i.Immediate), true
+	case OpSti:
+		return NewSti(i.DR, i.Immediate), true
+	case OpJmp:
+		return NewJmp(i.SR1), true
+	case OpLea:
+		return NewLea(i.DR, i.Immediate), true
+	case OpTrap:
+		return NewTrap(i.Immediate), true
+	}
+	return 0, false
+}
+
+// IsCanonical checks if instruction can be written by the assembler and assembles back to the same word
+func IsCanonical(instruction Word) bool {
+	encoded, ok := DecodeInstruction(instruction).Encode()
+	return ok && encoded == instruction
+}
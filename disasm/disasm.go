@@ -0,0 +1,358 @@
+// Package disasm turns LC-3 memory images back into assembly source.
+//
+// The output always assembles back to the same words: words that are not valid
+// instructions are written as .FILL, and PC-relative offsets refer to synthesized
+// labels when their targets are inside the disassembled range.
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// minimal number of characters to write a zero terminated run of words as .STRINGZ
+const minStringLength = 2
+
+// Block is a continuous range of words
+type Block struct {
+	Origin lc3.Word
+	Words  []lc3.Word
+}
+
+// Options control the disassembly
+type Options struct {
+	// known labels. labels for other referenced addresses are generated
+	Symbols []lc3.Symbol
+	// addresses where execution may start. the origin of the first block is used if empty
+	Entries []lc3.Word
+}
+
+// Disassemble converts words placed at origin into source
+func Disassemble(origin lc3.Word, words []lc3.Word) string {
+	return DisassembleBlocks([]Block{{origin, words}}, Options{})
+}
+
+// DisassembleProgram converts all sections of a program into source, reusing program labels
+func DisassembleProgram(program *lc3.Program) string {
+	var blocks []Block
+	for _, section := range program.Sections {
+		blocks = append(blocks, Block{section.Origin, section.Words})
+	}
+	return DisassembleBlocks(blocks, Options{Symbols: program.Symbols, Entries: []lc3.Word{program.Entry}})
+}
+
+// DisassembleMemory converts memory range [from, to] of m into source
+func DisassembleMemory(m *lc3.VM, from, to lc3.Word) string {
+	var words []lc3.Word
+	for address := int(from); address <= int(to); address++ {
+		words = append(words, m.PeekMem(lc3.Word(address)))
+	}
+	return Disassemble(from, words)
+}
+
+// DisassembleObj converts .obj file into source
+func DisassembleObj(reader io.Reader) (string, error) {
+	program, err := lc3.ReadObj(reader)
+	if err != nil {
+		return "", err
+	}
+	return DisassembleProgram(program), nil
+}
+
+// DisassembleBlocks converts blocks into source. every block starts with its own .ORIG
+func DisassembleBlocks(blocks []Block, options Options) string {
+	d := newDisassembler(blocks, options)
+
+	var buffer strings.Builder
+	for _, block := range blocks {
+		d.writeBlock(&buffer, block)
+	}
+	buffer.WriteString("\t.END\n")
+
+	return buffer.String()
+}
+
+type wordKind int
+
+const (
+	kindCode wordKind = iota
+	kindData
+	kindString
+)
+
+type disassembler struct {
+	words  map[lc3.Word]lc3.Word
+	kinds  map[lc3.Word]wordKind
+	labels map[lc3.Word]string
+	// first words of .STRINGZ
+	stringStarts map[lc3.Word]bool
+}
+
+func newDisassembler(blocks []Block, options Options) *disassembler {
+	d := &disassembler{
+		words:        make(map[lc3.Word]lc3.Word),
+		kinds:        make(map[lc3.Word]wordKind),
+		labels:       make(map[lc3.Word]string),
+		stringStarts: make(map[lc3.Word]bool),
+	}
+
+	for _, block := range blocks {
+		for i, word := range block.Words {
+			d.words[block.Origin+lc3.Word(i)] = word
+		}
+	}
+
+	for _, symbol := range options.Symbols {
+		if _, ok := d.words[symbol.Address]; ok && d.labels[symbol.Address] == "" {
+			d.labels[symbol.Address] = symbol.Name
+		}
+	}
+
+	entries := options.Entries
+	if len(entries) == 0 && len(blocks) > 0 {
+		entries = []lc3.Word{blocks[0].Origin}
+	}
+	reachable := d.trace(entries)
+
+	// words loaded and stored by executed instructions are data, unless they are executed too
+	for address := range reachable {
+		instruction := lc3.DecodeInstruction(d.words[address])
+		target, ok := d.target(address)
+		if !ok {
+			continue
+		}
+		switch instruction.Opcode {
+		case lc3.OpLd, lc3.OpSt, lc3.OpLdi, lc3.OpSti:
+			if !reachable[target] {
+				d.kinds[target] = kindData
+			}
+		}
+	}
+
+	for address, word := range d.words {
+		if !lc3.IsCanonical(word) {
+			d.kinds[address] = kindData
+		}
+	}
+
+	for _, block := range blocks {
+		d.findStrings(block, reachable)
+	}
+
+	// words that were not reached may be code too. they get labels unless they are data
+	for address := range d.words {
+		if d.kinds[address] == kindCode && !reachable[address] {
+			d.target(address)
+		}
+	}
+
+	return d
+}
+
+// target of PC-relative instruction at address. a label is generated for targets inside disassembled blocks
+func (d *disassembler) target(address lc3.Word) (lc3.Word, bool) {
+	target, ok := lc3.DecodeInstruction(d.words[address]).Target(address)
+	if !ok {
+		return 0, false
+	}
+	if _, inside := d.words[target]; !inside {
+		return 0, false
+	}
+	// strings can't be split by labels
+	if d.kinds[target] == kindString && !d.stringStarts[target] {
+		return 0, false
+	}
+	if d.labels[target] == "" {
+		d.labels[target] = fmt.Sprintf("L%04X", target)
+	}
+	return target, true
+}
+
+// find addresses executed when the program starts at entries
+func (d *disassembler) trace(entries []lc3.Word) map[lc3.Word]bool {
+	ret := make(map[lc3.Word]bool)
+	queue := append([]lc3.Word{}, entries...)
+
+	for len(queue) > 0 {
+		address := queue[0]
+		queue = queue[1:]
+
+		word, ok := d.words[address]
+		if !ok || ret[address] || !lc3.IsCanonical(word) {
+			continue
+		}
+		ret[address] = true
+
+		instruction := lc3.DecodeInstruction(word)
+		next := true
+		switch instruction.Opcode {
+		case lc3.OpBr:
+			target, _ := instruction.Target(address)
+			queue = append(queue, target)
+			next = instruction.Flags != lc3.FlN|lc3.FlZ|lc3.FlP
+		case lc3.OpJsr:
+			if target, ok := instruction.Target(address); ok {
+				queue = append(queue, target)
+			}
+		case lc3.OpJmp, lc3.OpRti:
+			next = false
+		case lc3.OpTrap:
+			next = instruction.Immediate != lc3.TrapVectHalt
+		}
+		if next {
+			queue = append(queue, address+1)
+		}
+	}
+
+	return ret
+}
+
+func isPrintable(word lc3.Word) bool {
+	return word >= 0x20 && word < 0x7F || word == '\n' || word == '\t'
+}
+
+// mark zero terminated runs of characters as strings.
+// labels can point only to the first character, because .STRINGZ can't be split
+func (d *disassembler) findStrings(block Block, reachable map[lc3.Word]bool) {
+	for i := 0; i < len(block.Words); {
+		j := i
+		for j < len(block.Words) && isPrintable(block.Words[j]) && !reachable[block.Origin+lc3.Word(j)] &&
+			(j == i || d.labels[block.Origin+lc3.Word(j)] == "") {
+			j++
+		}
+		if j-i >= minStringLength && j < len(block.Words) && block.Words[j] == 0 && d.labels[block.Origin+lc3.Word(j)] == "" {
+			d.stringStarts[block.Origin+lc3.Word(i)] = true
+			for k := i; k <= j; k++ {
+				d.kinds[block.Origin+lc3.Word(k)] = kindString
+			}
+			i = j + 1
+			continue
+		}
+		i++
+	}
+}
+
+func (d *disassembler) writeBlock(buffer *strings.Builder, block Block) {
+	fmt.Fprintf(buffer, "\t.ORIG x%04X\n", block.Origin)
+
+	for i := 0; i < len(block.Words); {
+		address := block.Origin + lc3.Word(i)
+		word := block.Words[i]
+
+		var text, comment string
+		switch d.kinds[address] {
+		case kindString:
+			var str []byte
+			for block.Words[i] != 0 {
+				str = append(str, byte(block.Words[i]))
+				i++
+			}
+			text = ".STRINGZ " + strconv.Quote(string(str))
+		case kindData:
+			text = fmt.Sprintf(".FILL x%04X", word)
+		default:
+			text, comment = d.instruction(address, word)
+		}
+		i++
+
+		buffer.WriteString(d.labels[address])
+		buffer.WriteByte('\t')
+		buffer.WriteString(text)
+		if comment != "" {
+			buffer.WriteString(" ; ")
+			buffer.WriteString(comment)
+		}
+		buffer.WriteByte('\n')
+	}
+}
+
+func register(r lc3.Word) string {
+	return fmt.Sprintf("R%d", r)
+}
+
+func signed(value lc3.Word) string {
+	return fmt.Sprintf("#%d", int16(value))
+}
+
+// source of a canonical instruction and a comment for it
+func (d *disassembler) instruction(address lc3.Word, word lc3.Word) (string, string) {
+	i := lc3.DecodeInstruction(word)
+
+	// label of PC-relative target or a raw offset for targets out of range
+	target := func() (string, string) {
+		t, _ := i.Target(address)
+		if label := d.labels[t]; label != "" {
+			return label, ""
+		}
+		return signed(i.Immediate), fmt.Sprintf("x%04X", t)
+	}
+
+	switch i.Opcode {
+	case lc3.OpBr:
+		op, comment := target()
+		flags := ""
+		if i.Flags&lc3.FlN != 0 {
+			flags += "N"
+		}
+		if i.Flags&lc3.FlZ != 0 {
+			flags += "Z"
+		}
+		if i.Flags&lc3.FlP != 0 {
+			flags += "P"
+		}
+		return "BR" + flags + " " + op, comment
+	case lc3.OpAdd, lc3.OpAnd:
+		name := "ADD"
+		if i.Opcode == lc3.OpAnd {
+			name = "AND"
+		}
+		if i.ImmediateMode {
+			return fmt.Sprintf("%s %s, %s, %s", name, register(i.DR), register(i.SR1), signed(i.Immediate)), ""
+		}
+		return fmt.Sprintf("%s %s, %s, %s", name, register(i.DR), register(i.SR1), register(i.SR2)), ""
+	case lc3.OpLd, lc3.OpSt, lc3.OpLdi, lc3.OpSti, lc3.OpLea:
+		names := map[lc3.Word]string{lc3.OpLd: "LD", lc3.OpSt: "ST", lc3.OpLdi: "LDI", lc3.OpSti: "STI", lc3.OpLea: "LEA"}
+		op, comment := target()
+		return fmt.Sprintf("%s %s, %s", names[i.Opcode], register(i.DR), op), comment
+	case lc3.OpJsr:
+		if i.ImmediateMode {
+			op, comment := target()
+			return "JSR " + op, comment
+		}
+		return "JSRR " + register(i.SR1), ""
+	case lc3.OpLdr, lc3.OpStr:
+		name := "LDR"
+		if i.Opcode == lc3.OpStr {
+			name = "STR"
+		}
+		return fmt.Sprintf("%s %s, %s, %s", name, register(i.DR), register(i.SR1), signed(i.Immediate)), ""
+	case lc3.OpRti:
+		return "RTI", ""
+	case lc3.OpNot:
+		return fmt.Sprintf("NOT %s, %s", register(i.DR), register(i.SR1)), ""
+	case lc3.OpJmp:
+		if i.SR1 == lc3.RegR7 {
+			return "RET", ""
+		}
+		return "JMP " + register(i.SR1), ""
+	case lc3.OpTrap:
+		names := map[lc3.Word]string{
+			lc3.TrapVectGetc:  "GETC",
+			lc3.TrapVectOut:   "OUT",
+			lc3.TrapVectPuts:  "PUTS",
+			lc3.TrapVectIn:    "IN",
+			lc3.TrapVectPutsp: "PUTSP",
+			lc3.TrapVectHalt:  "HALT",
+		}
+		if name, ok := names[i.Immediate]; ok {
+			return name, ""
+		}
+		return fmt.Sprintf("TRAP x%02X", i.Immediate), ""
+	}
+
+	return fmt.Sprintf(".FILL x%04X", word), ""
+}
@@ -0,0 +1,106 @@
+package disasm
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// assemble source produced by disassembler and return words of all sections
+func reassemble(t *testing.T, source string) *lc3.Program {
+	program, err := (&lc3.Assembler{}).Assemble("disasm.asm", strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("cannot assemble disassembled code: %v\n%s", err, source)
+	}
+	return program
+}
+
+func Test_RoundTripRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 200; n++ {
+		words := make([]lc3.Word, 1+random.Intn(64))
+		for i := range words {
+			switch random.Intn(4) {
+			case 0: // printable characters make strings
+				words[i] = lc3.Word(0x20 + random.Intn(0x5F))
+			case 1:
+				words[i] = 0
+			default:
+				words[i] = lc3.Word(random.Intn(0x10000))
+			}
+		}
+		origin := lc3.Word(0x3000 + random.Intn(0x1000))
+
+		source := Disassemble(origin, words)
+		program := reassemble(t, source)
+		if len(program.Sections) != 1 || program.Sections[0].Origin != origin ||
+			!reflect.DeepEqual(program.Sections[0].Words, words) {
+			t.Fatalf("%d: words differ after round trip\n%s", n, source)
+		}
+	}
+}
+
+const roundTripTestCode = `
+		.orig x3000
+		lea r0, hello
+		puts
+		ld r1, count
+loop	add r1, r1, #-1
+		brp loop
+		ldi r2, pointer
+		st r2, result
+		jsr sub
+		halt
+sub		and r3, r3, #0
+		ret
+count	.fill #3
+pointer	.fill count
+result	.fill #0
+hello	.stringz "Hello,\n\"world\""
+		.end`
+
+func Test_RoundTripProgram(t *testing.T) {
+	program := reassemble(t, roundTripTestCode)
+
+	source := DisassembleProgram(program)
+	if !reflect.DeepEqual(reassemble(t, source).Sections, program.Sections) {
+		t.Fatalf("words differ after round trip\n%s", source)
+	}
+
+	for _, expected := range []string{
+		"LOOP\tADD R1, R1, #-1\n",
+		"\tBRP LOOP\n",
+		"\tJSR SUB\n",
+		"COUNT\t.FILL x0003\n",
+		"POINTER\t.FILL x300B\n",
+		"HELLO\t.STRINGZ \"Hello,\\n\\\"world\\\"\"\n",
+	} {
+		if !strings.Contains(source, expected) {
+			t.Errorf("expected %q in disassembly:\n%s", expected, source)
+		}
+	}
+
+	var obj bytes.Buffer
+	if err := program.WriteObj(&obj); err != nil {
+		t.Fatal(err)
+	}
+	fromObj, err := DisassembleObj(&obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(fromObj, "L300B\t.FILL x0003\n") || !strings.Contains(fromObj, "\tLD R1, L300B\n") {
+		t.Errorf("expected generated labels in disassembly:\n%s", fromObj)
+	}
+}
+
+func Test_DisassembleOutOfRange(t *testing.T) {
+	source := Disassemble(0x3000, []lc3.Word{lc3.NewBR(lc3.FlZ, lc3.MakeNegative(-5)), lc3.NewHalt()})
+	if !strings.Contains(source, "\tBRZ #-5 ; x2FFC\n") {
+		t.Errorf("expected numeric offset for out of range target:\n%s", source)
+	}
+}
@@ -12,9 +12,44 @@ const (
 	ModeDec
 )
 
-var encodingLiteralMode = ModeHex
+// Formatter controls how instructions and operands are printed.
+// it is passed by value, so differently configured formatters can be used concurrently.
+// zero value prints unsigned hex literals, upper case mnemonics and PC-relative offsets
+type Formatter struct {
+	Mode NumericLiteralMode
+	// print immediates and offsets as signed numbers: #-1 instead of #65535
+	Signed bool
+	// print hex digits in upper case
+	UpperHex bool
+	// print mnemonics and registers in lower case
+	Lowercase bool
+	// print addresses PC-relative instructions refer to instead of offsets. used by EncodeInstructionAt
+	Targets bool
+	// names of registers R0-R7. empty names are printed as R0-R7
+	RegisterNames [8]string
+	// print labels and strings of lines parsed by Assembler.ParseSource as they were written
+	Source bool
+	// print numbers of lines parsed by Assembler.ParseSource in the base they were written in.
+	// numbers written with a minus sign stay signed
+	SourceBase bool
+}
+
+// formatter used by EncodeInstructionAt and Dump
+var targetsFormatter = Formatter{Targets: true}
+
+// formatter used by Operand.String and Line.String. keeps the way assembler sources were always printed
+var sourceFormatter = Formatter{UpperHex: true}
 
-var instructionEncoders = map[Word]func(Word) string{
+// how PC-relative offsets are printed
+type encoding struct {
+	Formatter
+	// address of the instruction
+	address Word
+	// address is known
+	located bool
+}
+
+var instructionEncoders = map[Word]func(encoding, Word) string{
 	OpBr:   encodeBR,
 	OpAdd:  encodeAdd,
 	OpLd:   encodeLd,
@@ -33,29 +68,73 @@ var instructionEncoders = map[Word]func(Word) string{
 	OpTrap: encodeTrap,
 }
 
-func SetEncodeNumericLiteralMode(mode NumericLiteralMode) {
-	encodingLiteralMode = mode
+// EncodeInstruction prints instruction with default formatting
+func EncodeInstruction(instruction Word) string {
+	return Formatter{}.EncodeInstruction(instruction)
+}
+
+// EncodeInstructionAt prints instruction located at address.
+// PC-relative offsets are replaced by addresses they refer to
+func EncodeInstructionAt(address Word, instruction Word) string {
+	return targetsFormatter.EncodeInstructionAt(address, instruction)
 }
 
-func EncodeInstruction(instruction Word) string {
+// EncodeInstruction prints instruction. PC-relative offsets are printed as is
+func (f Formatter) EncodeInstruction(instruction Word) string {
+	opcode := getOpcode(instruction)
+	return instructionEncoders[opcode](encoding{Formatter: f}, instruction)
+}
+
+// EncodeInstructionAt prints instruction located at address.
+// PC-relative offsets are replaced by addresses they refer to if f.Targets is set
+func (f Formatter) EncodeInstructionAt(address Word, instruction Word) string {
 	opcode := getOpcode(instruction)
-	return instructionEncoders[opcode](instruction)
+	return instructionEncoders[opcode](encoding{Formatter: f, address: address, located: true}, instruction)
 }
 
-func encodeNumericLiteral(value Word) string {
-	if encodingLiteralMode == ModeHex {
-		return fmt.Sprintf("x%x", value)
-	} else if encodingLiteralMode == ModeDec {
-		return fmt.Sprintf("#%d", value)
+// print numeric literal. signed values are printed with minus sign if f.Signed is set
+func (f Formatter) literal(value Word, signed bool) string {
+	if signed && f.Signed && int16(value) < 0 {
+		return f.number("-", Word(-int16(value)))
 	}
-	panic(encodingLiteralMode)
+	return f.number("", value)
 }
 
-func encodeRegister(register Word) string {
-	return fmt.Sprintf("R%d", register)
+func (f Formatter) number(sign string, value Word) string {
+	switch f.Mode {
+	case ModeHex:
+		if f.UpperHex {
+			return fmt.Sprintf("x%s%X", sign, value)
+		}
+		return fmt.Sprintf("x%s%x", sign, value)
+	case ModeDec:
+		return fmt.Sprintf("#%s%d", sign, value)
+	}
+	panic(f.Mode)
+}
+
+func (f Formatter) register(register Word) string {
+	if name := f.RegisterNames[register&0x7]; name != "" {
+		return name
+	}
+	return f.mnemonic(fmt.Sprintf("R%d", register))
 }
 
-func encodeBR(instruction Word) string {
+func (f Formatter) mnemonic(mnemonic string) string {
+	if f.Lowercase {
+		return strings.ToLower(mnemonic)
+	}
+	return mnemonic
+}
+
+func (e encoding) encodePCOffset(offset Word) string {
+	if e.Targets && e.located {
+		return e.literal(e.address+1+offset, false)
+	}
+	return e.literal(offset, true)
+}
+
+func encodeBR(e encoding, instruction Word) string {
 	var flagsMapping = map[Word]string{
 		/* nzp */
 		/* 000 */ 0: "BR",
@@ -69,186 +148,190 @@ func encodeBR(instruction Word) string {
 	}
 	flags := getNBits(instruction, 9, 3)
 	if flags == 0 {
-		return "NOP"
+		return e.mnemonic("NOP")
 	}
-	return fmt.Sprintf("%s %s", flagsMapping[flags], encodeNumericLiteral(getNBitsExtended(instruction, 0, 9)))
+	return fmt.Sprintf("%s %s", e.mnemonic(flagsMapping[flags]), e.encodePCOffset(getNBitsExtended(instruction, 0, 9)))
 }
 
-func encodeAdd(instruction Word) string {
+func encodeAdd(e encoding, instruction Word) string {
 	var ret strings.Builder
-	ret.WriteString("ADD ")
+	ret.WriteString(e.mnemonic("ADD "))
 	if getNBits(instruction, 5, 1) == 0 {
-		ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+		ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 		ret.WriteString(", ")
-		ret.WriteString(encodeRegister(getNBits(instruction, 6, 3)))
+		ret.WriteString(e.register(getNBits(instruction, 6, 3)))
 		ret.WriteString(", ")
-		ret.WriteString(encodeRegister(getNBits(instruction, 0, 3)))
+		ret.WriteString(e.register(getNBits(instruction, 0, 3)))
 	} else {
-		ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+		ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 		ret.WriteString(", ")
-		ret.WriteString(encodeRegister(getNBits(instruction, 6, 3)))
+		ret.WriteString(e.register(getNBits(instruction, 6, 3)))
 		ret.WriteString(", ")
-		ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 5)))
+		ret.WriteString(e.literal(getNBitsExtended(instruction, 0, 5), true))
 	}
 	return ret.String()
 }
 
-func encodeLd(instruction Word) string {
+func encodeLd(e encoding, instruction Word) string {
 	var ret strings.Builder
-	ret.WriteString("LD ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+	ret.WriteString(e.mnemonic("LD "))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 9)))
+	ret.WriteString(e.encodePCOffset(getNBitsExtended(instruction, 0, 9)))
 	return ret.String()
 }
 
-func encodeSt(instruction Word) string {
+func encodeSt(e encoding, instruction Word) string {
 	var ret strings.Builder
-	ret.WriteString("ST ")
-	ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 9)))
+	ret.WriteString(e.mnemonic("ST "))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
+	ret.WriteString(", ")
+	ret.WriteString(e.encodePCOffset(getNBitsExtended(instruction, 0, 9)))
 	return ret.String()
 }
 
-func encodeJsr(instruction Word) string {
+func encodeJsr(e encoding, instruction Word) string {
 	var ret strings.Builder
 
 	if getNBits(instruction, 11, 1) == 1 {
-		ret.WriteString("JSR ")
-		ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 11)))
+		ret.WriteString(e.mnemonic("JSR "))
+		ret.WriteString(e.encodePCOffset(getNBitsExtended(instruction, 0, 11)))
 	} else {
-		ret.WriteString("JSRR ")
-		ret.WriteString(encodeRegister(getNBits(instruction, 6, 3)))
+		ret.WriteString(e.mnemonic("JSRR "))
+		ret.WriteString(e.register(getNBits(instruction, 6, 3)))
 	}
 
 	return ret.String()
 }
 
-func encodeAnd(instruction Word) string {
+func encodeAnd(e encoding, instruction Word) string {
 	var ret strings.Builder
 
-	ret.WriteString("AND ")
+	ret.WriteString(e.mnemonic("AND "))
 
-	ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 6, 3)))
+	ret.WriteString(e.register(getNBits(instruction, 6, 3)))
 	if getNBits(instruction, 5, 1) == 0 {
 		ret.WriteString(", ")
-		ret.WriteString(encodeRegister(getNBits(instruction, 0, 3)))
+		ret.WriteString(e.register(getNBits(instruction, 0, 3)))
 	} else {
 		ret.WriteString(", ")
-		ret.WriteString(encodeNumericLiteral(getNBits(instruction, 0, 5)))
+		ret.WriteString(e.literal(getNBitsExtended(instruction, 0, 5), true))
 	}
 	return ret.String()
 }
 
-func encodeLdr(instruction Word) string {
+func encodeLdr(e encoding, instruction Word) string {
 	var ret strings.Builder
-	ret.WriteString("LDR ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+	ret.WriteString(e.mnemonic("LDR "))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 6, 3)))
+	ret.WriteString(e.register(getNBits(instruction, 6, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 6)))
+	ret.WriteString(e.literal(getNBitsExtended(instruction, 0, 6), true))
 	return ret.String()
 }
 
-func encodeStr(instruction Word) string {
+func encodeStr(e encoding, instruction Word) string {
 	var ret strings.Builder
-	ret.WriteString("STR ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+	ret.WriteString(e.mnemonic("STR "))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 6, 3)))
+	ret.WriteString(e.register(getNBits(instruction, 6, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 6)))
+	ret.WriteString(e.literal(getNBitsExtended(instruction, 0, 6), true))
 	return ret.String()
 }
 
-func encodeRti(instruction Word) string {
-	return ""
+func encodeRti(e encoding, instruction Word) string {
+	return e.mnemonic("RTI")
 }
 
-func encodeNot(instruction Word) string {
+func encodeNot(e encoding, instruction Word) string {
 	var ret strings.Builder
 
-	ret.WriteString("NOT ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+	ret.WriteString(e.mnemonic("NOT "))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 6, 3)))
+	ret.WriteString(e.register(getNBits(instruction, 6, 3)))
 
 	return ret.String()
 }
 
-func encodeLdi(instruction Word) string {
+func encodeLdi(e encoding, instruction Word) string {
 	var ret strings.Builder
 
-	ret.WriteString("LDI ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+	ret.WriteString(e.mnemonic("LDI "))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 9)))
+	ret.WriteString(e.encodePCOffset(getNBitsExtended(instruction, 0, 9)))
 
 	return ret.String()
 }
 
-func encodeSti(instruction Word) string {
+func encodeSti(e encoding, instruction Word) string {
 	var ret strings.Builder
 
-	ret.WriteString("STI ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+	ret.WriteString(e.mnemonic("STI "))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 9)))
+	ret.WriteString(e.encodePCOffset(getNBitsExtended(instruction, 0, 9)))
 
 	return ret.String()
 }
 
-func encodeJmp(instruction Word) string {
+func encodeJmp(e encoding, instruction Word) string {
 	var ret strings.Builder
 
 	register := getNBits(instruction, 6, 3)
 
 	if register == RegR7 {
-		ret.WriteString("RET")
+		ret.WriteString(e.mnemonic("RET"))
 	} else {
-		ret.WriteString("JMP ")
-		ret.WriteString(encodeRegister(register))
+		ret.WriteString(e.mnemonic("JMP "))
+		ret.WriteString(e.register(register))
 	}
 
 	return ret.String()
 }
 
-func encodeRes(instruction Word) string {
-	return "RES"
+func encodeRes(e encoding, instruction Word) string {
+	return e.mnemonic("RES")
 }
 
-func encodeLea(instruction Word) string {
+func encodeLea(e encoding, instruction Word) string {
 	var ret strings.Builder
 
-	ret.WriteString("LEA ")
-	ret.WriteString(encodeRegister(getNBits(instruction, 9, 3)))
+	ret.WriteString(e.mnemonic("LEA "))
+	ret.WriteString(e.register(getNBits(instruction, 9, 3)))
 	ret.WriteString(", ")
-	ret.WriteString(encodeNumericLiteral(getNBitsExtended(instruction, 0, 9)))
+	ret.WriteString(e.encodePCOffset(getNBitsExtended(instruction, 0, 9)))
 
 	return ret.String()
 }
 
-func encodeTrap(instruction Word) string {
+func encodeTrap(e encoding, instruction Word) string {
 	var ret strings.Builder
 
-	vector := getNBitsExtended(instruction, 0, 8)
+	vector := getNBits(instruction, 0, 8)
 	switch vector {
 	case TrapVectGetc:
-		return "GETC"
+		return e.mnemonic("GETC")
 	case TrapVectOut:
-		return "OUT"
+		return e.mnemonic("OUT")
 	case TrapVectPuts:
-		return "PUTS"
+		return e.mnemonic("PUTS")
 	case TrapVectIn:
-		return "IN"
+		return e.mnemonic("IN")
+	case TrapVectPutsp:
+		return e.mnemonic("PUTSP")
 	case TrapVectHalt:
-		return "HALT"
+		return e.mnemonic("HALT")
 	}
 
-	ret.WriteString("TRAP ")
-	ret.WriteString(encodeNumericLiteral(vector))
+	ret.WriteString(e.mnemonic("TRAP "))
+	ret.WriteString(e.literal(vector, false))
 
 	return ret.String()
 }
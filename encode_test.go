@@ -0,0 +1,133 @@
+package lc3
+
+import "testing"
+
+func Test_EncodeInstruction(t *testing.T) {
+	type testCase struct {
+		instruction Word
+		encoded     string
+	}
+
+	testData := []testCase{
+		{NewSt(RegR3, 2), "ST R3, x2"},
+		{NewAndImmediate(RegR1, RegR2, MakeNegative(-1)), "AND R1, R2, xffff"},
+		{NewRti(), "RTI"},
+		{NewPutsp(), "PUTSP"},
+		{NewTrap(0x80), "TRAP x80"},
+		{NewBR(FlZ, MakeNegative(-2)), "BRZ xfffe"},
+	}
+
+	for i := range testData {
+		encoded := EncodeInstruction(testData[i].instruction)
+		if encoded != testData[i].encoded {
+			t.Errorf("%d: expected %q, got %q", i, testData[i].encoded, encoded)
+		}
+	}
+}
+
+func Test_EncodeInstructionAt(t *testing.T) {
+	type testCase struct {
+		address     Word
+		instruction Word
+		encoded     string
+	}
+
+	testData := []testCase{
+		{0x3000, NewBR(FlZ, MakeNegative(-2)), "BRZ x2fff"},
+		{0x3000, NewLd(RegR0, 5), "LD R0, x3006"},
+		{0x3000, NewSt(RegR1, 5), "ST R1, x3006"},
+		{0x3000, NewJsr(0x10), "JSR x3011"},
+		{0x3000, NewLea(RegR2, 0), "LEA R2, x3001"},
+		{0x3000, NewJsrr(RegR4), "JSRR R4"},
+		{0x3000, NewLdr(RegR0, RegR6, 1), "LDR R0, R6, x1"},
+	}
+
+	for i := range testData {
+		encoded := EncodeInstructionAt(testData[i].address, testData[i].instruction)
+		if encoded != testData[i].encoded {
+			t.Errorf("%d: expected %q, got %q", i, testData[i].encoded, encoded)
+		}
+	}
+}
+
+func Test_DecodeInstruction(t *testing.T) {
+	type testCase struct {
+		instruction Word
+		canonical   bool
+	}
+
+	testData := []testCase{
+		{NewAddRegister(RegR1, RegR2, RegR3), true},
+		{NewAddRegister(RegR1, RegR2, RegR3) | 1<<3, false}, // unused bits are set
+		{NewAndImmediate(RegR0, RegR0, MakeNegative(-16)), true},
+		{NewJsr(MakeNegative(-1024)), true},
+		{NewJsrr(RegR3), true},
+		{NewJmp(RegR2) | 1, false},
+		{NewRet(), true},
+		{NewTrap(0xFF), true},
+		{NewRti(), true},
+		{0, false}, // BR without flags
+		{OpRes << 12, false},
+	}
+
+	for i := range testData {
+		if IsCanonical(testData[i].instruction) != testData[i].canonical {
+			t.Errorf("%d: expected canonical = %v for %016b", i, testData[i].canonical, testData[i].instruction)
+		}
+	}
+
+	decoded := DecodeInstruction(NewLdr(RegR5, RegR6, MakeNegative(-3)))
+	if decoded.Opcode != OpLdr || decoded.DR != RegR5 || decoded.SR1 != RegR6 || decoded.Immediate != MakeNegative(-3) {
+		t.Errorf("unexpected decoded instruction %+v", decoded)
+	}
+
+	if target, ok := DecodeInstruction(NewBR(FlN, MakeNegative(-1))).Target(0x3000); !ok || target != 0x3000 {
+		t.Errorf("unexpected target x%04X", target)
+	}
+}
+
+func Test_Formatter(t *testing.T) {
+	type testCase struct {
+		formatter   Formatter
+		instruction Word
+		encoded     string
+	}
+
+	names := [8]string{6: "SP", 7: "LR"}
+
+	testData := []testCase{
+		{Formatter{}, NewAddImmediate(RegR1, RegR2, MakeNegative(-3)), "ADD R1, R2, xfffd"},
+		{Formatter{Signed: true}, NewAddImmediate(RegR1, RegR2, MakeNegative(-3)), "ADD R1, R2, x-3"},
+		{Formatter{Mode: ModeDec}, NewAddImmediate(RegR1, RegR2, MakeNegative(-3)), "ADD R1, R2, #65533"},
+		{Formatter{Mode: ModeDec, Signed: true}, NewAddImmediate(RegR1, RegR2, MakeNegative(-3)), "ADD R1, R2, #-3"},
+		{Formatter{Mode: ModeDec, Signed: true}, NewTrap(0xFF), "TRAP #255"},
+		{Formatter{UpperHex: true}, NewTrap(0xFF), "TRAP xFF"},
+		{Formatter{Lowercase: true}, NewLdr(RegR0, RegR6, 1), "ldr r0, r6, x1"},
+		{Formatter{Lowercase: true, RegisterNames: names}, NewLdr(RegR0, RegR6, 1), "ldr r0, SP, x1"},
+		{Formatter{Lowercase: true}, NewHalt(), "halt"},
+		{Formatter{Signed: true, Mode: ModeDec}, NewBR(FlN, MakeNegative(-2)), "BRN #-2"},
+		{Formatter{Targets: true}, NewBR(FlN, MakeNegative(-2)), "BRN x2fff"},
+	}
+
+	for i := range testData {
+		encoded := testData[i].formatter.EncodeInstructionAt(0x3000, testData[i].instruction)
+		if encoded != testData[i].encoded {
+			t.Errorf("%d: expected %q, got %q", i, testData[i].encoded, encoded)
+		}
+	}
+
+	// offsets are printed when address of the instruction is unknown
+	if encoded := (Formatter{Targets: true}).EncodeInstruction(NewLd(RegR0, 1)); encoded != "LD R0, x1" {
+		t.Errorf("unexpected %q", encoded)
+	}
+
+	line := Line{Label: "LOOP", Opcode: "ADD", Comment: "comment"}
+	r1, number := "R1", MakeNegative(-1)
+	line.Operands = []Operand{{register: &r1}, {register: &r1}, {number: &number}}
+	if formatted := line.String(); formatted != "LOOP ADD R1, R1, xFFFF ;comment" {
+		t.Errorf("unexpected %q", formatted)
+	}
+	if formatted := line.Format(Formatter{Mode: ModeDec, Signed: true, Lowercase: true}); formatted != "LOOP add r1, r1, #-1 ;comment" {
+		t.Errorf("unexpected %q", formatted)
+	}
+}
@@ -0,0 +1,91 @@
+package lc3
+
+// Engine executes instructions of a VM. all engines give the same results
+type Engine int
+
+const (
+	// EngineInterpreter decodes every instruction when it is executed
+	EngineInterpreter Engine = iota
+	// EngineDecodeCache executes instructions decoded once, see SetDecodeCache
+	EngineDecodeCache
+	// EngineBlocks compiles basic blocks into chains of closures. Run executes whole blocks.
+	// the VM doesn't model interrupts: RTI is a bad instruction and nothing is checked between blocks
+	EngineBlocks
+	// EngineMicro executes microcycles of the textbook datapath and state machine, see Microstep
+	EngineMicro
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineInterpreter:
+		return "interpreter"
+	case EngineDecodeCache:
+		return "cache"
+	case EngineBlocks:
+		return "blocks"
+	case EngineMicro:
+		return "micro"
+	}
+	return "unknown"
+}
+
+// NewVMWithEngine creates VM executing instructions by engine
+func NewVMWithEngine(memorySize int, engine Engine) *VM {
+	ret := NewVM(memorySize)
+	switch engine {
+	case EngineDecodeCache:
+		ret.SetDecodeCache(true)
+	case EngineBlocks:
+		ret.blocks = newBlockCache(memorySize)
+	case EngineMicro:
+		ret.micro = &Datapath{State: stateFetch}
+	}
+	return ret
+}
+
+// NewVMWithEngine creates VM with full memory executing instructions by engine and loads program into it
+func (p *Program) NewVMWithEngine(engine Engine) *VM {
+	ret := NewVMWithEngine(WordMax+1, engine)
+	p.Load(ret)
+	return ret
+}
+
+// Engine returns engine executing instructions
+func (m *VM) Engine() Engine {
+	switch {
+	case m.micro != nil:
+		return EngineMicro
+	case m.blocks != nil:
+		return EngineBlocks
+	case m.decoded != nil:
+		return EngineDecodeCache
+	}
+	return EngineInterpreter
+}
+
+// Run executes instructions until the program stops, an instruction fails like in Step or limit instructions
+// are executed. zero limit means no limit. nil is returned when the program stops or the limit is reached
+func (m *VM) Run(limit uint) error {
+	if !m.running {
+		return ErrNotRunning
+	}
+	// cycles are counted by Step
+	if m.timing == nil {
+		switch {
+		case m.blocks != nil:
+			return m.runBlocks(limit)
+		case m.decoded != nil && m.micro == nil:
+			return m.runDecoded(limit)
+		}
+	}
+	start := m.instructionsExecuted
+	for m.running {
+		if limit > 0 && m.instructionsExecuted-start >= limit {
+			break
+		}
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package lc3
+
+import (
+	"strings"
+	"testing"
+)
+
+type engineTestCase struct {
+	name string
+	code string
+	// input of the console and of the keyboard
+	input string
+	stdin string
+	// protect code with CodeWriteException
+	protect bool
+}
+
+var engineTestCases = []engineTestCase{
+	{name: "self-modifying", code: selfModifyingProgram},
+	{name: "same block", code: `
+			.orig x3000
+			loop	ld r0, nop
+					st r0, target
+			target	add r1, r1, #1
+					ld r0, inc
+					st r0, target
+					add r2, r2, #1
+					brnzp loop
+			nop		.fill x0000
+			inc		add r1, r1, #1
+			.end`},
+	{name: "jsrr r7", code: `
+			.orig x3000
+					lea r7, sub
+					jsrr r7
+					halt
+			sub		add r0, r0, #1
+					halt
+			.end`},
+	{name: "patched loop", code: `
+			.orig x3000
+			loop	add r1, r1, #1
+					add r2, r2, #1
+					add r3, r2, #-5
+					brn loop
+					ld r0, nop
+					st r0, loop
+					and r2, r2, #0
+					add r4, r4, #1
+					add r5, r4, #-3
+					brn loop
+					halt
+			nop		.fill x0000
+			.end`},
+	{name: "keyboard", stdin: "echo\n", code: `
+			.orig x3000
+			poll	ldi r0, kbsr
+					brzp poll
+					ldi r0, kbdr
+					sti r0, ddr
+					add r1, r0, #-10
+					brnp poll
+					and r0, r0, #0
+					sti r0, mcr
+			kbsr	.fill xfe00
+			kbdr	.fill xfe02
+			ddr		.fill xfe06
+			mcr		.fill xfffe
+			.end`},
+	{name: "traps", input: "ab", code: `
+			.orig x3000
+					getc
+					out
+					lea r0, hello
+					puts
+					in
+					getc
+					halt
+			hello	.stringz "hello"
+			.end`},
+	{name: "bad instruction", code: `
+			.orig x3000
+					add r0, r0, #1
+					add r0, r0, #1
+					rti
+			.end`},
+	{name: "protected", protect: true, code: `
+			.orig x3000
+					add r0, r0, #1
+					st r0, here
+			here	halt
+			.end`},
+}
+
+// state of a VM run by Step or Run
+type engineRun struct {
+	vm      *VM
+	console *testConsole
+	err     error
+}
+
+func newEngineRun(t *testing.T, test engineTestCase, engine Engine) *engineRun {
+	program, err := (&Assembler{}).Assemble(test.name+".asm", strings.NewReader(test.code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := &engineRun{vm: program.NewVMWithEngine(engine), console: &testConsole{input: test.input}}
+	ret.vm.Console = ret.console
+	if test.protect {
+		ret.vm.CodeWriteAction = CodeWriteException
+		program.ProtectCode(ret.vm)
+	}
+	ret.vm.Start()
+	for i := 0; i < len(test.stdin); i++ {
+		ret.vm.Stdin <- Word(test.stdin[i])
+	}
+	return ret
+}
+
+func (r *engineRun) compare(t *testing.T, expected *engineRun) {
+	t.Helper()
+	if r.err != expected.err {
+		t.Fatalf("expected error %v, got %v", expected.err, r.err)
+	}
+	if r.vm.registers != expected.vm.registers {
+		t.Fatalf("expected registers %v, got %v", expected.vm.registers, r.vm.registers)
+	}
+	if r.vm.instructionsExecuted != expected.vm.instructionsExecuted || r.vm.running != expected.vm.running {
+		t.Fatalf("expected %d instructions executed and running %v, got %d and %v",
+			expected.vm.instructionsExecuted, expected.vm.running, r.vm.instructionsExecuted, r.vm.running)
+	}
+	if string(r.console.output) != string(expected.console.output) {
+		t.Fatalf("expected output %q, got %q", expected.console.output, r.console.output)
+	}
+}
+
+func (r *engineRun) compareMemory(t *testing.T, expected *engineRun) {
+	t.Helper()
+	for address := range expected.vm.memory {
+		if r.vm.memory[address] != expected.vm.memory[address] {
+			t.Fatalf("expected x%04X at x%04X, got x%04X", expected.vm.memory[address], address, r.vm.memory[address])
+		}
+	}
+}
+
+// engines must give the same results as the interpreter, step by step and when running whole blocks
+func Test_Engines(t *testing.T) {
+	const steps = 1000
+
+	tests := engineTestCases
+	for _, p := range benchmarkPrograms {
+		tests = append(tests, engineTestCase{name: p.name, code: p.code})
+	}
+
+	for _, test := range tests {
+		for _, engine := range []Engine{EngineDecodeCache, EngineBlocks, EngineMicro} {
+			t.Run(test.name+"/"+engine.String(), func(t *testing.T) {
+				expected := newEngineRun(t, test, EngineInterpreter)
+				stepped := newEngineRun(t, test, engine)
+				if stepped.vm.Engine() != engine {
+					t.Fatalf("expected engine %s, got %s", engine, stepped.vm.Engine())
+				}
+				for i := 0; i < steps && expected.err == nil; i++ {
+					expected.err = expected.vm.Step()
+					stepped.err = stepped.vm.Step()
+					stepped.compare(t, expected)
+				}
+				stepped.compareMemory(t, expected)
+
+				// Run stops exactly at the limit
+				for _, chunk := range []uint{1, 7, steps} {
+					run := newEngineRun(t, test, engine)
+					for run.err == nil && run.vm.running && run.vm.instructionsExecuted < expected.vm.instructionsExecuted {
+						limit := expected.vm.instructionsExecuted - run.vm.instructionsExecuted
+						if limit > chunk {
+							limit = chunk
+						}
+						run.err = run.vm.Run(limit)
+					}
+					// failed instruction is not counted
+					if expected.err != nil && run.err == nil {
+						run.err = run.vm.Run(1)
+					}
+					run.compare(t, expected)
+					run.compareMemory(t, expected)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package lc3
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// minimal width of the label column. opcodes of lines without labels are indented by it
+const minLabelWidth = 8
+
+// FormatSource prints file parsed by Assembler.ParseSource with aligned columns.
+// labels start at the beginning of a line, opcodes are aligned in the whole file.
+// operands and comments are aligned in paragraphs separated by blank lines.
+// comments on their own lines keep starting at the beginning of the line if they did
+func FormatSource(writer io.Writer, file *File, f Formatter) error {
+	w := bufio.NewWriter(writer)
+	lines := file.Lines
+
+	// trailing blank lines are replaced by a single line break
+	for len(lines) > 0 && lines[len(lines)-1].isBlank() {
+		lines = lines[:len(lines)-1]
+	}
+
+	labelWidth := minLabelWidth
+	for i := range lines {
+		labelWidth = maxInt(labelWidth, len(lines[i].label(f))+1)
+	}
+
+	for start := 0; start < len(lines); {
+		end := start
+		for end < len(lines) && !lines[end].isBlank() {
+			end++
+		}
+
+		formatParagraph(w, lines[start:end], f, labelWidth)
+
+		// blank lines are kept as is
+		for ; end < len(lines) && lines[end].isBlank(); end++ {
+			w.WriteString("\n")
+		}
+		start = end
+	}
+
+	return w.Flush()
+}
+
+func formatParagraph(w *bufio.Writer, lines []Line, f Formatter, labelWidth int) {
+	opcodeWidth := 0
+	operandsWidth := 0
+	for i := range lines {
+		if lines[i].Opcode == "" {
+			continue
+		}
+		opcodeWidth = maxInt(opcodeWidth, len(lines[i].Opcode)+1)
+		if lines[i].hasComment {
+			operandsWidth = maxInt(operandsWidth, len(lines[i].operands(f))+1)
+		}
+	}
+
+	for i := range lines {
+		line := &lines[i]
+
+		var buffer strings.Builder
+		pad := func(width int) {
+			buffer.WriteByte(' ')
+			for buffer.Len() < width {
+				buffer.WriteByte(' ')
+			}
+		}
+
+		// lines after .END
+		if line.Label == "" && line.Opcode == "" && !line.hasComment {
+			w.WriteString(strings.TrimRight(line.source, " \t"))
+			w.WriteString("\n")
+			continue
+		}
+
+		if line.Label != "" {
+			buffer.WriteString(line.label(f))
+		}
+
+		if line.Opcode != "" {
+			pad(labelWidth)
+			buffer.WriteString(f.mnemonic(line.Opcode))
+			if operands := line.operands(f); operands != "" {
+				pad(labelWidth + opcodeWidth)
+				buffer.WriteString(operands)
+			}
+			if line.hasComment {
+				pad(labelWidth + opcodeWidth + operandsWidth)
+			}
+		} else if line.hasComment && (line.Label != "" || strings.IndexByte(line.source, ';') > 0) {
+			pad(labelWidth)
+		}
+
+		if line.hasComment {
+			buffer.WriteString(";")
+			buffer.WriteString(strings.TrimRight(line.Comment, " \t"))
+		}
+
+		w.WriteString(buffer.String())
+		w.WriteString("\n")
+	}
+}
+
+// line without label, opcode and comment
+func (l *Line) isBlank() bool {
+	return l.Label == "" && l.Opcode == "" && !l.hasComment && strings.TrimSpace(l.source) == ""
+}
+
+// comma separated operands printed by f
+func (l *Line) operands(f Formatter) string {
+	var operands []string
+	for i := range l.Operands {
+		operands = append(operands, l.Operands[i].format(f, l.signedOperands()))
+	}
+	return strings.Join(operands, ", ")
+}
+
+// numbers of the line are immediates and offsets rather than addresses, words or trap vectors
+func (l *Line) signedOperands() bool {
+	switch l.Opcode {
+	case stropOrig, stropFill, stropBlkw, stropTrap:
+		return false
+	}
+	return true
+}
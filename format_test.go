@@ -0,0 +1,161 @@
+package lc3
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const formatTestCode = `; header comment
+   .orig x3000
+main  lea r0, Hello ; load
+  inc r1
+loop add R1,r1,#-1
+   brp loop    ; again
+	;indented comment
+.if 0
+  add r1, r1, #2
+.endif
+.fill xffff
+
+Hello .stringz "hi\e\n"
+  .end
+text after end
+`
+
+const formatTestExpected = `; header comment
+        .ORIG    x3000
+main    LEA      R0, Hello ; load
+        INC      R1
+loop    ADD      R1, R1, #-1
+        BRP      loop      ; again
+        ;indented comment
+        .IF      0
+        ADD      R1, R1, #2
+        .ENDIF
+        .FILL    xFFFF
+
+Hello   .STRINGZ "hi\e\n"
+        .END
+text after end
+`
+
+func Test_FormatSource(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.asm": {Data: []byte(formatTestCode)},
+		"lib.asm":  {Data: []byte(".macro inc reg\nadd reg, reg, #1\n.endm\n")},
+	}
+	code := strings.Replace(formatTestCode, "; header comment\n", "; header comment\n.include \"lib.asm\"\n", 1)
+	expected := strings.Replace(formatTestExpected, "; header comment\n", "; header comment\n        .INCLUDE \"lib.asm\"\n", 1)
+
+	assembler := &Assembler{FS: fsys}
+	format := func(source string) string {
+		file, err := assembler.ParseSource("main.asm", strings.NewReader(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buffer bytes.Buffer
+		if err := FormatSource(&buffer, file, Formatter{UpperHex: true, Source: true, SourceBase: true}); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.String()
+	}
+
+	formatted := format(code)
+	if formatted != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+	if again := format(formatted); again != formatted {
+		t.Errorf("formatting is not idempotent:\n%s", again)
+	}
+
+	// formatted code assembles to the same words
+	original, err := assembler.Assemble("main.asm", strings.NewReader(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reformatted, err := assembler.Assemble("main.asm", strings.NewReader(formatted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(original.Sections, reformatted.Sections) {
+		t.Errorf("formatted code assembles differently")
+	}
+}
+
+func Test_FormatSourceLiterals(t *testing.T) {
+	file, err := (&Assembler{}).ParseSource("", strings.NewReader("LOOP add r1, r1, #-1\n brnzp loop\n.fill xffff\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	if err := FormatSource(&buffer, file, Formatter{Mode: ModeDec, Signed: true, Lowercase: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "LOOP    add   r1, r1, #-1\n        brnzp LOOP\n        .fill #65535\n"
+	if buffer.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+}
+
+// addresses, words and trap vectors are unsigned, formatted source assembles into the same words
+func Test_FormatSourceAddresses(t *testing.T) {
+	const code = `.orig xC000
+ld r0, kbsr
+add r0, r0, #-1
+trap xff
+kbsr .fill xFE00
+.fill #-2
+.blkw #1
+.end
+`
+	expected := map[NumericLiteralMode]string{
+		ModeHex: `        .orig xC000
+        ld    r0, KBSR
+        add   r0, r0, x-1
+        trap  xFF
+KBSR    .fill xFE00
+        .fill x-2
+        .blkw x1
+        .end
+`,
+		ModeDec: `        .orig #49152
+        ld    r0, KBSR
+        add   r0, r0, #-1
+        trap  #255
+KBSR    .fill #65024
+        .fill #-2
+        .blkw #1
+        .end
+`,
+	}
+
+	original, err := (&Assembler{}).Assemble("", strings.NewReader(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []NumericLiteralMode{ModeHex, ModeDec} {
+		file, err := (&Assembler{}).ParseSource("", strings.NewReader(code))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buffer bytes.Buffer
+		if err := FormatSource(&buffer, file, Formatter{Mode: mode, Signed: true, UpperHex: true, Lowercase: true}); err != nil {
+			t.Fatal(err)
+		}
+		if buffer.String() != expected[mode] {
+			t.Errorf("%d: expected:\n%s\ngot:\n%s", mode, expected[mode], buffer.String())
+		}
+
+		formatted, err := (&Assembler{}).Assemble("", &buffer)
+		if err != nil {
+			t.Fatalf("%d: %v", mode, err)
+		}
+		if !reflect.DeepEqual(formatted.Sections, original.Sections) {
+			t.Errorf("%d: expected sections %v, got %v", mode, original.Sections, formatted.Sections)
+		}
+	}
+}
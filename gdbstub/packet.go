@@ -0,0 +1,101 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// interrupt sent by the client to stop running program
+const interrupt = 0x03
+
+// packet or interrupt read from the client
+type input struct {
+	packet    string
+	interrupt bool
+}
+
+// read packets framed as $data#checksum and interrupts. acknowledgments are skipped,
+// packets with wrong checksum are requested again with '-' written to w
+func readInput(r *bufio.Reader, w io.Writer, ack func() bool) (input, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return input{}, err
+		}
+		switch c {
+		case interrupt:
+			return input{interrupt: true}, nil
+		case '$':
+		default:
+			// '+', '-' and garbage between packets
+			continue
+		}
+
+		var data []byte
+		var sum byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return input{}, err
+			}
+			if c == '#' {
+				break
+			}
+			sum += c
+			data = append(data, c)
+		}
+		var checksum [2]byte
+		if _, err := io.ReadFull(r, checksum[:]); err != nil {
+			return input{}, err
+		}
+
+		if fmt.Sprintf("%02x", sum) != string(checksum[:]) {
+			if ack() {
+				if _, err := w.Write([]byte{'-'}); err != nil {
+					return input{}, err
+				}
+			}
+			continue
+		}
+		if ack() {
+			if _, err := w.Write([]byte{'+'}); err != nil {
+				return input{}, err
+			}
+		}
+		return input{packet: string(unescape(data))}, nil
+	}
+}
+
+// binary data of X packet escapes '#', '$', '}' and '*' with '}' followed by the byte xor 0x20
+func unescape(data []byte) []byte {
+	var ret []byte
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			ret = append(ret, data[i]^0x20)
+			continue
+		}
+		ret = append(ret, data[i])
+	}
+	return ret
+}
+
+func writePacket(w io.Writer, data string) error {
+	var escaped []byte
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c == '#' || c == '$' || c == '}' || c == '*' {
+			escaped = append(escaped, '}')
+			sum += '}'
+			c ^= 0x20
+		}
+		escaped = append(escaped, c)
+		sum += c
+	}
+	_, err := fmt.Fprintf(w, "$%s#%02x", escaped, sum)
+	return errors.Wrap(err, "write packet")
+}
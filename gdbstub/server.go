@@ -0,0 +1,515 @@
+// Package gdbstub serves a debugged LC-3 program over GDB remote serial protocol.
+//
+// LC-3 memory is word addressed while the protocol addresses bytes, so the stub shows memory as an array
+// of 2*65536 bytes: word at address A occupies bytes 2*A and 2*A+1 in little endian order.
+// Breakpoint and watchpoint addresses are byte addresses too, and so is PC. Registers are
+// R0-R7, PC and PSR, 16 bits each, described by target.xml sent with qXfer:features:read.
+//
+// Supported packets are ?, g, G, p, P, m, M, X, s, c, vCont, Z0-Z4, z0-z4, D, k and queries
+// needed to connect. The running program is stopped by the interrupt byte 0x03.
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/debugger"
+	"github.com/pkg/errors"
+)
+
+// registers in the order of g packet and target.xml
+var registers = []int{lc3.RegR0, lc3.RegR1, lc3.RegR2, lc3.RegR3, lc3.RegR4, lc3.RegR5, lc3.RegR6, lc3.RegR7, lc3.RegPC, lc3.RegCond}
+
+// signals of stop replies
+const (
+	sigInt  = 2
+	sigIll  = 4
+	sigTrap = 5
+)
+
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.lc3.core">
+    <flags id="psr_flags" size="2">
+      <field name="P" start="0" end="0"/>
+      <field name="Z" start="1" end="1"/>
+      <field name="N" start="2" end="2"/>
+      <field name="USER" start="15" end="15"/>
+    </flags>
+    <reg name="r0" bitsize="16" type="int16" regnum="0"/>
+    <reg name="r1" bitsize="16" type="int16"/>
+    <reg name="r2" bitsize="16" type="int16"/>
+    <reg name="r3" bitsize="16" type="int16"/>
+    <reg name="r4" bitsize="16" type="int16"/>
+    <reg name="r5" bitsize="16" type="int16"/>
+    <reg name="r6" bitsize="16" type="int16"/>
+    <reg name="r7" bitsize="16" type="int16"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+    <reg name="psr" bitsize="16" type="psr_flags"/>
+  </feature>
+</target>
+`
+
+// Server serves a debugger to GDB clients
+type Server struct {
+	debugger *debugger.Debugger
+	// addresses of hardware breakpoints, other breakpoints are software
+	hardware map[lc3.Word]bool
+	// watchpoint kinds by address as requested by the client
+	watchpoints map[lc3.Word]debugger.WatchKind
+	// reply to the last stop, returned by ?
+	status string
+}
+
+// NewServer creates server for a program loaded into d. the program is stopped until the client continues it
+func NewServer(d *debugger.Debugger) *Server {
+	return &Server{
+		debugger:    d,
+		hardware:    make(map[lc3.Word]bool),
+		watchpoints: make(map[lc3.Word]debugger.WatchKind),
+		status:      fmt.Sprintf("S%02x", sigTrap),
+	}
+}
+
+// Serve accepts connections and serves them one at a time until the listener is closed
+// or a client kills the program
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		killed, err := s.serveConn(conn)
+		conn.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if killed {
+			return nil
+		}
+	}
+}
+
+// ServeConn serves one client until it detaches, kills the program or closes the connection
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	_, err := s.serveConn(conn)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// connection state
+type session struct {
+	// packets are not acknowledged after QStartNoAckMode
+	noAck int32
+}
+
+func (c *session) ack() bool {
+	return atomic.LoadInt32(&c.noAck) == 0
+}
+
+func (s *Server) serveConn(conn io.ReadWriter) (bool, error) {
+	c := &session{}
+
+	// packets are read in background, so an interrupt can stop running program
+	inputs := make(chan input)
+	errs := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(inputs)
+		r := bufio.NewReader(conn)
+		for {
+			in, err := readInput(r, conn, c.ack)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if in.interrupt {
+				s.debugger.Pause()
+				continue
+			}
+			select {
+			case inputs <- in:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	for in := range inputs {
+		reply, done, killed := s.handle(c, in.packet)
+		if killed {
+			return true, nil
+		}
+		if err := writePacket(conn, reply); err != nil {
+			return false, err
+		}
+		if done {
+			return false, nil
+		}
+	}
+	return false, <-errs
+}
+
+// handle packet and return reply. done is set when the client detaches, killed when it kills the program
+func (s *Server) handle(c *session, packet string) (reply string, done bool, killed bool) {
+	if packet == "" {
+		return "", false, false
+	}
+	command, args := packet[0], packet[1:]
+
+	switch command {
+	case '?':
+		return s.status, false, false
+	case 'g':
+		return s.readRegisters(), false, false
+	case 'G':
+		return result(s.writeRegisters(args)), false, false
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || int(n) >= len(registers) {
+			return "E01", false, false
+		}
+		return encodeWord(s.register(int(n))), false, false
+	case 'P':
+		return result(s.writeRegister(args)), false, false
+	case 'm':
+		data, err := s.readMemory(args)
+		if err != nil {
+			return "E01", false, false
+		}
+		return data, false, false
+	case 'M':
+		return result(s.writeMemory(args, false)), false, false
+	case 'X':
+		return result(s.writeMemory(args, true)), false, false
+	case 's', 'c':
+		if args != "" {
+			address, err := strconv.ParseUint(args, 16, 32)
+			if err != nil {
+				return "E01", false, false
+			}
+			s.debugger.VM.SetRegister(lc3.RegPC, lc3.Word(address/2))
+		}
+		return s.resume(command == 's'), false, false
+	case 'v':
+		return s.handleV(args), false, false
+	case 'Z', 'z':
+		return result(s.point(command == 'Z', args)), false, false
+	case 'H', 'T':
+		// the only thread
+		return "OK", false, false
+	case 'q', 'Q':
+		return s.query(c, packet), false, false
+	case 'D':
+		return "OK", true, false
+	case 'k':
+		s.debugger.VM.Stop()
+		return "", true, true
+	}
+	// unsupported packets get empty reply
+	return "", false, false
+}
+
+func result(err error) string {
+	if err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+func (s *Server) handleV(args string) string {
+	switch {
+	case args == "Cont?":
+		return "vCont;c;C;s;S"
+	case strings.HasPrefix(args, "Cont;"):
+		action := args[len("Cont;"):]
+		if action == "" {
+			return "E01"
+		}
+		// the first action applies to the only thread
+		return s.resume(action[0] == 's' || action[0] == 'S')
+	}
+	return ""
+}
+
+func (s *Server) query(c *session, packet string) string {
+	switch {
+	case strings.HasPrefix(packet, "qSupported"):
+		return "PacketSize=1000;qXfer:features:read+;swbreak+;hwbreak+;QStartNoAckMode+"
+	case packet == "QStartNoAckMode":
+		// this reply is still acknowledged by the client
+		defer atomic.StoreInt32(&c.noAck, 1)
+		return "OK"
+	case strings.HasPrefix(packet, "qXfer:features:read:target.xml:"):
+		return xfer(targetXML, packet[len("qXfer:features:read:target.xml:"):])
+	case packet == "qAttached":
+		return "1"
+	case packet == "qC":
+		return "QC1"
+	case packet == "qfThreadInfo":
+		return "m1"
+	case packet == "qsThreadInfo":
+		return "l"
+	case packet == "qSymbol::":
+		return "OK"
+	}
+	return ""
+}
+
+// part of document requested as offset,length
+func xfer(document string, args string) string {
+	var offset, length int
+	if _, err := fmt.Sscanf(args, "%x,%x", &offset, &length); err != nil {
+		return "E01"
+	}
+	if offset >= len(document) {
+		return "l"
+	}
+	if offset+length >= len(document) {
+		return "l" + document[offset:]
+	}
+	return "m" + document[offset:offset+length]
+}
+
+// register value as seen by the client: PC is a byte address, PSR is in user mode
+func (s *Server) register(n int) lc3.Word {
+	value := s.debugger.VM.GetRegister(registers[n])
+	switch registers[n] {
+	case lc3.RegPC:
+		return value * 2
+	case lc3.RegCond:
+		return 1<<15 | value
+	}
+	return value
+}
+
+func (s *Server) setRegister(n int, value lc3.Word) {
+	switch registers[n] {
+	case lc3.RegPC:
+		value /= 2
+	case lc3.RegCond:
+		value &= lc3.FlN | lc3.FlZ | lc3.FlP
+	}
+	s.debugger.VM.SetRegister(registers[n], value)
+}
+
+// little endian hex of word
+func encodeWord(w lc3.Word) string {
+	return fmt.Sprintf("%02x%02x", byte(w), byte(w>>8))
+}
+
+func decodeWord(text string) (lc3.Word, error) {
+	data, err := hex.DecodeString(text)
+	if err != nil || len(data) != 2 {
+		return 0, errors.Errorf("invalid register value %q", text)
+	}
+	return lc3.Word(data[0]) | lc3.Word(data[1])<<8, nil
+}
+
+func (s *Server) readRegisters() string {
+	var ret strings.Builder
+	for n := range registers {
+		ret.WriteString(encodeWord(s.register(n)))
+	}
+	return ret.String()
+}
+
+func (s *Server) writeRegisters(args string) error {
+	if len(args) != 4*len(registers) {
+		return errors.New("invalid registers length")
+	}
+	values := make([]lc3.Word, len(registers))
+	for n := range registers {
+		value, err := decodeWord(args[4*n : 4*n+4])
+		if err != nil {
+			return err
+		}
+		values[n] = value
+	}
+	for n, value := range values {
+		s.setRegister(n, value)
+	}
+	return nil
+}
+
+func (s *Server) writeRegister(args string) error {
+	parts := strings.SplitN(args, "=", 2)
+	if len(parts) != 2 {
+		return errors.New("invalid P packet")
+	}
+	n, err := strconv.ParseUint(parts[0], 16, 8)
+	if err != nil || int(n) >= len(registers) {
+		return errors.New("invalid register")
+	}
+	value, err := decodeWord(parts[1])
+	if err != nil {
+		return err
+	}
+	s.setRegister(int(n), value)
+	return nil
+}
+
+// size of memory in bytes
+const memoryBytes = 2 * (lc3.WordMax + 1)
+
+// parse addr,length of memory packets
+func memoryRange(args string) (int, int, error) {
+	var address, length int
+	if _, err := fmt.Sscanf(args, "%x,%x", &address, &length); err != nil {
+		return 0, 0, err
+	}
+	if address < 0 || length < 0 || address+length > memoryBytes {
+		return 0, 0, errors.New("address out of memory")
+	}
+	return address, length, nil
+}
+
+func (s *Server) readByte(address int) byte {
+	word := s.debugger.VM.PeekMem(lc3.Word(address / 2))
+	if address%2 == 1 {
+		return byte(word >> 8)
+	}
+	return byte(word)
+}
+
+func (s *Server) writeByte(address int, value byte) {
+	vm := s.debugger.VM
+	word := vm.PeekMem(lc3.Word(address / 2))
+	if address%2 == 1 {
+		word = word&0x00ff | lc3.Word(value)<<8
+	} else {
+		word = word&0xff00 | lc3.Word(value)
+	}
+	vm.WriteMem(lc3.Word(address/2), word)
+}
+
+func (s *Server) readMemory(args string) (string, error) {
+	address, length, err := memoryRange(args)
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = s.readByte(address + i)
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// write memory from hex data of M packet or binary data of X packet
+func (s *Server) writeMemory(args string, binary bool) error {
+	parts := strings.SplitN(args, ":", 2)
+	if len(parts) != 2 {
+		return errors.New("invalid memory packet")
+	}
+	address, length, err := memoryRange(parts[0])
+	if err != nil {
+		return err
+	}
+	data := []byte(parts[1])
+	if !binary {
+		if data, err = hex.DecodeString(parts[1]); err != nil {
+			return err
+		}
+	}
+	if len(data) != length {
+		return errors.New("memory data length mismatch")
+	}
+	for i, b := range data {
+		s.writeByte(address+i, b)
+	}
+	return nil
+}
+
+// insert or remove breakpoint or watchpoint: type,addr,kind
+func (s *Server) point(insert bool, args string) error {
+	var kind, address, length int
+	if _, err := fmt.Sscanf(args, "%d,%x,%x", &kind, &address, &length); err != nil {
+		return err
+	}
+	if address < 0 || address >= memoryBytes {
+		return errors.New("address out of memory")
+	}
+	word := lc3.Word(address / 2)
+
+	switch kind {
+	case 0, 1:
+		if insert {
+			s.debugger.SetBreakpoint(word)
+			s.hardware[word] = kind == 1
+		} else {
+			s.debugger.ClearBreakpoint(word)
+			delete(s.hardware, word)
+		}
+		return nil
+	case 2, 3, 4:
+		watch := map[int]debugger.WatchKind{2: debugger.WatchWrite, 3: debugger.WatchRead, 4: debugger.WatchAccess}[kind]
+		if length < 1 {
+			length = 1
+		}
+		for a := address / 2; a <= (address+length-1)/2 && a <= lc3.WordMax; a++ {
+			if insert {
+				s.debugger.SetWatchpoint(lc3.Word(a), watch)
+				s.watchpoints[lc3.Word(a)] |= watch
+			} else {
+				s.debugger.ClearWatchpoint(lc3.Word(a), watch)
+				if s.watchpoints[lc3.Word(a)] &^= watch; s.watchpoints[lc3.Word(a)] == 0 {
+					delete(s.watchpoints, lc3.Word(a))
+				}
+			}
+		}
+		return nil
+	}
+	return errors.Errorf("unsupported breakpoint type %d", kind)
+}
+
+// step or continue the program and return stop reply
+func (s *Server) resume(step bool) string {
+	if !s.debugger.VM.IsRunning() {
+		return "W00"
+	}
+
+	run := s.debugger.Continue
+	if step {
+		run = s.debugger.StepInstruction
+	}
+	reason, _ := run()
+
+	switch reason {
+	case debugger.StopHalted:
+		s.status = "W00"
+	case debugger.StopPause:
+		s.status = fmt.Sprintf("S%02x", sigInt)
+	case debugger.StopException:
+		s.status = fmt.Sprintf("S%02x", sigIll)
+	case debugger.StopBreakpoint:
+		breakpoint := "swbreak"
+		if s.hardware[s.debugger.PC()] {
+			breakpoint = "hwbreak"
+		}
+		s.status = fmt.Sprintf("T%02x%s:;", sigTrap, breakpoint)
+	case debugger.StopWatchpoint:
+		w, _ := s.debugger.Watchpoint()
+		watch := "rwatch"
+		switch {
+		case s.watchpoints[w.Address] == debugger.WatchAccess:
+			watch = "awatch"
+		case w.Kind == debugger.WatchWrite:
+			watch = "watch"
+		}
+		s.status = fmt.Sprintf("T%02x%s:%x;", sigTrap, watch, int(w.Address)*2)
+	default:
+		s.status = fmt.Sprintf("S%02x", sigTrap)
+	}
+	return s.status
+}
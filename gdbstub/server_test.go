@@ -0,0 +1,193 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/debugger"
+)
+
+const testProgram = `	.orig x3000
+main	ld r1, count
+loop	add r1, r1, #-1
+	st r1, count
+	brp loop
+	jsr sub
+	halt
+sub	ret
+count	.fill #2
+	.end
+`
+
+// in-process RSP client
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	// acknowledgments are expected
+	ack bool
+}
+
+func newClient(t *testing.T) (*client, *Server, chan error) {
+	program, err := (&lc3.Assembler{}).Assemble("main.asm", strings.NewReader(testProgram))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(debugger.New(program))
+
+	clientConn, serverConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServeConn(serverConn)
+		serverConn.Close()
+	}()
+	return &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn), ack: true}, server, done
+}
+
+func (c *client) send(data string) {
+	c.t.Helper()
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", data, sum); err != nil {
+		c.t.Fatal(err)
+	}
+	if c.ack {
+		if b, err := c.r.ReadByte(); err != nil || b != '+' {
+			c.t.Fatalf("%s: expected ack, got %q %v", data, b, err)
+		}
+	}
+}
+
+func (c *client) receive() string {
+	c.t.Helper()
+	if b, err := c.r.ReadByte(); err != nil || b != '$' {
+		c.t.Fatalf("expected packet, got %q %v", b, err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var checksum [2]byte
+	if _, err := c.r.Read(checksum[:1]); err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.r.Read(checksum[1:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if c.ack {
+		if _, err := c.conn.Write([]byte{'+'}); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return string(unescape([]byte(strings.TrimSuffix(data, "#"))))
+}
+
+// send packet and check the reply
+func (c *client) expect(packet, reply string) {
+	c.t.Helper()
+	c.send(packet)
+	if got := c.receive(); got != reply {
+		c.t.Errorf("%s: expected %q, got %q", packet, reply, got)
+	}
+}
+
+func Test_Server(t *testing.T) {
+	c, _, done := newClient(t)
+
+	c.send("qSupported:multiprocess+;swbreak+;hwbreak+")
+	if reply := c.receive(); !strings.Contains(reply, "qXfer:features:read+") {
+		t.Errorf("unexpected qSupported reply %q", reply)
+	}
+	c.expect("QStartNoAckMode", "OK")
+	c.ack = false
+
+	c.send("qXfer:features:read:target.xml:0,20")
+	if reply := c.receive(); reply != "m"+targetXML[:0x20] {
+		t.Errorf("unexpected first part of target.xml %q", reply)
+	}
+	c.send("qXfer:features:read:target.xml:20,1000")
+	if reply := c.receive(); reply != "l"+targetXML[0x20:] {
+		t.Errorf("unexpected last part of target.xml %q", reply)
+	}
+
+	c.expect("?", "S05")
+	// R0-R7 are zero, PC x3000 is byte address x6000, PSR is in user mode
+	c.expect("g", strings.Repeat("0000", 8)+"0060"+"0080")
+	c.expect("p8", "0060")
+	// LD R1, x3007 is x2206
+	c.expect("m6000,2", "0622")
+	c.expect("m600f,1", "00")
+
+	c.expect("s", "S05")
+	c.expect("p1", "0200")
+	c.expect("P1=0300", "OK")
+	c.expect("p1", "0300")
+
+	// write of COUNT at x3007
+	c.expect("Z2,600e,2", "OK")
+	c.expect("c", "T05watch:600e;")
+	c.expect("m600e,2", "0200")
+	c.expect("z2,600e,2", "OK")
+
+	// software breakpoint on SUB
+	c.expect("Z0,600c,0", "OK")
+	c.expect("vCont;c", "T05swbreak:;")
+	c.expect("p8", "0c60")
+	c.expect("p7", "0530")
+	c.expect("z0,600c,0", "OK")
+
+	// hardware breakpoint on HALT
+	c.expect("Z1,600a,0", "OK")
+	c.expect("c", "T05hwbreak:;")
+	c.expect("z1,600a,0", "OK")
+
+	c.expect("M6000,2:3412", "OK")
+	c.expect("m6000,2", "3412")
+	c.expect("X6002,2:"+"\x7d\x03\x00", "OK")
+	c.expect("m6002,2", "2300")
+	// PC at JSR SUB
+	c.expect("G"+strings.Repeat("0100", 8)+"0860"+"0200", "OK")
+	c.expect("g", strings.Repeat("0100", 8)+"0860"+"0280")
+
+	c.expect("vCont;s", "S05")
+	c.expect("c", "W00")
+	c.expect("?", "W00")
+
+	c.expect("D", "OK")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Interrupt(t *testing.T) {
+	c, server, done := newClient(t)
+	// AND R0, R0, #0 followed by endless BRNZP
+	server.debugger.VM.WriteMem(0x3000, 0x5020)
+	server.debugger.VM.WriteMem(0x3001, 0x0FFF)
+
+	c.send("c")
+	if _, err := c.conn.Write([]byte{interrupt}); err != nil {
+		t.Fatal(err)
+	}
+	if reply := c.receive(); reply != "S02" {
+		t.Errorf("expected interrupt, got %q", reply)
+	}
+	// interrupt can come before the first instruction
+	c.send("p8")
+	if pc := c.receive(); pc != "0060" && pc != "0260" {
+		t.Errorf("expected PC in the loop, got %q", pc)
+	}
+
+	c.send("k")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
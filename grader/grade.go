@@ -0,0 +1,160 @@
+package grader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// Grade assembles and grades the submission file
+func (r *Rubric) Grade(submission string) *Report {
+	ret := &Report{Submission: submission, MaxScore: r.MaxScore(), Tests: []TestResult{}}
+
+	// .INCLUDE can't leave the directory of the submission
+	assembler := &lc3.Assembler{FS: os.DirFS(filepath.Dir(submission)), MaxExpandedLines: r.Limits.ExpandedLines}
+	program, err := assembler.AssembleFile(filepath.Base(submission))
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+
+	for _, test := range r.Tests {
+		result := TestResult{Name: test.Name, Weight: test.Weight, Passed: true}
+		for _, c := range test.cases {
+			c = c.With(c.Name).Program(program).Limit(r.Limits.Instructions, r.Limits.OutputBytes)
+			report := c.Report(test.Name)
+			if report.Err != nil {
+				result.Failures = append(result.Failures, fmt.Sprintf("%s: %v", c.Name, report.Err))
+			}
+			for _, failure := range report.Failures {
+				result.Failures = append(result.Failures, fmt.Sprintf("%s: %v", c.Name, failure))
+			}
+		}
+		if len(result.Failures) > 0 {
+			result.Passed = false
+		} else {
+			ret.Score += test.Weight
+		}
+		ret.Tests = append(ret.Tests, result)
+	}
+
+	ret.Violations = r.check(program)
+	ret.Score -= r.Penalty * float64(len(ret.Violations))
+	if ret.Score < 0 {
+		ret.Score = 0
+	}
+	return ret
+}
+
+// GradeAll grades submissions by workers goroutines. reports are in order of submissions
+func (r *Rubric) GradeAll(submissions []string, workers int) []*Report {
+	ret := make([]*Report, len(submissions))
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				ret[i] = r.Grade(submissions[i])
+			}
+		}()
+	}
+	for i := range submissions {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return ret
+}
+
+// opcodes by mnemonic. banned opcode bans all its forms: BR bans BRZ, JMP bans RET, JSR bans JSRR, TRAP bans HALT
+var opcodes = map[string]lc3.Word{
+	"BR": lc3.OpBr, "ADD": lc3.OpAdd, "LD": lc3.OpLd, "ST": lc3.OpSt, "JSR": lc3.OpJsr, "AND": lc3.OpAnd,
+	"LDR": lc3.OpLdr, "STR": lc3.OpStr, "RTI": lc3.OpRti, "NOT": lc3.OpNot, "LDI": lc3.OpLdi, "STI": lc3.OpSti,
+	"JMP": lc3.OpJmp, "LEA": lc3.OpLea, "TRAP": lc3.OpTrap,
+}
+
+// mnemonics of instruction forms as they are disassembled. banned form bans only itself
+var forms = map[string]bool{
+	"BRN": true, "BRZ": true, "BRP": true, "BRNZ": true, "BRNP": true, "BRZP": true, "BRNZP": true, "NOP": true,
+	"JSRR": true, "RET": true, "RES": true,
+	"GETC": true, "OUT": true, "PUTS": true, "IN": true, "PUTSP": true, "HALT": true,
+}
+
+// checks if mnemonic of a banned instruction is known
+func isInstruction(mnemonic string) bool {
+	mnemonic = strings.ToUpper(mnemonic)
+	_, ok := opcodes[mnemonic]
+	return ok || forms[mnemonic]
+}
+
+// violated requirements of the program
+func (r *Rubric) check(program *lc3.Program) []string {
+	var ret []string
+
+	for _, label := range r.RequiredLabels {
+		if _, ok := program.Symbol(strings.ToUpper(label)); !ok {
+			ret = append(ret, fmt.Sprintf("required label %s is not defined", label))
+		}
+	}
+
+	called := make(map[lc3.Word]bool)
+	banned := make(map[string]bool)
+	bannedOpcodes := make(map[lc3.Word]bool)
+	for _, mnemonic := range r.BannedInstructions {
+		mnemonic = strings.ToUpper(mnemonic)
+		banned[mnemonic] = true
+		if opcode, ok := opcodes[mnemonic]; ok {
+			bannedOpcodes[opcode] = true
+		}
+	}
+	for _, line := range program.Lines {
+		if !line.Code {
+			continue
+		}
+		reported := false
+		for i := lc3.Word(0); i < line.Size; i++ {
+			address := line.Address + i
+			word := program.WordAt(address)
+			instruction := lc3.DecodeInstruction(word)
+			if instruction.Opcode == lc3.OpJsr {
+				if target, ok := instruction.Target(address); ok {
+					called[target] = true
+				}
+			}
+			mnemonic := strings.ToUpper(strings.Fields(lc3.EncodeInstruction(word) + " ")[0])
+			if (banned[mnemonic] || bannedOpcodes[instruction.Opcode]) && !reported {
+				ret = append(ret, fmt.Sprintf("banned instruction %s at %s:%d", mnemonic, line.File, line.Line))
+				reported = true
+			}
+		}
+	}
+
+	for _, name := range r.RequiredSubroutines {
+		address, ok := program.Symbol(strings.ToUpper(name))
+		switch {
+		case !ok:
+			ret = append(ret, fmt.Sprintf("required subroutine %s is not defined", name))
+		case !called[address]:
+			ret = append(ret, fmt.Sprintf("required subroutine %s is never called by JSR", name))
+		}
+	}
+
+	words := 0
+	for _, section := range program.Sections {
+		words += len(section.Words)
+	}
+	if r.MaxWords > 0 && words > r.MaxWords {
+		ret = append(ret, fmt.Sprintf("program has %d words, the limit is %d", words, r.MaxWords))
+	}
+	return ret
+}
@@ -0,0 +1,139 @@
+package grader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Grade(t *testing.T) {
+	rubric, err := ReadRubricFile("testdata/rubric.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rubric.MaxScore() != 6 {
+		t.Fatalf("expected max score 6, got %v", rubric.MaxScore())
+	}
+
+	reports := rubric.GradeAll([]string{"testdata/good.asm", "testdata/bad.asm", "testdata/broken.asm", "testdata/flood.asm"}, 3)
+
+	good := reports[0]
+	if good.Score != 6 || len(good.Violations) != 0 {
+		t.Errorf("unexpected report of good submission %+v", good)
+	}
+
+	bad := reports[1]
+	if bad.Score != 2 {
+		t.Errorf("expected score 2, got %v", bad.Score)
+	}
+	violations := []string{
+		"banned instruction LDI at bad.asm:12",
+		"required subroutine upper is never called by JSR",
+	}
+	if !reflect.DeepEqual(bad.Violations, violations) {
+		t.Errorf("expected violations %q, got %q", violations, bad.Violations)
+	}
+	failures := []string{"digit: expected R0 = x0031, got x0011"}
+	if bad.Tests[1].Passed || !reflect.DeepEqual(bad.Tests[1].Failures, failures) {
+		t.Errorf("expected failures %q, got %+v", failures, bad.Tests[1])
+	}
+
+	if broken := reports[2]; broken.Error == "" || broken.Score != 0 {
+		t.Errorf("expected include out of the submission directory to fail, got %+v", broken)
+	}
+
+	flood := reports[3]
+	if flood.Score != 0 || !strings.Contains(flood.Tests[0].Failures[0], "output limit exceeded") {
+		t.Errorf("expected output limit to fail the tests, got %+v", flood)
+	}
+
+	// the same reports in any order of grading
+	again := rubric.GradeAll([]string{"testdata/good.asm", "testdata/bad.asm", "testdata/broken.asm", "testdata/flood.asm"}, 1)
+	if !reflect.DeepEqual(reports, again) {
+		t.Errorf("reports differ between runs")
+	}
+}
+
+func Test_Markdown(t *testing.T) {
+	rubric, err := ReadRubricFile("testdata/rubric.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := rubric.Grade("testdata/bad.asm").WriteMarkdown(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# testdata/bad.asm\n\nScore: **2 / 6**\n\n" +
+		"| Test | Points | Result |\n|---|---|---|\n" +
+		"| echo | 2 / 2 | passed |\n| upper | 0 / 3 | failed |\n| empty line | 1 / 1 | passed |\n\n" +
+		"## upper\n\n- `digit: expected R0 = x0031, got x0011`\n\n" +
+		"## Violations\n\n- banned instruction LDI at bad.asm:12\n- required subroutine upper is never called by JSR\n"
+	if b.String() != expected {
+		t.Errorf("unexpected markdown:\n%s", b.String())
+	}
+}
+
+func Test_ReadRubric(t *testing.T) {
+	for rubric, expected := range map[string]string{
+		`{"tests": [{"spec": []}]}`:                                "test 1 has no name or its name is not unique",
+		`{"tests": [{"name": "a", "spec": ["expect flags = q"]}]}`: "test a: bad flags q at a.spec:1",
+		`{"tests": [{"name": "a", "spec": ["steps x"]}]}`:          "test a: strconv.ParseUint: parsing \"x\": invalid syntax at a.spec:1",
+		`{"test": []}`:                     "read rubric: json: unknown field \"test\"",
+		`{"banned_instructions": ["BRX"]}`: "unknown banned instruction BRX",
+	} {
+		if _, err := ReadRubric(strings.NewReader(rubric)); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected error %q, got %v", rubric, expected, err)
+		}
+	}
+}
+
+func Test_BannedInstructions(t *testing.T) {
+	submission := filepath.Join(t.TempDir(), "main.asm")
+	code := ".orig x3000\ngetc\nbrz skip\nskip jsr sub\nhalt\nsub ret\n.end\n"
+	if err := ioutil.WriteFile(submission, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for banned, expected := range map[string][]string{
+		`"BR"`:    {"banned instruction BRZ at main.asm:3"},
+		`"trap"`:  {"banned instruction GETC at main.asm:2", "banned instruction HALT at main.asm:5"},
+		`"HALT"`:  {"banned instruction HALT at main.asm:5"},
+		`"JMP"`:   {"banned instruction RET at main.asm:6"},
+		`"BRNZP"`: nil,
+	} {
+		rubric, err := ReadRubric(strings.NewReader(`{"banned_instructions": [` + banned + `]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if violations := rubric.Grade(submission).Violations; !reflect.DeepEqual(violations, expected) {
+			t.Errorf("%s: expected violations %q, got %q", banned, expected, violations)
+		}
+	}
+}
+
+// nested macros expand exponentially, assembly stops at the limit
+func Test_ExpandedLines(t *testing.T) {
+	var code strings.Builder
+	code.WriteString(".macro m0\nadd r0, r0, #1\n.endm\n")
+	for i := 1; i < 16; i++ {
+		fmt.Fprintf(&code, ".macro m%d\nm%d\nm%d\nm%d\nm%d\n.endm\n", i, i-1, i-1, i-1, i-1)
+	}
+	code.WriteString(".orig x3000\nm15\nhalt\n.end\n")
+	submission := filepath.Join(t.TempDir(), "main.asm")
+	if err := ioutil.WriteFile(submission, []byte(code.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rubric, err := ReadRubric(strings.NewReader(`{"tests": [{"name": "a", "spec": ["expect output"]}], "limits": {"expanded_lines": 1000}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := rubric.GradeAll([]string{submission}, 1)[0]
+	if !strings.Contains(report.Error, "macro expansion exceeds 1000 lines") || report.Score != 0 {
+		t.Errorf("expected failed submission, got score %v and error %q", report.Score, report.Error)
+	}
+}
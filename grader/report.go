@@ -0,0 +1,90 @@
+package grader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Report is the score of a submission
+type Report struct {
+	Submission string  `json:"submission"`
+	Score      float64 `json:"score"`
+	MaxScore   float64 `json:"max_score"`
+	// the submission can't be assembled, its score is zero
+	Error      string       `json:"error,omitempty"`
+	Tests      []TestResult `json:"tests"`
+	Violations []string     `json:"violations,omitempty"`
+}
+
+type TestResult struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+	Passed bool    `json:"passed"`
+	// failed expectations and errors prefixed by case names
+	Failures []string `json:"failures,omitempty"`
+}
+
+// WriteJSON writes indented JSON of the report
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteMarkdown writes the report for a student
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", r.Submission)
+	fmt.Fprintf(&b, "Score: **%s / %s**\n", points(r.Score), points(r.MaxScore))
+
+	if r.Error != "" {
+		fmt.Fprintf(&b, "\nThe program can't be assembled:\n\n```\n%s\n```\n", r.Error)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	if len(r.Tests) > 0 {
+		b.WriteString("\n| Test | Points | Result |\n|---|---|---|\n")
+	}
+	for _, test := range r.Tests {
+		result := "passed"
+		earned := test.Weight
+		if !test.Passed {
+			result = "failed"
+			earned = 0
+		}
+		fmt.Fprintf(&b, "| %s | %s / %s | %s |\n", escape(test.Name), points(earned), points(test.Weight), result)
+	}
+
+	for _, test := range r.Tests {
+		if test.Passed {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", test.Name)
+		for _, failure := range test.Failures {
+			fmt.Fprintf(&b, "- `%s`\n", strings.ReplaceAll(failure, "`", "'"))
+		}
+	}
+
+	if len(r.Violations) > 0 {
+		b.WriteString("\n## Violations\n\n")
+		for _, violation := range r.Violations {
+			fmt.Fprintf(&b, "- %s\n", violation)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func points(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// escape table cell
+func escape(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
@@ -0,0 +1,134 @@
+// Package grader scores student submissions against a rubric: weighted test cases,
+// required labels and subroutines, banned instructions and a size limit.
+//
+// Every submission is assembled in a sandbox where .INCLUDE can only read files of its directory,
+// and every case runs in its own VM with capped instructions and output. Submissions are graded
+// in parallel, reports don't depend on timing, so grading the same submissions gives the same reports.
+package grader
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pavel-krush/lc3/lc3test"
+	"github.com/pkg/errors"
+)
+
+// Rubric is read from JSON:
+//
+//	{
+//		"tests": [
+//			{"name": "echo", "weight": 2, "spec": ["input a", "expect output a"]}
+//		],
+//		"required_labels": ["MAIN"],
+//		"required_subroutines": ["UPPER"],
+//		"banned_instructions": ["LDI", "STI"],
+//		"max_words": 100,
+//		"penalty": 1,
+//		"limits": {"instructions": 100000, "output_bytes": 1000, "expanded_lines": 10000}
+//	}
+//
+// Spec lines of a test are in the format of lc3test.Spec. The test passes if all its cases pass.
+type Rubric struct {
+	Tests []Test `json:"tests"`
+	// labels the submission must define
+	RequiredLabels []string `json:"required_labels,omitempty"`
+	// labels the submission must define and call by JSR
+	RequiredSubroutines []string `json:"required_subroutines,omitempty"`
+	// mnemonics of instructions the submission must not use, e.g. LDI or RET. a mnemonic of an opcode
+	// bans all its forms: BR bans BRZ, TRAP bans HALT, JMP bans RET
+	BannedInstructions []string `json:"banned_instructions,omitempty"`
+	// number of words of the assembled program. zero means no limit
+	MaxWords int `json:"max_words,omitempty"`
+	// points subtracted for every violated requirement
+	Penalty float64 `json:"penalty,omitempty"`
+	Limits  Limits  `json:"limits"`
+}
+
+type Test struct {
+	Name string `json:"name"`
+	// points for passing the test. 1 if omitted
+	Weight float64  `json:"weight,omitempty"`
+	Spec   []string `json:"spec"`
+
+	cases lc3test.Cases
+}
+
+// Limits cap resources of assembly and of every case. reading after the end of input always fails the case
+type Limits struct {
+	// executed instructions. DefaultInstructions if omitted
+	Instructions uint `json:"instructions,omitempty"`
+	// printed characters. DefaultOutputBytes if omitted
+	OutputBytes int `json:"output_bytes,omitempty"`
+	// lines produced by macro expansion when the submission is assembled. DefaultExpandedLines if omitted
+	ExpandedLines int `json:"expanded_lines,omitempty"`
+}
+
+const (
+	DefaultInstructions  = 1000000
+	DefaultOutputBytes   = 64 * 1024
+	DefaultExpandedLines = 100000
+)
+
+// ReadRubricFile reads rubric from JSON file
+func ReadRubricFile(name string) (*Rubric, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadRubric(file)
+}
+
+// ReadRubric reads rubric from JSON and parses specs of its tests
+func ReadRubric(reader io.Reader) (*Rubric, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	ret := &Rubric{}
+	if err := decoder.Decode(ret); err != nil {
+		return nil, errors.Wrap(err, "read rubric")
+	}
+
+	if ret.Limits.Instructions == 0 {
+		ret.Limits.Instructions = DefaultInstructions
+	}
+	if ret.Limits.OutputBytes == 0 {
+		ret.Limits.OutputBytes = DefaultOutputBytes
+	}
+	if ret.Limits.ExpandedLines == 0 {
+		ret.Limits.ExpandedLines = DefaultExpandedLines
+	}
+	for _, mnemonic := range ret.BannedInstructions {
+		if !isInstruction(mnemonic) {
+			return nil, errors.Errorf("unknown banned instruction %s", mnemonic)
+		}
+	}
+	names := make(map[string]bool)
+	for i := range ret.Tests {
+		test := &ret.Tests[i]
+		if test.Name == "" || names[test.Name] {
+			return nil, errors.Errorf("test %d has no name or its name is not unique", i+1)
+		}
+		names[test.Name] = true
+		if test.Weight == 0 {
+			test.Weight = 1
+		}
+		spec, err := lc3test.ParseSpec(test.Name+lc3test.SpecExt, strings.NewReader(strings.Join(test.Spec, "\n")))
+		if err != nil {
+			return nil, errors.Wrapf(err, "test %s", test.Name)
+		}
+		test.cases = spec.Cases
+	}
+	return ret, nil
+}
+
+// MaxScore is the sum of weights of all tests
+func (r *Rubric) MaxScore() float64 {
+	ret := 0.0
+	for _, test := range r.Tests {
+		ret += test.Weight
+	}
+	return ret
+}
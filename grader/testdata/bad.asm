@@ -0,0 +1,17 @@
+; converts digits too and never calls upper by JSR
+	.orig x3000
+main	getc
+	add r1, r0, #-10
+	brz done
+	lea r7, back
+	brnzp upper
+back	out
+	brnzp main
+done	halt
+
+upper	ldi r1, minusa
+	add r0, r0, r1
+	ret
+minusa	.fill minus
+minus	.fill #-32
+	.end
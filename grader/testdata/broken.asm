@@ -0,0 +1,3 @@
+	.orig x3000
+	.include "../rubric.json"
+	.end
@@ -0,0 +1,7 @@
+	.orig x3000
+main	lea r0, text
+	puts
+	brnzp main
+upper	ret
+text	.stringz "flood"
+	.end
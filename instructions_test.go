@@ -0,0 +1,185 @@
+package lc3_test
+
+import (
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/lc3test"
+)
+
+func Test_Add(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("add").Source(`
+					add r0, r0, #0
+					add r1, r1, #1
+					add r2, r2, #-1
+					add r3, r1, r2
+					halt`).
+			ExpectRegister(lc3.RegR0, 0).
+			ExpectRegister(lc3.RegR1, 1).
+			ExpectRegister(lc3.RegR2, lc3.MakeNegative(-1)).
+			ExpectRegister(lc3.RegR3, 0),
+	}.Test(t)
+}
+
+func Test_BR(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("brn").Source(`
+					add r0, r0, #-1
+					brz zcond ;not jump
+					brp pcond ;not jump
+					brn ncond ;jump
+					halt ;unreachable
+			zcond	halt ;unreachable
+			pcond	halt ;unreachable
+			ncond	halt ;must stop here
+			`).ExpectRegister(lc3.RegPC, 8),
+		lc3test.New("brz").Source(`
+					add r0, r0, #0
+					brp pcond ;not jump
+					brn ncond ;not jump
+					brz zcond ;jump
+					halt ;unreachable
+			zcond	halt ;unreachable
+			pcond	halt ;unreachable
+			ncond	halt ;must stop here
+			`).ExpectRegister(lc3.RegPC, 6),
+		lc3test.New("brp").Source(`
+					add r0, r0, #1
+					brn ncond ;not jump
+					brz zcond ;not jump
+					brp pcond ;jump
+					halt ;unreachable
+			zcond	halt ;unreachable
+			pcond	halt ;unreachable
+			ncond	halt ;must stop here
+			`).ExpectRegister(lc3.RegPC, 7),
+	}.Test(t)
+}
+
+func Test_Ld(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("ld").Source(`
+					ld r0, #-1 ;load this instruction into r0
+					halt`).
+			ExpectRegister(lc3.RegR0, lc3.NewLd(lc3.RegR0, lc3.MakeNegative(-1))),
+	}.Test(t)
+}
+
+func Test_St(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("st").Source(`
+					add r0, r0, #13
+					st r0, #1 ;store 13 in the word after the halt instruction
+					halt
+			`).ExpectMemory(3, 13),
+	}.Test(t)
+}
+
+func Test_Jsr(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("jsr 1").Source(`
+					jsr #1 ; must jump and set r7 = 1
+					halt ;unreachable
+					halt ;must stop here`).
+			ExpectRegister(lc3.RegR7, 1).
+			ExpectRegister(lc3.RegPC, 3),
+		lc3test.New("jsr 2").Source(`
+					add r0, r0, #3
+					jsrr r0 ;save r0 to r7 and jump to second halt
+					halt
+					halt
+					`).
+			ExpectRegister(lc3.RegR7, 2).ExpectRegister(lc3.RegPC, 4),
+	}.Test(t)
+}
+
+func Test_And(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("and").Source(`
+					add r0, r0, #13
+					add r1, r1, #42
+					and r2, r0, r1
+					halt`).
+			ExpectRegister(lc3.RegR2, 8).
+			ExpectFlags(lc3.FlP),
+	}.Test(t)
+}
+
+func Test_Ldr(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("ldr").Source(`
+					add r6, r6, #3 
+					ldr r0, r6, #1
+					halt
+					.fill #1 ;r6 will point here
+					.fill #42 ;this value must be loaded`).
+			ExpectRegister(lc3.RegR0, 42),
+	}.Test(t)
+}
+
+func Test_Str(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("str").Source(`
+					add r6, r6, #4 
+					add r0, r0, #13
+					str r0, r6, #1
+					halt
+					.fill #1 ;r6 will point here
+					.fill #42 ;this value will be overwritten`).
+			ExpectMemory(5, 13),
+	}.Test(t)
+}
+
+func Test_Not(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("not").Source(`
+					add r0, r0, #13
+					not r1, r0
+					halt`).
+			ExpectRegister(lc3.RegR1, lc3.MakeNegative(-14)),
+	}.Test(t)
+}
+
+func Test_Ldi(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("ldi").Source(`
+					ldi r1, #1 ;address of first .fill
+					halt
+					.fill x3 ;pointer to next word
+					.fill x42 ;this value must be loaded`).
+			ExpectRegister(lc3.RegR1, 0x42),
+	}.Test(t)
+}
+
+func Test_Sti(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("sti").Source(`
+					add r1, r1, #13
+					sti r1, #1 ;address of first .fill
+					halt
+					.fill x4 ;pointer to next word
+					.fill x42 ;this value will be overwritten`).
+			ExpectMemory(4, 13),
+	}.Test(t)
+}
+
+func Test_Jmp(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("jmp").Source(`
+					add r0, r0, #3
+					jmp r0
+					halt
+					halt ;must stop here`).
+			ExpectRegister(lc3.RegPC, 4),
+	}.Test(t)
+}
+
+func Test_Lea(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("lea").Source(`
+					lea r1, stack
+					stack halt`).
+			ExpectRegister(lc3.RegR1, 1),
+	}.Test(t)
+}
@@ -0,0 +1,196 @@
+package lc3
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	anonymousLabel    = "@@" // anonymous label definition
+	anonymousBackward = "@B" // the nearest anonymous label before, or on the same line
+	anonymousForward  = "@F" // the nearest anonymous label after
+)
+
+func isLocalLabel(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// global labels open a new scope for local ones.
+// labels generated by macro expansion contain @ and do not change the scope
+func isGlobalLabel(name string) bool {
+	return name != "" && !isLocalLabel(name) && !strings.Contains(name, "@")
+}
+
+// index of anonymous label for every line: the last one defined before or on the line, and the number of them
+func anonymousIndexes(lines []Line) ([]int, int) {
+	anonymous := make([]int, len(lines))
+	count := 0
+	for i, line := range lines {
+		if line.Label == anonymousLabel {
+			count++
+		}
+		anonymous[i] = count
+	}
+	return anonymous, count
+}
+
+func anonymousName(index int) string {
+	return fmt.Sprintf("%s%d", anonymousLabel, index)
+}
+
+// name of label operand as the assembler sees it. anonymous is the index of the last anonymous label
+// before the line, count is the number of anonymous labels. false if the operand does not need qualification
+func qualifyOperand(label string, scope string, anonymous int, count int) (string, bool, error) {
+	switch {
+	case label == anonymousBackward:
+		if anonymous == 0 {
+			return "", false, errors.Errorf("no anonymous label before %s", label)
+		}
+		return anonymousName(anonymous), true, nil
+	case label == anonymousForward:
+		if anonymous == count {
+			return "", false, errors.Errorf("no anonymous label after %s", label)
+		}
+		return anonymousName(anonymous + 1), true, nil
+	case isLocalLabel(label):
+		return scope + label, true, nil
+	}
+	return label, false, nil
+}
+
+// rename local labels (.LOOP) to names qualified by the nearest preceding global label (MAIN.LOOP)
+// and anonymous labels (@@) to unique names (@@1), resolving @B and @F references to them
+func resolveLocalLabels(lines []Line) ([]Line, error) {
+	anonymous, count := anonymousIndexes(lines)
+
+	ret := make([]Line, len(lines))
+	scope := ""
+	for i, line := range lines {
+		if isGlobalLabel(line.Label) {
+			scope = line.Label
+		}
+
+		if line.Label == anonymousLabel {
+			line.Label = anonymousName(anonymous[i])
+		} else if isLocalLabel(line.Label) {
+			line.Label = scope + line.Label
+		}
+
+		operands := make([]Operand, len(line.Operands))
+		copy(operands, line.Operands)
+		for j, operand := range operands {
+			if !operand.isLabel() {
+				continue
+			}
+			name, qualified, err := qualifyOperand(*operand.label, scope, anonymous[i], count)
+			if err != nil {
+				return nil, line.pos.wrap(err)
+			}
+			if qualified {
+				operands[j] = Operand{label: &name}
+			}
+		}
+		line.Operands = operands
+
+		ret[i] = line
+	}
+
+	return ret, nil
+}
+
+// LabelReference is a label defined or referenced by a line of a syntax tree
+type LabelReference struct {
+	// name as the assembler sees it: local labels are qualified by their scope (MAIN.LOOP),
+	// anonymous labels are numbered (@@1) and labels of macro bodies are suffixed by the macro name (LOOP@INC)
+	Name string
+	// label as it was written
+	Text string
+	Pos  Position
+	// the label is defined by the line, otherwise it is an operand
+	Definition bool
+}
+
+// LabelReferences returns labels defined and referenced in the file in source order.
+// references that can't be resolved, like @B before the first anonymous label, are skipped
+func (f *File) LabelReferences() []LabelReference {
+	var ret []LabelReference
+
+	anonymous, count := anonymousIndexes(f.Lines)
+	scope := ""
+	// labels and parameters of the macro being defined
+	macro := ""
+	var macroLabels, macroParameters map[string]bool
+
+	for i := range f.Lines {
+		line := &f.Lines[i]
+
+		switch line.Opcode {
+		case stropMacro:
+			if len(line.Operands) == 0 || !line.Operands[0].isLabel() {
+				continue
+			}
+			macro = *line.Operands[0].label
+			macroLabels, macroParameters = make(map[string]bool), make(map[string]bool)
+			for _, operand := range line.Operands[1:] {
+				if operand.isLabel() {
+					macroParameters[*operand.label] = true
+				}
+			}
+			for _, body := range f.Lines[i+1:] {
+				if body.Opcode == stropEndm {
+					break
+				}
+				macroLabels[body.Label] = true
+			}
+			continue
+		case stropEndm:
+			macro = ""
+			continue
+		case stropIf, stropIfdef, stropIfndef, stropInclude:
+			// operands are not labels
+			continue
+		}
+
+		// labels of macro bodies are renamed on every expansion
+		inMacro := func(label string) (string, bool) {
+			if macro != "" && macroLabels[label] && label != anonymousLabel {
+				return label + "@" + macro, true
+			}
+			return label, false
+		}
+
+		if line.Label != "" {
+			name, renamed := inMacro(line.Label)
+			if !renamed {
+				if isGlobalLabel(line.Label) {
+					scope = line.Label
+				}
+				if line.Label == anonymousLabel {
+					name = anonymousName(anonymous[i])
+				} else if isLocalLabel(line.Label) {
+					name = scope + line.Label
+				}
+			}
+			ret = append(ret, LabelReference{Name: name, Text: line.LabelText(), Pos: line.LabelPos(), Definition: true})
+		}
+
+		for j := range line.Operands {
+			operand := &line.Operands[j]
+			if !operand.isLabel() || macroParameters[*operand.label] && macro != "" {
+				continue
+			}
+			name, renamed := inMacro(*operand.label)
+			if !renamed {
+				var err error
+				if name, _, err = qualifyOperand(*operand.label, scope, anonymous[i], count); err != nil {
+					continue
+				}
+			}
+			ret = append(ret, LabelReference{Name: name, Text: operand.text, Pos: operand.pos})
+		}
+	}
+
+	return ret
+}
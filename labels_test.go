@@ -0,0 +1,147 @@
+package lc3_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/lc3test"
+)
+
+const localLabelsTestCode = `
+			.orig x3000
+	main	add r1, r1, #3
+			jsr count
+			add r1, r1, #2
+			jsr count
+			brnzp other
+	count	and r0, r0, #0
+	.loop	add r0, r0, #1
+			add r1, r1, #-1
+			brp .loop
+	@@		add r2, r2, #1
+			brz @b
+			brp @f
+			halt ;unreachable
+	@@		add r3, r3, #1
+			brp .done
+	.done	ret
+	other	add r4, r4, #1
+	.loop	brnzp main.done ;jump to the local label of another scope
+			halt ;unreachable
+	main.done halt`
+
+func Test_LocalLabels(t *testing.T) {
+	lc3test.Cases{
+		lc3test.New("local labels").Source(localLabelsTestCode).
+			ExpectRegister(lc3.RegR0, 2).
+			ExpectRegister(lc3.RegR1, 0).
+			ExpectRegister(lc3.RegR2, 2).
+			ExpectRegister(lc3.RegR3, 2).
+			ExpectRegister(lc3.RegR4, 1),
+		// anonymous labels in macros
+		lc3test.New("anonymous labels in macros").Source(`
+			.macro clear reg
+			@@		add reg, reg, #-1
+					brp @b
+			.endm
+					add r0, r0, #3
+					add r1, r1, #5
+					clear r0
+					clear r1
+					halt`).
+			ExpectRegister(lc3.RegR0, 0).
+			ExpectRegister(lc3.RegR1, 0),
+	}.Test(t)
+}
+
+func Test_LocalLabelsSymbols(t *testing.T) {
+	program, err := (&lc3.Assembler{}).Assemble("main.asm", strings.NewReader(localLabelsTestCode))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]lc3.Word{
+		"MAIN":       0x3000,
+		"COUNT":      0x3005,
+		"COUNT.LOOP": 0x3006,
+		"COUNT.DONE": 0x300F,
+		"@@1":        0x3009,
+		"@@2":        0x300D,
+		"OTHER.LOOP": 0x3011,
+		"MAIN.DONE":  0x3013,
+	}
+	for name, address := range expected {
+		actual, ok := program.Symbol(name)
+		if !ok || actual != address {
+			t.Errorf("expected %s at x%04X, got x%04X", name, address, actual)
+		}
+	}
+
+	var symbols bytes.Buffer
+	if err := program.WriteSymbols(&symbols); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(symbols.String(), "//\tCOUNT.LOOP        3006\n") {
+		t.Errorf("qualified label is not found in symbol table:\n%s", symbols.String())
+	}
+
+	var listing bytes.Buffer
+	if err := program.WriteListing(&listing); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(listing.String(), "x3008  03FD 0000001111111101     11  BRP COUNT.LOOP\n") {
+		t.Errorf("qualified label is not found in listing:\n%s", listing.String())
+	}
+}
+
+func Test_LocalLabelsErrors(t *testing.T) {
+	type testCase struct {
+		code  string
+		error string
+	}
+
+	testData := []testCase{
+		{"brnzp @b\n@@ halt\n", "no anonymous label before @B at line 1"},
+		{"@@ halt\nbrnzp @f\n", "no anonymous label after @F at line 2"},
+		{"a halt\nb brnzp .x\n", "unknown label B.X at line 2"},
+	}
+
+	for i := range testData {
+		_, err := lc3.ParseAssembly(strings.NewReader(testData[i].code))
+		if err == nil {
+			t.Errorf("%d: expected error %q", i, testData[i].error)
+			continue
+		}
+		if !strings.Contains(err.Error(), testData[i].error) {
+			t.Errorf("%d: expected error %q, got %q", i, testData[i].error, err.Error())
+		}
+	}
+}
+
+func Test_LabelReferences(t *testing.T) {
+	file, err := (&lc3.Assembler{}).ParseSource("", strings.NewReader(localLabelsTestCode+`
+		.macro twice reg
+		again	add reg, reg, reg
+				brn again
+		.endm`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, ref := range file.LabelReferences() {
+		prefix := ""
+		if ref.Definition {
+			prefix = "="
+		}
+		names = append(names, prefix+ref.Name)
+	}
+
+	expected := "=MAIN COUNT COUNT OTHER =COUNT =COUNT.LOOP COUNT.LOOP =@@1 @@1 @@2 =@@2 COUNT.DONE =COUNT.DONE " +
+		"=OTHER =OTHER.LOOP MAIN.DONE =MAIN.DONE =AGAIN@TWICE AGAIN@TWICE"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(names, " "))
+	}
+}
@@ -11,6 +11,9 @@ type Word uint16
 const WordMax = math.MaxUint16
 const MrKbsr = 0xFE00 // keyboard status
 const MrKbdr = 0xFE02 // keyboard data
+const MrDsr = 0xFE04  // display status
+const MrDdr = 0xFE06  // display data
+const MrMcr = 0xFFFE  // machine control. clearing bit 15 stops the machine
 
 const (
 	TrapVectGetc  = 0x20
@@ -64,17 +67,68 @@ const (
 	OpTrap        // execute trap
 )
 
+// ErrBadInstruction is returned by Step for RTI and the reserved opcode. PC stays at the instruction
 var ErrBadInstruction = errors.New("bad instruction")
 var ErrNotRunning = errors.New("vm is not running")
 
+// ErrWaitingForInput is returned by Step when GETC or IN has no input yet.
+// the trap is executed again by the next Step
+var ErrWaitingForInput = errors.New("waiting for input")
+
+// prompt printed by IN trap
+const inPrompt = "Input a character> "
+
+// Console is a terminal used by I/O traps: GETC, OUT, PUTS, IN and PUTSP
+type Console interface {
+	// ReadChar returns the next typed character. false if there is no input yet
+	ReadChar() (byte, bool)
+	WriteChar(char byte)
+}
+
 type VM struct {
 	memory               []Word
 	registers            [10]Word
 	running              bool
 	instructionsExecuted uint
+	// PC value after reset
+	origin Word
+	// prompt of IN trap waiting for input is printed
+	prompted bool
 
 	Stdin  chan Word
 	Stdout chan Word
+	// Console is used by I/O traps and the display. they do nothing if it is nil
+	Console Console
+	// SystemTraps makes standard traps jump through the trap vector table like other traps,
+	// so they are served by routines of an operating system image instead of the VM
+	SystemTraps bool
+
+	// what happens when an instruction writes into code marked by ProtectCode
+	CodeWriteAction CodeWriteAction
+
+	observers []Observer
+	// words marked by ProtectCode. nil if nothing is protected
+	protected  []bool
+	codeWrites []CodeWrite
+	// error of a write into protected code by the current instruction
+	codeWriteErr error
+	// instructions by address, see SetDecodeCache. nil if the cache is disabled
+	decoded []decoded
+	// the current instruction at an address which isn't cached
+	current decoded
+	// compiled blocks of EngineBlocks. nil for other engines
+	blocks *blockCache
+	// datapath of EngineMicro. nil for other engines
+	micro *Datapath
+	// cycles counted by the timing model, see SetTiming. nil if cycles aren't counted
+	timing *timing
+	cycles uint
+}
+
+// Observer is notified about memory accesses of instructions and traps. instruction fetches are not reported
+type Observer interface {
+	Load(address Word, value Word)
+	Store(address Word, value Word)
 }
 
 func NewVM(memorySize int) *VM {
@@ -99,6 +153,9 @@ func (m *VM) setFlags(result Word) {
 func (m *VM) Start() {
 	m.Reset()
 	m.running = true
+	if int(MrMcr) < len(m.memory) {
+		m.memory[MrMcr] = 1 << 15
+	}
 }
 
 func (m *VM) Reset() {
@@ -107,7 +164,13 @@ func (m *VM) Reset() {
 	for i := 0; i < len(m.registers); i++ {
 		m.registers[i] = 0
 	}
+	m.registers[RegPC] = m.origin
 	m.instructionsExecuted = 0
+	m.cycles = 0
+	if m.micro != nil {
+		*m.micro = Datapath{State: stateFetch}
+	}
+	m.prompted = false
 }
 
 func (m *VM) Stop() {
@@ -122,6 +185,23 @@ func (m *VM) Step() error {
 	if !m.running {
 		return ErrNotRunning
 	}
+	if m.timing != nil {
+		return m.stepTimed()
+	}
+	return m.execute()
+}
+
+// execute the instruction at PC by the engine of the VM
+func (m *VM) execute() error {
+	if m.micro != nil {
+		return m.stepMicro()
+	}
+	if m.blocks != nil {
+		return m.stepBlock()
+	}
+	if m.decoded != nil {
+		return m.stepDecoded()
+	}
 
 	m.instructionsExecuted++
 
@@ -164,14 +244,14 @@ func (m *VM) Step() error {
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
 		// |  0    0    1    0 |      DR      |               PCOffset9                    |
 		dr := getNBits(instruction, 9, 3)
-		m.registers[dr] = m.ReadMem(m.registers[RegPC] + getNBitsExtended(instruction, 0, 9))
+		m.registers[dr] = m.load(m.registers[RegPC] + getNBitsExtended(instruction, 0, 9))
 		m.setFlags(m.registers[dr])
 	case OpSt:
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
 		// |  0    0    1    1 |      SR      |               PCOffset9                    |
 		sr := getNBits(instruction, 9, 3)
 		offset := getNBitsExtended(instruction, 0, 9)
-		m.WriteMem(m.registers[RegPC]+offset, m.registers[sr])
+		m.store(m.registers[RegPC]+offset, m.registers[sr])
 	case OpJsr:
 		m.registers[RegR7] = m.registers[RegPC]
 		if getNBits(instruction, 11, 1) == 1 {
@@ -206,7 +286,7 @@ func (m *VM) Step() error {
 		dr := getNBits(instruction, 9, 3)
 		baseR := getNBits(instruction, 6, 3)
 		offset := getNBitsExtended(instruction, 0, 6)
-		m.registers[dr] = m.ReadMem(m.registers[baseR] + offset)
+		m.registers[dr] = m.load(m.registers[baseR] + offset)
 		m.setFlags(m.registers[dr])
 	case OpStr:
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
@@ -214,8 +294,9 @@ func (m *VM) Step() error {
 		sr := getNBits(instruction, 9, 3)
 		baseR := getNBits(instruction, 6, 3)
 		offset := getNBitsExtended(instruction, 0, 6)
-		m.WriteMem(m.registers[baseR]+offset, m.registers[sr])
+		m.store(m.registers[baseR]+offset, m.registers[sr])
 	case OpRti:
+		m.retry()
 		return ErrBadInstruction
 	case OpNot:
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
@@ -228,19 +309,20 @@ func (m *VM) Step() error {
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
 		// |  1    0    1    0 |      DR      |                 PCOffset9                  |
 		dr := getNBits(instruction, 9, 3)
-		m.registers[dr] = m.ReadMem(m.ReadMem(m.registers[RegPC] + getNBitsExtended(instruction, 0, 9)))
+		m.registers[dr] = m.load(m.load(m.registers[RegPC] + getNBitsExtended(instruction, 0, 9)))
 		m.setFlags(m.registers[dr])
 	case OpSti:
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
 		// |  1    0    1    1 |      SR      |                 PCOffset9                  |
 		sr := getNBits(instruction, 9, 3)
-		m.WriteMem(m.ReadMem(m.registers[RegPC]+getNBitsExtended(instruction, 0, 9)), m.registers[sr])
+		m.store(m.load(m.registers[RegPC]+getNBitsExtended(instruction, 0, 9)), m.registers[sr])
 	case OpJmp:
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
 		// |  1    1    0    0 |  0    0    0 |     BaseR    |  0    0    0    0    0    0 |
 		baseR := getNBits(instruction, 6, 3)
 		m.registers[RegPC] = m.registers[baseR]
 	case OpRes:
+		m.retry()
 		return ErrBadInstruction
 	case OpLea:
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
@@ -252,37 +334,144 @@ func (m *VM) Step() error {
 	case OpTrap:
 		// | 15 | 14 | 13 | 12 | 11 | 10 |  9 |  8 |  7 |  6 |  5 |  4 |  3 |  2 |  1 |  0 |
 		// |  1    1    1    1 |  0    0    0    0 |              trapvect8                |
-		vector := getNBitsExtended(instruction, 0, 8)
-		switch vector {
-		case TrapVectGetc:
-			//ch := <-m.Stdin
-			//m.registers[RegR0] = ch
-		case TrapVectOut:
-			//fmt.Printf("%c", m.registers[RegR0] & 0xff)
-		case TrapVectPuts:
-			/*ptr := m.registers[RegR0]
-			for {
-				word := m.ReadMem(ptr)
-				char := byte(word & 0xff)
-				if char == 0 {
-					break
-				}
-				fmt.Printf("%c", char)
-				ptr++
-			}*/
-		case TrapVectIn:
-		case TrapVectPutsp:
-		case TrapVectHalt:
-			m.Stop()
-		default:
-			m.registers[RegR7] = m.registers[RegPC]
-			m.registers[RegPC] = m.ReadMem(vector)
+		if err := m.trap(getNBits(instruction, 0, 8)); err != nil {
+			return err
+		}
+	}
+
+	return m.complete()
+}
+
+// execute TRAP. PC already points to the next instruction
+func (m *VM) trap(vector Word) error {
+	if m.SystemTraps {
+		m.registers[RegR7] = m.registers[RegPC]
+		m.registers[RegPC] = m.load(vector)
+		return nil
+	}
+	switch vector {
+	case TrapVectGetc, TrapVectOut, TrapVectPuts, TrapVectIn, TrapVectPutsp:
+		if err := m.trapIO(vector); err != nil {
+			// execute the trap again when input is ready
+			m.retry()
+			return err
+		}
+	case TrapVectHalt:
+		m.Stop()
+	default:
+		m.registers[RegR7] = m.registers[RegPC]
+		m.registers[RegPC] = m.load(vector)
+	}
+	return nil
+}
+
+// finish executed instruction. returns error of a write into protected code
+func (m *VM) complete() error {
+	if err := m.codeWriteErr; err != nil {
+		m.codeWriteErr = nil
+		if err == ErrWriteProtected {
+			m.retry()
+		}
+		return err
+	}
+	return nil
+}
+
+// leave PC at the current instruction which can't be completed
+func (m *VM) retry() {
+	m.registers[RegPC]--
+	m.instructionsExecuted--
+}
+
+// execute I/O trap on the console
+func (m *VM) trapIO(vector Word) error {
+	if m.Console == nil {
+		return nil
+	}
+
+	switch vector {
+	case TrapVectGetc:
+		char, ok := m.Console.ReadChar()
+		if !ok {
+			return ErrWaitingForInput
+		}
+		m.registers[RegR0] = Word(char)
+	case TrapVectOut:
+		m.Console.WriteChar(byte(m.registers[RegR0]))
+	case TrapVectPuts:
+		for address := m.registers[RegR0]; ; address++ {
+			char := m.load(address)
+			if char == 0 || !m.running {
+				break
+			}
+			m.Console.WriteChar(byte(char))
+		}
+	case TrapVectIn:
+		if !m.prompted {
+			for i := 0; i < len(inPrompt); i++ {
+				m.Console.WriteChar(inPrompt[i])
+			}
+			m.prompted = true
+		}
+		char, ok := m.Console.ReadChar()
+		if !ok {
+			return ErrWaitingForInput
+		}
+		m.prompted = false
+		m.Console.WriteChar(char)
+		m.registers[RegR0] = Word(char)
+	case TrapVectPutsp:
+		// two characters per word, the low byte first
+		for address := m.registers[RegR0]; m.running; address++ {
+			word := m.load(address)
+			if word&0xff == 0 {
+				break
+			}
+			m.Console.WriteChar(byte(word))
+			if word>>8 == 0 {
+				break
+			}
+			m.Console.WriteChar(byte(word >> 8))
 		}
 	}
 
 	return nil
 }
 
+// AddObserver starts notifying o about memory accesses
+func (m *VM) AddObserver(o Observer) {
+	m.observers = append(m.observers, o)
+}
+
+func (m *VM) RemoveObserver(o Observer) {
+	for i := range m.observers {
+		if m.observers[i] == o {
+			m.observers = append(m.observers[:i], m.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// read memory by executed instruction
+func (m *VM) load(address Word) Word {
+	value := m.ReadMem(address)
+	for _, o := range m.observers {
+		o.Load(address, value)
+	}
+	return value
+}
+
+// write memory by executed instruction
+func (m *VM) store(address Word, value Word) {
+	if !m.checkCodeWrite(address, value) {
+		return
+	}
+	m.WriteMem(address, value)
+	for _, o := range m.observers {
+		o.Store(address, value)
+	}
+}
+
 func (m *VM) WriteMem(address Word, value Word) {
 	if int(address) >= len(m.memory) {
 		m.Stop()
@@ -290,6 +479,21 @@ func (m *VM) WriteMem(address Word, value Word) {
 	}
 
 	m.memory[address] = value
+	m.invalidate(address)
+	if m.blocks != nil {
+		m.blocks.invalidate(address)
+	}
+
+	switch address {
+	case MrDdr:
+		if m.Console != nil {
+			m.Console.WriteChar(byte(value))
+		}
+	case MrMcr:
+		if value&(1<<15) == 0 {
+			m.Stop()
+		}
+	}
 }
 
 func (m *VM) ReadMem(address Word) Word {
@@ -298,22 +502,35 @@ func (m *VM) ReadMem(address Word) Word {
 		return 0
 	}
 
-	if address == MrKbsr {
+	switch address {
+	case MrKbsr:
 		if len(m.Stdin) > 0 {
 			m.memory[MrKbsr] = 1 << 15
 			m.memory[MrKbdr] = <-m.Stdin
 		} else {
 			m.memory[MrKbsr] = 0
 		}
+	case MrDsr:
+		// the display is always ready
+		m.memory[MrDsr] = 1 << 15
 	}
 
 	return m.memory[address]
 }
 
+// PeekMem reads memory without side effects of memory mapped devices
+func (m *VM) PeekMem(address Word) Word {
+	if int(address) >= len(m.memory) {
+		return 0
+	}
+	return m.memory[address]
+}
+
 func (m *VM) SetOrigin(origin Word) {
 	if m.running {
 		return
 	}
+	m.origin = origin
 	m.registers[RegPC] = origin
 }
 
@@ -329,6 +546,11 @@ func (m *VM) GetRegister(register int) Word {
 	return m.registers[register]
 }
 
+// SetRegister changes register: RegR0-RegR7, RegPC or RegCond
+func (m *VM) SetRegister(register int, value Word) {
+	m.registers[register] = value
+}
+
 func (m *VM) GetMemorySize() int {
 	return len(m.memory)
 }
@@ -0,0 +1,268 @@
+// Package lc3test runs LC-3 programs in go tests. A Case describes the program, its input and
+// the expected state after the run:
+//
+//	func TestEcho(t *testing.T) {
+//		lc3test.New("echo").
+//			File("echo.asm").
+//			Input("a").
+//			ExpectOutput("a").
+//			ExpectRegister(lc3.RegR0, 'a').
+//			Test(t)
+//	}
+//
+// Subroutines are tested with Call, which jumps to a label with R7 set to ReturnAddress
+// and stops when the subroutine returns. Cases sharing a program are built with With
+// and run as subtests by Cases.
+package lc3test
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// DefaultBudget is the number of instructions a case may execute if Budget is not set
+const DefaultBudget = 1000000
+
+// ReturnAddress is put into R7 by Call. the case stops when PC reaches it
+const ReturnAddress lc3.Word = 0xFDFF
+
+type Case struct {
+	Name string
+
+	assembler *lc3.Assembler
+	source    string
+	file      string
+	program   *lc3.Program
+
+	input     string
+	budget    uint
+	maxOutput int
+	call      string
+	registers map[int]lc3.Word
+	memory    map[lc3.Word]lc3.Word
+	// memory at labels
+	labelMemory map[string]lc3.Word
+
+	expectedRegister map[int]lc3.Word
+	isExpectedFlags  bool
+	expectedFlags    lc3.Word
+	expectedMemory   map[lc3.Word]lc3.Word
+	// memory at labels
+	expectedLabelMemory map[string]lc3.Word
+	isExpectedOutput    bool
+	expectedOutput      string
+	goldenOutput        string
+	// program must stop by an exception. nil matches any one
+	isExpectedException bool
+	expectedException   error
+}
+
+// Cases is a table of cases run as subtests
+type Cases []*Case
+
+func New(name string) *Case {
+	return &Case{
+		Name:                name,
+		assembler:           &lc3.Assembler{},
+		budget:              DefaultBudget,
+		registers:           make(map[int]lc3.Word),
+		memory:              make(map[lc3.Word]lc3.Word),
+		labelMemory:         make(map[string]lc3.Word),
+		expectedRegister:    make(map[int]lc3.Word),
+		expectedMemory:      make(map[lc3.Word]lc3.Word),
+		expectedLabelMemory: make(map[string]lc3.Word),
+	}
+}
+
+// With returns a copy of the case named name. expectations and setup of the copy are independent
+func (c *Case) With(name string) *Case {
+	ret := *c
+	ret.Name = name
+	ret.registers = copyRegisters(c.registers)
+	ret.memory = copyMemory(c.memory)
+	ret.expectedRegister = copyRegisters(c.expectedRegister)
+	ret.expectedMemory = copyMemory(c.expectedMemory)
+	ret.labelMemory = copyLabels(c.labelMemory)
+	ret.expectedLabelMemory = copyLabels(c.expectedLabelMemory)
+	return &ret
+}
+
+// Source sets assembly source of the program
+func (c *Case) Source(code string) *Case {
+	c.source, c.file, c.program = code, "", nil
+	return c
+}
+
+// File sets assembly file of the program
+func (c *Case) File(name string) *Case {
+	c.source, c.file, c.program = "", name, nil
+	return c
+}
+
+// Program sets assembled program
+func (c *Case) Program(program *lc3.Program) *Case {
+	c.source, c.file, c.program = "", "", program
+	return c
+}
+
+// Assembler sets assembler used for source and files, e.g. to set include paths and defines
+func (c *Case) Assembler(assembler *lc3.Assembler) *Case {
+	c.assembler = assembler
+	return c
+}
+
+// Input sets characters read by GETC, IN and the keyboard. reading after the end of input is an exception
+func (c *Case) Input(input string) *Case {
+	c.input = input
+	return c
+}
+
+// Budget limits number of executed instructions. exceeding it is an exception
+func (c *Case) Budget(instructions uint) *Case {
+	c.budget = instructions
+	return c
+}
+
+// MaxOutput limits number of printed characters. exceeding it is an exception. zero means no limit
+func (c *Case) MaxOutput(characters int) *Case {
+	c.maxOutput = characters
+	return c
+}
+
+// Limit lowers the budget and the output limit to the caps, e.g. of a sandbox. zero means no cap
+func (c *Case) Limit(instructions uint, characters int) *Case {
+	if instructions > 0 && instructions < c.budget {
+		c.budget = instructions
+	}
+	if characters > 0 && (c.maxOutput == 0 || characters < c.maxOutput) {
+		c.maxOutput = characters
+	}
+	return c
+}
+
+// Call runs subroutine at label instead of the program. it is expected to return by RET
+func (c *Case) Call(label string) *Case {
+	c.call = strings.ToUpper(label)
+	return c
+}
+
+// SetRegister sets register before the run
+func (c *Case) SetRegister(register int, value lc3.Word) *Case {
+	c.registers[register] = value
+	return c
+}
+
+// SetMemory writes memory before the run
+func (c *Case) SetMemory(address lc3.Word, value lc3.Word) *Case {
+	c.memory[address] = value
+	return c
+}
+
+// SetLabel writes memory at label before the run
+func (c *Case) SetLabel(label string, value lc3.Word) *Case {
+	c.labelMemory[strings.ToUpper(label)] = value
+	return c
+}
+
+func (c *Case) ExpectRegister(register int, value lc3.Word) *Case {
+	c.expectedRegister[register] = value
+	return c
+}
+
+func (c *Case) ExpectFlags(flags lc3.Word) *Case {
+	c.isExpectedFlags = true
+	c.expectedFlags = flags
+	return c
+}
+
+func (c *Case) ExpectMemory(address lc3.Word, value lc3.Word) *Case {
+	c.expectedMemory[address] = value
+	return c
+}
+
+func (c *Case) ExpectLabel(label string, value lc3.Word) *Case {
+	c.expectedLabelMemory[strings.ToUpper(label)] = value
+	return c
+}
+
+// ExpectOutput sets everything the program prints
+func (c *Case) ExpectOutput(output string) *Case {
+	c.isExpectedOutput = true
+	c.expectedOutput = output
+	return c
+}
+
+// ExpectGoldenOutput compares the output with file content. the file is rewritten when UpdateEnv is set
+func (c *Case) ExpectGoldenOutput(name string) *Case {
+	c.goldenOutput = name
+	return c
+}
+
+// ExpectHalt expects the program to stop by HALT or the machine control register,
+// or the subroutine to return. it is the default
+func (c *Case) ExpectHalt() *Case {
+	c.isExpectedException = false
+	c.expectedException = nil
+	return c
+}
+
+// ExpectException expects the program to stop by err: lc3.ErrBadInstruction, ErrEndOfInput,
+// ErrBudgetExceeded or ErrOutputExceeded. nil matches any exception
+func (c *Case) ExpectException(err error) *Case {
+	c.isExpectedException = true
+	c.expectedException = err
+	return c
+}
+
+func copyRegisters(src map[int]lc3.Word) map[int]lc3.Word {
+	ret := make(map[int]lc3.Word, len(src))
+	for k, v := range src {
+		ret[k] = v
+	}
+	return ret
+}
+
+func copyMemory(src map[lc3.Word]lc3.Word) map[lc3.Word]lc3.Word {
+	ret := make(map[lc3.Word]lc3.Word, len(src))
+	for k, v := range src {
+		ret[k] = v
+	}
+	return ret
+}
+
+func copyLabels(src map[string]lc3.Word) map[string]lc3.Word {
+	ret := make(map[string]lc3.Word, len(src))
+	for k, v := range src {
+		ret[k] = v
+	}
+	return ret
+}
+
+func sortedRegisters(m map[int]lc3.Word) []int {
+	var ret []int
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+func sortedAddresses(m map[lc3.Word]lc3.Word) []lc3.Word {
+	var ret []lc3.Word
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+func sortedLabels(m map[string]lc3.Word) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,74 @@
+package lc3test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+func Test_Case(t *testing.T) {
+	upper := New("upper").File("testdata/upper.asm")
+
+	Cases{
+		upper.With("output").Input("Hello, lc-3\n").ExpectGoldenOutput("testdata/upper.golden"),
+		upper.With("subroutine").Call("upper").SetRegister(lc3.RegR0, 'q').
+			ExpectRegister(lc3.RegR0, 'Q').ExpectRegister(lc3.RegR7, ReturnAddress),
+		upper.With("not a letter").Call("upper").SetRegister(lc3.RegR0, '!').ExpectRegister(lc3.RegR0, '!'),
+		upper.With("end of input").Input("ab").ExpectOutput("AB").ExpectException(ErrEndOfInput),
+		upper.With("budget").Input("a\n").Budget(5).ExpectException(ErrBudgetExceeded),
+		upper.With("output limit").Input("abc\n").MaxOutput(2).ExpectOutput("AB").ExpectException(ErrOutputExceeded),
+		New("bad instruction").Source("\t.orig x3000\n\t.fill x8000\n\t.end\n").ExpectException(lc3.ErrBadInstruction),
+		New("polling").Source(`	.orig x3000
+wait	ldi r1, kbsr
+	brzp wait
+	ldi r0, kbdr
+	sti r0, ddr
+	halt
+kbsr	.fill xfe00
+kbdr	.fill xfe02
+ddr	.fill xfe06
+	.end
+`).Input("z").ExpectOutput("z").ExpectMemory(0xFE02, 'z').ExpectFlags(lc3.FlP),
+	}.Test(t)
+}
+
+func Test_Check(t *testing.T) {
+	c := New("halt").Source("\t.orig x3000\n\tadd r0, r0, #1\n\thalt\n\t.end\n").
+		ExpectRegister(lc3.RegR0, 2).ExpectOutput("x").ExpectException(nil)
+	result, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Instructions != 2 {
+		t.Errorf("expected 2 instructions, got %d", result.Instructions)
+	}
+	if errs := c.Check(result); len(errs) != 3 {
+		t.Errorf("expected 3 failed expectations, got %v", errs)
+	}
+
+	if _, err := New("call").Source("\t.orig x3000\n\thalt\n\t.end\n").Call("nothere").Run(); err == nil {
+		t.Errorf("expected unknown label error")
+	}
+}
+
+func Test_UpdateGolden(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "hi.golden")
+	c := New("hi").Source("\t.orig x3000\n\tlea r0, hi\n\tputs\n\thalt\nhi\t.stringz \"hi\"\n\t.end\n").ExpectGoldenOutput(name)
+	result, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := c.Check(result); len(errs) != 1 {
+		t.Errorf("expected missing golden file, got %v", errs)
+	}
+
+	t.Setenv(UpdateEnv, "1")
+	if errs := c.Check(result); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if content, err := os.ReadFile(name); err != nil || string(content) != "hi" {
+		t.Errorf("expected golden file with output, got %q, %v", content, err)
+	}
+}
@@ -0,0 +1,191 @@
+package lc3test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Report is the outcome of a case for test runners
+type Report struct {
+	Suite string
+	Name  string
+	// the case can't be run
+	Err      error
+	Failures []error
+	Result   *Result
+	Time     time.Duration
+}
+
+func (r Report) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// Report runs the case and checks its expectations
+func (c *Case) Report(suite string) Report {
+	ret := Report{Suite: suite, Name: c.Name}
+	start := time.Now()
+	ret.Result, ret.Err = c.Run()
+	if ret.Err == nil {
+		ret.Failures = c.Check(ret.Result)
+	}
+	ret.Time = time.Since(start)
+	return ret
+}
+
+// Diff shows expected and actual state of failed report:
+//
+//	--- expected
+//	+++ actual
+//	-R0 = x0051
+//	+R0 = x0071
+func (r Report) Diff() string {
+	if r.Err != nil {
+		return r.Err.Error() + "\n"
+	}
+	var ret strings.Builder
+	if len(r.Failures) > 0 {
+		ret.WriteString("--- expected\n+++ actual\n")
+	}
+	for _, failure := range r.Failures {
+		if m, ok := failure.(Mismatch); ok {
+			fmt.Fprintf(&ret, "-%s = %s\n+%s = %s\n", m.Name, m.Expected, m.Name, m.Actual)
+		} else {
+			fmt.Fprintf(&ret, "!%v\n", failure)
+		}
+	}
+	return ret.String()
+}
+
+// WriteText writes PASS or FAIL line of every report with diffs of failed ones and the summary
+func WriteText(w io.Writer, reports []Report) error {
+	failed := 0
+	for _, r := range reports {
+		status := "PASS"
+		if !r.Passed() {
+			status = "FAIL"
+			failed++
+		}
+		if _, err := fmt.Fprintf(w, "%s %s: %s\n", status, r.Suite, r.Name); err != nil {
+			return err
+		}
+		if !r.Passed() {
+			if _, err := io.WriteString(w, indent(r.Diff(), "\t")); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d failed\n", len(reports)-failed, failed)
+	return err
+}
+
+// WriteTAP writes reports in Test Anything Protocol version 13. diffs are in YAML blocks
+func WriteTAP(w io.Writer, reports []Report) error {
+	if _, err := fmt.Fprintf(w, "TAP version 13\n1..%d\n", len(reports)); err != nil {
+		return err
+	}
+	for i, r := range reports {
+		status := "ok"
+		if !r.Passed() {
+			status = "not ok"
+		}
+		if _, err := fmt.Fprintf(w, "%s %d - %s: %s\n", status, i+1, r.Suite, r.Name); err != nil {
+			return err
+		}
+		if !r.Passed() {
+			if _, err := fmt.Fprintf(w, "  ---\n  diff: |\n%s  ...\n", indent(r.Diff(), "    ")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes reports as JUnit XML with a test suite for every suite name
+func WriteJUnit(w io.Writer, reports []Report) error {
+	var suites junitSuites
+	index := make(map[string]int)
+	var times []time.Duration
+
+	for _, r := range reports {
+		i, ok := index[r.Suite]
+		if !ok {
+			i = len(suites.Suites)
+			index[r.Suite] = i
+			suites.Suites = append(suites.Suites, junitSuite{Name: r.Suite})
+			times = append(times, 0)
+		}
+		suite := &suites.Suites[i]
+		times[i] += r.Time
+
+		c := junitCase{Name: r.Name, Classname: r.Suite, Time: seconds(r.Time)}
+		switch {
+		case r.Err != nil:
+			suite.Errors++
+			c.Error = &junitFailure{Message: r.Err.Error(), Text: r.Diff()}
+		case len(r.Failures) > 0:
+			suite.Failures++
+			c.Failure = &junitFailure{Message: r.Failures[0].Error(), Text: r.Diff()}
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, c)
+	}
+	for i := range suites.Suites {
+		suites.Suites[i].Time = seconds(times[i])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// prefix every line of text
+func indent(text string, prefix string) string {
+	var ret strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line != "" {
+			ret.WriteString(prefix + line)
+		}
+	}
+	return ret.String()
+}
@@ -0,0 +1,282 @@
+package lc3test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pkg/errors"
+)
+
+// UpdateEnv is the environment variable rewriting golden files instead of comparing the output with them
+// when it is not empty, e.g. LC3TEST_UPDATE=1 go test
+const UpdateEnv = "LC3TEST_UPDATE"
+
+// ErrEndOfInput stops a program reading more characters than given by Input
+var ErrEndOfInput = errors.New("end of input")
+
+// ErrBudgetExceeded stops a program executing more instructions than its budget
+var ErrBudgetExceeded = errors.New("instruction budget exceeded")
+
+// ErrOutputExceeded stops a program printing more characters than allowed by MaxOutput
+var ErrOutputExceeded = errors.New("output limit exceeded")
+
+// Result is the state of the program after the run
+type Result struct {
+	VM           *lc3.VM
+	Program      *lc3.Program
+	Output       string
+	Instructions uint
+	// exception stopped the program. nil if it halted or returned
+	Exception error
+	// PC of the instruction which caused the exception
+	ExceptionPC lc3.Word
+}
+
+// console with the whole input known in advance
+type console struct {
+	vm     *lc3.VM
+	input  string
+	output strings.Builder
+	// output limit, zero if unlimited
+	max      int
+	exceeded bool
+}
+
+// keep one character in the keyboard buffer, so both traps and polling of the keyboard see it
+func (c *console) fill() {
+	if len(c.vm.Stdin) == 0 && c.input != "" {
+		c.vm.Stdin <- lc3.Word(c.input[0])
+		c.input = c.input[1:]
+	}
+}
+
+func (c *console) ReadChar() (byte, bool) {
+	c.fill()
+	select {
+	case char := <-c.vm.Stdin:
+		return byte(char), true
+	default:
+		return 0, false
+	}
+}
+
+func (c *console) WriteChar(char byte) {
+	if c.max > 0 && c.output.Len() >= c.max {
+		c.exceeded = true
+		c.vm.Stop()
+		return
+	}
+	c.output.WriteByte(char)
+}
+
+func (c *Case) assemble() (*lc3.Program, error) {
+	switch {
+	case c.program != nil:
+		return c.program, nil
+	case c.file != "":
+		return c.assembler.AssembleFile(c.file)
+	case c.source != "":
+		return c.assembler.Assemble(c.Name+".asm", strings.NewReader(c.source))
+	}
+	return nil, errors.New("no program")
+}
+
+// Run assembles and runs the program. the error is returned if it can't be started,
+// expectations are checked by Check
+func (c *Case) Run() (*Result, error) {
+	program, err := c.assemble()
+	if err != nil {
+		return nil, err
+	}
+
+	vm := program.NewVM()
+	vm.Start()
+	con := &console{vm: vm, input: c.input, max: c.maxOutput}
+	vm.Console = con
+
+	if c.call != "" {
+		address, ok := program.Symbol(c.call)
+		if !ok {
+			return nil, errors.Errorf("unknown label %s", c.call)
+		}
+		vm.SetRegister(lc3.RegPC, address)
+		vm.SetRegister(lc3.RegR7, ReturnAddress)
+	}
+	for register, value := range c.registers {
+		vm.SetRegister(register, value)
+	}
+	for address, value := range c.memory {
+		vm.WriteMem(address, value)
+	}
+	for label, value := range c.labelMemory {
+		address, ok := program.Symbol(label)
+		if !ok {
+			return nil, errors.Errorf("unknown label %s", label)
+		}
+		vm.WriteMem(address, value)
+	}
+
+	ret := &Result{VM: vm, Program: program}
+	for vm.IsRunning() {
+		pc := vm.GetRegister(lc3.RegPC)
+		if c.call != "" && pc == ReturnAddress {
+			break
+		}
+		if vm.GetInstructionsExecuted() >= c.budget {
+			ret.Exception, ret.ExceptionPC = ErrBudgetExceeded, pc
+			break
+		}
+		con.fill()
+		switch err := vm.Step(); err {
+		case nil, lc3.ErrNotRunning:
+		case lc3.ErrWaitingForInput:
+			ret.Exception, ret.ExceptionPC = ErrEndOfInput, pc
+		default:
+			ret.Exception, ret.ExceptionPC = err, pc
+		}
+		if con.exceeded {
+			ret.Exception, ret.ExceptionPC = ErrOutputExceeded, pc
+		}
+		if ret.Exception != nil {
+			break
+		}
+	}
+	ret.Output = con.output.String()
+	ret.Instructions = vm.GetInstructionsExecuted()
+	return ret, nil
+}
+
+// Mismatch is an expectation not met by the result
+type Mismatch struct {
+	// register, memory location, flags, output or stop
+	Name     string
+	Expected string
+	Actual   string
+}
+
+func (m Mismatch) Error() string {
+	return fmt.Sprintf("expected %s = %s, got %s", m.Name, m.Expected, m.Actual)
+}
+
+// Check returns mismatches of all expectations not met by the result
+func (c *Case) Check(result *Result) []error {
+	var ret []error
+	m := result.VM
+	mismatch := func(name, expected, actual string) {
+		ret = append(ret, Mismatch{Name: name, Expected: expected, Actual: actual})
+	}
+
+	stop := "halt"
+	if result.Exception != nil {
+		stop = fmt.Sprintf("%v at %s", result.Exception, hex(result.ExceptionPC))
+	}
+	switch {
+	case !c.isExpectedException && result.Exception != nil:
+		mismatch("stop", "halt", stop)
+	case c.isExpectedException && result.Exception == nil:
+		mismatch("stop", "exception", stop)
+	case c.isExpectedException && c.expectedException != nil && c.expectedException != result.Exception:
+		mismatch("stop", c.expectedException.Error(), stop)
+	}
+
+	for _, register := range sortedRegisters(c.expectedRegister) {
+		value := c.expectedRegister[register]
+		if got := m.GetRegister(register); got != value {
+			mismatch(RegisterName(register), hex(value), hex(got))
+		}
+	}
+
+	if got := m.GetRegister(lc3.RegCond); c.isExpectedFlags && c.expectedFlags != got {
+		mismatch("flags", c.expectedFlags.FlagsAsString(), got.FlagsAsString())
+	}
+
+	for _, address := range sortedAddresses(c.expectedMemory) {
+		value := c.expectedMemory[address]
+		if got := m.PeekMem(address); got != value {
+			mismatch(hex(address), hex(value), hex(got))
+		}
+	}
+	for _, label := range sortedLabels(c.expectedLabelMemory) {
+		value := c.expectedLabelMemory[label]
+		address, ok := result.Program.Symbol(label)
+		if !ok {
+			mismatch(label, hex(value), "unknown label")
+			continue
+		}
+		if got := m.PeekMem(address); got != value {
+			mismatch(label, hex(value), hex(got))
+		}
+	}
+
+	if c.isExpectedOutput && c.expectedOutput != result.Output {
+		mismatch("output", fmt.Sprintf("%q", c.expectedOutput), fmt.Sprintf("%q", result.Output))
+	}
+	if c.goldenOutput != "" {
+		if err := checkGolden(c.goldenOutput, result.Output); err != nil {
+			ret = append(ret, err)
+		}
+	}
+
+	return ret
+}
+
+// word in LC-3 assembler notation
+func hex(value lc3.Word) string {
+	return fmt.Sprintf("x%04X", value)
+}
+
+// RegisterName returns R0-R7, PC or COND
+func RegisterName(register int) string {
+	switch register {
+	case lc3.RegPC:
+		return "PC"
+	case lc3.RegCond:
+		return "COND"
+	}
+	return fmt.Sprintf("R%d", register)
+}
+
+// Test runs the case and reports failed expectations to t
+func (c *Case) Test(t testing.TB) {
+	t.Helper()
+	result, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range c.Check(result) {
+		t.Error(err)
+	}
+}
+
+// Test runs every case as a subtest named by the case
+func (cs Cases) Test(t *testing.T) {
+	t.Helper()
+	for i := range cs {
+		c := cs[i]
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Helper()
+			c.Test(t)
+		})
+	}
+}
+
+func checkGolden(name string, output string) error {
+	if os.Getenv(UpdateEnv) != "" {
+		return errors.Wrap(os.WriteFile(name, []byte(output), 0644), "update golden file")
+	}
+	expected, err := os.ReadFile(name)
+	if err != nil {
+		return errors.Wrap(err, "read golden file")
+	}
+	if string(expected) != output {
+		return Mismatch{Name: "output of " + name, Expected: fmt.Sprintf("%q", expected), Actual: fmt.Sprintf("%q", output)}
+	}
+	return nil
+}
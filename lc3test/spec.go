@@ -0,0 +1,258 @@
+package lc3test
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pkg/errors"
+)
+
+// SpecExt is the extension of spec files. file.spec tests file.asm
+const SpecExt = ".spec"
+
+// Spec is a set of cases read from a plain text spec file. Every line is a directive,
+// lines before the first case apply to all cases. # and ; start comments:
+//
+//	program upper.asm        assembly file, relative to the spec. file.asm of file.spec by default
+//	case lower case letter   start a case named by the rest of the line
+//	call UPPER               run subroutine instead of the program
+//	input "q\n"              characters read by the program, quoted in Go syntax or as is
+//	steps 1000               instruction budget
+//	set R0 = 'q'             initial value of R0-R7, PC, memory address or label
+//	expect R0 = x51          expected value of R0-R7, PC, memory address or label
+//	expect flags = p         expected condition flag: n, z or p
+//	expect output "Q"        everything printed by the program
+//	expect halt              program halts or subroutine returns. default
+//	expect exception         program stops by an exception: bad instruction, end of input,
+//	                         budget or output. any one if the kind is omitted
+//
+// Values are written as N, #N, xN, 0xN, negative decimal numbers or 'c' characters.
+type Spec struct {
+	Name  string
+	Cases Cases
+}
+
+// ParseSpecFile reads spec from file
+func ParseSpecFile(name string) (*Spec, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseSpec(name, file)
+}
+
+// ParseSpec reads spec named by its file name. programs are looked up relative to it
+func ParseSpec(name string, reader io.Reader) (*Spec, error) {
+	ret := &Spec{Name: strings.TrimSuffix(filepath.Base(name), SpecExt)}
+	base := New(ret.Name).File(strings.TrimSuffix(name, SpecExt) + ".asm")
+	current := base
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		directive, arg := splitWord(line)
+
+		var err error
+		switch strings.ToLower(directive) {
+		case "program":
+			current.File(filepath.Join(filepath.Dir(name), arg))
+		case "case":
+			if arg == "" {
+				err = errors.New("case name expected")
+				break
+			}
+			current = base.With(arg)
+			ret.Cases = append(ret.Cases, current)
+		case "call":
+			current.Call(arg)
+		case "input":
+			var input string
+			if input, err = parseText(arg); err == nil {
+				current.Input(input)
+			}
+		case "steps":
+			var steps uint64
+			if steps, err = strconv.ParseUint(arg, 10, 0); err == nil {
+				current.Budget(uint(steps))
+			}
+		case "set":
+			err = parseSet(current, arg)
+		case "expect":
+			err = parseExpect(current, arg)
+		default:
+			err = errors.Errorf("unknown directive %s", directive)
+		}
+		if err != nil {
+			return nil, errors.Errorf("%v at %s:%d", err, name, lineNumber)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ret.Cases) == 0 {
+		ret.Cases = Cases{base}
+	}
+	return ret, nil
+}
+
+// Run runs all cases of the spec
+func (s *Spec) Run() []Report {
+	var ret []Report
+	for _, c := range s.Cases {
+		ret = append(ret, c.Report(s.Name))
+	}
+	return ret
+}
+
+// first word of line and the rest
+func splitWord(line string) (string, string) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
+
+func parseSet(c *Case, arg string) error {
+	target, value, err := parseAssignment(arg)
+	if err != nil {
+		return err
+	}
+	if register, ok := parseRegister(target); ok {
+		c.SetRegister(register, value)
+	} else if address, err := parseValue(target); err == nil {
+		c.SetMemory(address, value)
+	} else {
+		c.SetLabel(target, value)
+	}
+	return nil
+}
+
+func parseExpect(c *Case, arg string) error {
+	what, rest := splitWord(arg)
+	switch strings.ToLower(what) {
+	case "halt":
+		c.ExpectHalt()
+		return nil
+	case "exception":
+		return parseException(c, rest)
+	case "output":
+		output, err := parseText(rest)
+		if err != nil {
+			return err
+		}
+		c.ExpectOutput(output)
+		return nil
+	case "flags":
+		return parseFlags(c, rest)
+	}
+
+	target, value, err := parseAssignment(arg)
+	if err != nil {
+		return err
+	}
+	if register, ok := parseRegister(target); ok {
+		c.ExpectRegister(register, value)
+	} else if address, err := parseValue(target); err == nil {
+		c.ExpectMemory(address, value)
+	} else {
+		c.ExpectLabel(target, value)
+	}
+	return nil
+}
+
+func parseException(c *Case, kind string) error {
+	switch strings.ToLower(kind) {
+	case "":
+		c.ExpectException(nil)
+	case lc3.ErrBadInstruction.Error():
+		c.ExpectException(lc3.ErrBadInstruction)
+	case ErrEndOfInput.Error():
+		c.ExpectException(ErrEndOfInput)
+	case ErrBudgetExceeded.Error(), "budget":
+		c.ExpectException(ErrBudgetExceeded)
+	case ErrOutputExceeded.Error(), "output":
+		c.ExpectException(ErrOutputExceeded)
+	default:
+		return errors.Errorf("unknown exception %s", kind)
+	}
+	return nil
+}
+
+func parseFlags(c *Case, arg string) error {
+	arg = strings.TrimSpace(strings.TrimPrefix(arg, "="))
+	switch strings.ToLower(arg) {
+	case "n":
+		c.ExpectFlags(lc3.FlN)
+	case "z":
+		c.ExpectFlags(lc3.FlZ)
+	case "p":
+		c.ExpectFlags(lc3.FlP)
+	default:
+		return errors.Errorf("bad flags %s", arg)
+	}
+	return nil
+}
+
+// target = value. the equals sign is optional
+func parseAssignment(arg string) (string, lc3.Word, error) {
+	target, rest := splitWord(arg)
+	if i := strings.Index(target, "="); i >= 0 {
+		target, rest = target[:i], target[i:]
+	}
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+	if target == "" || rest == "" {
+		return "", 0, errors.Errorf("target and value expected in %q", arg)
+	}
+	value, err := parseValue(rest)
+	if err != nil {
+		return "", 0, err
+	}
+	return target, value, nil
+}
+
+func parseRegister(name string) (int, bool) {
+	name = strings.ToUpper(name)
+	if name == "PC" {
+		return lc3.RegPC, true
+	}
+	if len(name) == 2 && name[0] == 'R' && name[1] >= '0' && name[1] <= '7' {
+		return int(name[1] - '0'), true
+	}
+	return 0, false
+}
+
+func parseValue(text string) (lc3.Word, error) {
+	if len(text) >= 3 && text[0] == '\'' {
+		char, _, tail, err := strconv.UnquoteChar(text[1:], '\'')
+		if err != nil || tail != "'" || char > 0xFF {
+			return 0, errors.Errorf("bad character %s", text)
+		}
+		return lc3.Word(char), nil
+	}
+
+	n, err := lc3.ParseNumber(text)
+	if err != nil {
+		return 0, errors.Errorf("bad value %s", text)
+	}
+	return n, nil
+}
+
+// Go quoted string or the text as is
+func parseText(text string) (string, error) {
+	if strings.HasPrefix(text, `"`) {
+		ret, err := strconv.Unquote(text)
+		return ret, errors.Wrapf(err, "bad string %s", text)
+	}
+	return text, nil
+}
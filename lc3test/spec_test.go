@@ -0,0 +1,89 @@
+package lc3test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_Spec(t *testing.T) {
+	spec, err := ParseSpecFile("testdata/upper.spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Name != "upper" || len(spec.Cases) != 5 {
+		t.Fatalf("unexpected spec %s with %d cases", spec.Name, len(spec.Cases))
+	}
+	for _, r := range spec.Run() {
+		if !r.Passed() {
+			t.Errorf("%s failed:\n%s", r.Name, r.Diff())
+		}
+	}
+}
+
+func Test_SpecErrors(t *testing.T) {
+	for spec, expected := range map[string]string{
+		"case\n":               "case name expected at bad.spec:1",
+		"case a\nexpect R0\n":  "target and value expected in \"R0\" at bad.spec:2",
+		"set R1 = xg\n":        "bad value xg at bad.spec:1",
+		"expect flags = nz\n":  "bad flags nz at bad.spec:1",
+		"\n; comment\nbreak\n": "unknown directive break at bad.spec:3",
+	} {
+		if _, err := ParseSpec("bad.spec", strings.NewReader(spec)); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", spec, expected, err)
+		}
+	}
+}
+
+func Test_Reports(t *testing.T) {
+	spec, err := ParseSpec("testdata/upper.spec", strings.NewReader(`
+case pass
+call upper
+set R0 = 'a'
+expect R0 = 'A'
+
+case fail
+call upper
+set R0 = 'a'
+expect R0 = 'a'
+expect output "a"
+
+case error
+call nothere
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := spec.Run()
+
+	diff := "--- expected\n+++ actual\n-R0 = x0061\n+R0 = x0041\n-output = \"a\"\n+output = \"\"\n"
+	if got := reports[1].Diff(); got != diff {
+		t.Errorf("expected diff %q, got %q", diff, got)
+	}
+
+	var tap bytes.Buffer
+	if err := WriteTAP(&tap, reports); err != nil {
+		t.Fatal(err)
+	}
+	expected := "TAP version 13\n1..3\nok 1 - upper: pass\nnot ok 2 - upper: fail\n  ---\n  diff: |\n" +
+		"    --- expected\n    +++ actual\n    -R0 = x0061\n    +R0 = x0041\n    -output = \"a\"\n    +output = \"\"\n  ...\n" +
+		"not ok 3 - upper: error\n  ---\n  diff: |\n    unknown label NOTHERE\n  ...\n"
+	if tap.String() != expected {
+		t.Errorf("unexpected TAP output:\n%s", tap.String())
+	}
+
+	var junit bytes.Buffer
+	if err := WriteJUnit(&junit, reports); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<testsuite name="upper" tests="3" failures="1" errors="1"`,
+		`<testcase name="pass" classname="upper"`,
+		`<failure message="expected R0 = x0061, got x0041">`,
+		`<error message="unknown label NOTHERE">`,
+	} {
+		if !strings.Contains(junit.String(), s) {
+			t.Errorf("%s is expected in JUnit output:\n%s", s, junit.String())
+		}
+	}
+}
@@ -0,0 +1,24 @@
+; prints input in upper case until a newline
+	.orig x3000
+main	getc
+	add r1, r0, #-10
+	brz done
+	jsr upper
+	out
+	brnzp main
+done	halt
+
+; converts lower case letter in R0 to upper case
+upper	ld r1, minusa
+	add r1, r0, r1
+	brn skip
+	ld r1, minusz
+	add r1, r0, r1
+	brp skip
+	ld r1, case
+	add r0, r0, r1
+skip	ret
+minusa	.fill #-97
+minusz	.fill #-122
+case	.fill #-32
+	.end
@@ -0,0 +1,31 @@
+# cases of upper.asm
+steps 1000
+
+case whole line
+input "Hello, lc-3\n"
+expect output "HELLO, LC-3"
+expect halt
+
+case subroutine
+call upper
+set R0 = 'q'
+expect R0 = 'Q'
+expect R7 = xFDFF
+expect flags = p
+
+case constant
+call upper
+set case = #-31
+set R0 = 'a'
+expect R0 = 'B'
+expect case = -31
+
+case no newline
+input ab
+expect output AB
+expect exception end of input
+
+case endless
+steps 10
+input abcdefgh
+expect exception budget
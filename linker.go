@@ -0,0 +1,221 @@
+package lc3
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// memory range taken by a placed section
+type placement struct {
+	object  *Object
+	section int
+	start   int
+	end     int
+}
+
+// reference to a symbol of some object
+type objectSymbol struct {
+	object *Object
+	ObjectSymbol
+}
+
+// Link places sections of objects into memory and resolves symbols between them.
+// fixed sections stay at their origins, relocatable ones are placed one after another
+// starting from base, skipping memory taken by other sections.
+// the program starts at the first non-empty section of the first object
+func Link(base Word, objects ...*Object) (*Program, error) {
+	globals := make(map[string]objectSymbol)
+	for _, obj := range objects {
+		for name, symbol := range obj.Symbols {
+			if !symbol.Global {
+				continue
+			}
+			if other, ok := globals[name]; ok {
+				return nil, errors.Errorf("symbol %s is defined in both %s and %s", name, other.object.Name, obj.Name)
+			}
+			globals[name] = objectSymbol{obj, symbol}
+		}
+	}
+
+	places, err := placeSections(int(base), objects)
+	if err != nil {
+		return nil, err
+	}
+
+	// address where section is placed
+	address := func(obj *Object, section int) Word {
+		return Word(places[obj][section].start)
+	}
+
+	// final address of address inside a section
+	relocate := func(obj *Object, section int, addr Word) Word {
+		return address(obj, section) + (addr - obj.Sections[section].Origin)
+	}
+
+	ret := &Program{}
+	if len(objects) > 0 {
+		for i, section := range objects[0].Sections {
+			if len(section.Words) > 0 {
+				ret.Entry = address(objects[0], i)
+				break
+			}
+		}
+	}
+
+	for _, obj := range objects {
+		sections := make([]Section, len(obj.Sections))
+		for i, section := range obj.Sections {
+			sections[i] = Section{Origin: address(obj, i), Words: append([]Word{}, section.Words...)}
+		}
+
+		for _, reloc := range obj.Relocations {
+			symbol, ok := obj.Symbols[reloc.Symbol]
+			target := objectSymbol{obj, symbol}
+			if !ok {
+				target, ok = globals[reloc.Symbol]
+				if !ok {
+					return nil, errors.Errorf("undefined symbol %s referenced in %s", reloc.Symbol, obj.Name)
+				}
+			}
+
+			targetAddress := relocate(target.object, target.Section, target.Address)
+			section := &sections[reloc.Section]
+			offset := reloc.Address - obj.Sections[reloc.Section].Origin
+			from := section.Origin + offset
+
+			var value, bits Word
+			switch reloc.Kind {
+			case RelocAbsolute:
+				section.Words[offset] = targetAddress
+				continue
+			case RelocPCOffset9:
+				bits = 9
+			case RelocPCOffset11:
+				bits = 11
+			default:
+				return nil, errors.Errorf("unknown relocation kind %d in %s", reloc.Kind, obj.Name)
+			}
+
+			value = targetAddress - (from + 1)
+			if !fitsSigned(value, bits) {
+				return nil, errors.Errorf("%s to %s is out of range at x%04X in %s", reloc.Kind, reloc.Symbol, from, obj.Name)
+			}
+
+			mask := Word(1)<<bits - 1
+			section.Words[offset] = section.Words[offset]&^mask | value&mask
+		}
+
+		for _, section := range sections {
+			if len(section.Words) > 0 {
+				ret.Sections = append(ret.Sections, section)
+			}
+		}
+
+		for _, line := range obj.Lines {
+			line.Address = relocate(obj, line.Section, line.Address)
+			ret.Lines = append(ret.Lines, line.SourceLine)
+		}
+
+		for name, symbol := range obj.Symbols {
+			ret.Symbols = append(ret.Symbols, Symbol{Name: name, Address: relocate(obj, symbol.Section, symbol.Address)})
+		}
+	}
+
+	sort.Slice(ret.Sections, func(i, j int) bool {
+		return ret.Sections[i].Origin < ret.Sections[j].Origin
+	})
+	sort.SliceStable(ret.Lines, func(i, j int) bool {
+		return ret.Lines[i].Address < ret.Lines[j].Address
+	})
+	sort.Slice(ret.Symbols, func(i, j int) bool {
+		if ret.Symbols[i].Address != ret.Symbols[j].Address {
+			return ret.Symbols[i].Address < ret.Symbols[j].Address
+		}
+		return ret.Symbols[i].Name < ret.Symbols[j].Name
+	})
+
+	return ret, nil
+}
+
+// check if signed value can be stored in given number of bits
+func fitsSigned(value Word, bits Word) bool {
+	v := int(int16(value))
+	return v >= -(1<<(bits-1)) && v < 1<<(bits-1)
+}
+
+// source position of the first word of section for diagnostics. the object name if the section has no lines
+func (obj *Object) sectionPosition(section int) string {
+	for _, line := range obj.Lines {
+		if line.Section == section {
+			return Position{File: line.File, Line: line.Line}.location()
+		}
+	}
+	return obj.Name
+}
+
+func placeSections(base int, objects []*Object) (map[*Object][]placement, error) {
+	ret := make(map[*Object][]placement)
+	var taken []placement
+
+	overlap := func(p placement) *placement {
+		if p.start == p.end {
+			return nil
+		}
+		for i := range taken {
+			if p.start < taken[i].end && taken[i].start < p.end {
+				return &taken[i]
+			}
+		}
+		return nil
+	}
+
+	// fixed sections first
+	for _, obj := range objects {
+		ret[obj] = make([]placement, len(obj.Sections))
+		for i, section := range obj.Sections {
+			if section.Relocatable {
+				continue
+			}
+			p := placement{obj, i, int(section.Origin), int(section.Origin) + len(section.Words)}
+			if p.end > WordMax+1 {
+				return nil, errors.Errorf("section of %s at x%04X does not fit into memory", obj.Name, section.Origin)
+			}
+			if other := overlap(p); other != nil {
+				return nil, errors.Errorf("sections at %s and %s overlap at x%04X",
+					other.object.sectionPosition(other.section), obj.sectionPosition(i), maxInt(p.start, other.start))
+			}
+			ret[obj][i] = p
+			taken = append(taken, p)
+		}
+	}
+
+	cursor := base
+	for _, obj := range objects {
+		for i, section := range obj.Sections {
+			if !section.Relocatable {
+				continue
+			}
+			p := placement{obj, i, cursor, cursor + len(section.Words)}
+			for other := overlap(p); other != nil; other = overlap(p) {
+				p.start = other.end
+				p.end = p.start + len(section.Words)
+			}
+			if p.end > WordMax+1 {
+				return nil, errors.Errorf("no room for section of %s", obj.Name)
+			}
+			ret[obj][i] = p
+			taken = append(taken, p)
+			cursor = p.end
+		}
+	}
+
+	return ret, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
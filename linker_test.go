@@ -0,0 +1,240 @@
+package lc3
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func parseTestObject(t *testing.T, name string, code string) *Object {
+	obj, err := (&Assembler{}).ParseObject(name, strings.NewReader(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func Test_Link(t *testing.T) {
+	main := parseTestObject(t, "main.asm", `
+			.external double
+			.external table
+					add r0, r0, #5
+					jsr double
+					ld r2, ptr
+					ldr r3, r2, #1
+					halt
+			ptr		.fill table`)
+
+	lib := parseTestObject(t, "lib.asm", `
+			.global double
+			.global table
+			double	add r0, r0, r0
+					ret
+			table	.fill #10
+					.fill #20`)
+
+	// library is shipped without its source
+	var buffer bytes.Buffer
+	if err := lib.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := ReadObject(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := Link(0x3000, main, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if program.Entry != 0x3000 {
+		t.Errorf("expected entry x3000, got x%04X", program.Entry)
+	}
+	if address, _ := program.Symbol("DOUBLE"); address != 0x3006 {
+		t.Errorf("expected DOUBLE at x3006, got x%04X", address)
+	}
+	if address, _ := program.Symbol("PTR"); address != 0x3005 {
+		t.Errorf("expected PTR at x3005, got x%04X", address)
+	}
+
+	m := program.NewVM()
+	m.Start()
+	for m.Step() == nil {
+	}
+
+	for register, value := range map[int]Word{RegR0: 10, RegR2: 0x3008, RegR3: 20} {
+		if m.GetRegister(register) != value {
+			t.Errorf("expected register %d = x%04X, got x%04X", register, value, m.GetRegister(register))
+		}
+	}
+}
+
+func Test_LinkFixedSections(t *testing.T) {
+	fixed := parseTestObject(t, "fixed.asm", `
+			.global value
+			.orig x3001
+			value	.fill #42`)
+	reloc := parseTestObject(t, "reloc.asm", `
+			.external value
+					ld r0, value
+					halt`)
+
+	program, err := Link(0x3000, reloc, fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// relocatable section does not fit before x3001, so it goes after the fixed one
+	if program.Entry != 0x3002 {
+		t.Errorf("expected entry x3002, got x%04X", program.Entry)
+	}
+
+	m := program.NewVM()
+	m.Start()
+	for m.Step() == nil {
+	}
+	if m.GetRegister(RegR0) != 42 {
+		t.Errorf("expected r0 = 42, got %d", m.GetRegister(RegR0))
+	}
+}
+
+func Test_OrigLabel(t *testing.T) {
+	program, err := Link(0x4000, parseTestObject(t, "main.asm", `
+			main	.orig x3000
+					add r0, r0, #1
+					halt
+			.end`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if program.Entry != 0x3000 {
+		t.Errorf("expected entry x3000, got x%04X", program.Entry)
+	}
+	if len(program.Sections) != 1 || program.Sections[0].Origin != 0x3000 {
+		t.Errorf("expected a section at x3000, got %v", program.Sections)
+	}
+	for _, symbol := range program.Symbols {
+		if symbol.Name == "MAIN" && symbol.Address != 0x3000 {
+			t.Errorf("expected MAIN at x3000, got x%04X", symbol.Address)
+		}
+	}
+}
+
+func Test_LinkErrors(t *testing.T) {
+	type testCase struct {
+		objects []string
+		error   string
+	}
+
+	testData := []testCase{
+		{[]string{".global a\na halt", ".global a\na halt"}, "symbol A is defined in both 0.asm and 1.asm"},
+		{[]string{".external a\njsr a"}, "undefined symbol A referenced in 0.asm"},
+		{[]string{".external a\nld r0, a", ".global a\n.orig x4000\na .fill #0"}, "PCoffset9 to A is out of range at x3000 in 0.asm"},
+		{[]string{".orig x3000\nhalt\nhalt", ".orig x3001\nhalt"}, "sections at 0.asm:2 and 1.asm:2 overlap at x3001"},
+	}
+
+	for i := range testData {
+		var objects []*Object
+		for j, code := range testData[i].objects {
+			objects = append(objects, parseTestObject(t, string(rune('0'+j))+".asm", code))
+		}
+		_, err := Link(0x3000, objects...)
+		if err == nil {
+			t.Errorf("%d: expected error %q", i, testData[i].error)
+			continue
+		}
+		if !strings.Contains(err.Error(), testData[i].error) {
+			t.Errorf("%d: expected error %q, got %q", i, testData[i].error, err.Error())
+		}
+	}
+}
+
+// sections of one source file are reported by their lines
+func Test_OverlapInSource(t *testing.T) {
+	for name, expected := range map[string]string{
+		"main.asm": "sections at main.asm:2 and main.asm:5 overlap at x3001",
+		"":         "sections at line 2 and line 5 overlap at x3001",
+	} {
+		_, err := (&Assembler{}).Assemble(name, strings.NewReader(".orig x3000\nhalt\nhalt\n.orig x3001\nhalt\n.end\n"))
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", name, expected, err)
+		}
+	}
+}
+
+func Test_ObjectErrors(t *testing.T) {
+	type testCase struct {
+		code  string
+		error string
+	}
+
+	testData := []testCase{
+		{".global a\nhalt", "global label A is not defined at line 1"},
+		{".external a\na halt", "external label A is defined at line 2"},
+		{"l add r0, r0, #1\nl halt", "label L already defined at line 2"},
+		{"l .orig x3000\nl halt", "label L already defined at line 2"},
+		{".external a\nldr r0, r0, a", "label A can't be relocated in LDR at line 2"},
+	}
+
+	for i := range testData {
+		_, err := (&Assembler{}).ParseObject("", strings.NewReader(testData[i].code))
+		if err == nil {
+			t.Errorf("%d: expected error %q", i, testData[i].error)
+			continue
+		}
+		if !strings.Contains(err.Error(), testData[i].error) {
+			t.Errorf("%d: expected error %q, got %q", i, testData[i].error, err.Error())
+		}
+	}
+
+	_, err := ParseAssembly(strings.NewReader(".external a\njsr a"))
+	if err == nil || !strings.Contains(err.Error(), "external label A can't be resolved without linking") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_Obj(t *testing.T) {
+	program, err := (&Assembler{}).Assemble("", strings.NewReader(`
+			.orig x3000
+			start	lea r0, data
+					halt
+			.orig x3004
+			data	.fill start`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	if err := program.WriteObj(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{0x30, 0x00, 0xE0, 0x03, 0xF0, 0x25, 0, 0, 0, 0, 0x30, 0x00}
+	if !bytes.Equal(buffer.Bytes(), expected) {
+		t.Fatalf("expected % X, got % X", expected, buffer.Bytes())
+	}
+
+	var hex, bin bytes.Buffer
+	if err := program.WriteHex(&hex); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "3000\nE003\nF025\n0000\n0000\n3000\n"; hex.String() != expected {
+		t.Errorf("expected hex\n%s\ngot\n%s", expected, hex.String())
+	}
+	if err := program.WriteBin(&bin); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(bin.String(), "\n"); len(lines) != 7 || lines[1] != "1110000000000011" {
+		t.Errorf("unexpected bin\n%s", bin.String())
+	}
+
+	read, err := ReadObj(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Entry != 0x3000 || len(read.Sections) != 1 || len(read.Sections[0].Words) != 5 {
+		t.Errorf("unexpected program %+v", read)
+	}
+}
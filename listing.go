@@ -0,0 +1,77 @@
+package lc3
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteSymbols writes symbol table in the format of the classic lc3as .sym files
+func (p *Program) WriteSymbols(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+
+	fmt.Fprintf(w, "// Symbol table\n")
+	fmt.Fprintf(w, "// Scope level 0:\n")
+	fmt.Fprintf(w, "//\tSymbol Name       Page Address\n")
+	fmt.Fprintf(w, "//\t----------------  ------------\n")
+	for _, symbol := range p.Symbols {
+		fmt.Fprintf(w, "//\t%-16s  %04X\n", symbol.Name, symbol.Address)
+	}
+	fmt.Fprintf(w, "\n")
+
+	return w.Flush()
+}
+
+// WriteListing writes every word of the program next to the source line it was assembled from
+func (p *Program) WriteListing(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+
+	fmt.Fprintf(w, "%-6s %-4s %16s  %5s  %s\n", "Addr", "Hex", "Binary", "Line", "Source")
+	for _, line := range p.Lines {
+		for i := Word(0); i < line.Size; i++ {
+			address := line.Address + i
+			word := p.WordAt(address)
+			if i == 0 {
+				fmt.Fprintf(w, "x%04X  %04X %016b  %5d  %s\n", address, word, word, line.Line, line.Text)
+			} else {
+				fmt.Fprintf(w, "x%04X  %04X %016b\n", address, word, word)
+			}
+		}
+	}
+
+	return w.Flush()
+}
+
+// WriteHex writes program in the text format of lc3convert: origin followed by words, four hex digits per line.
+// gaps between sections are filled with zeros
+func (p *Program) WriteHex(writer io.Writer) error {
+	return p.writeText(writer, "%04X\n")
+}
+
+// WriteBin writes program in the text format of lc3convert: origin followed by words, sixteen binary digits per line.
+// gaps between sections are filled with zeros
+func (p *Program) WriteBin(writer io.Writer) error {
+	return p.writeText(writer, "%016b\n")
+}
+
+func (p *Program) writeText(writer io.Writer, format string) error {
+	w := bufio.NewWriter(writer)
+
+	origin, image := p.image()
+	fmt.Fprintf(w, format, origin)
+	for _, word := range image {
+		fmt.Fprintf(w, format, word)
+	}
+
+	return w.Flush()
+}
+
+// WordAt returns word at address or zero if address is not a part of the program
+func (p *Program) WordAt(address Word) Word {
+	for _, section := range p.Sections {
+		if address >= section.Origin && int(address-section.Origin) < len(section.Words) {
+			return section.Words[address-section.Origin]
+		}
+	}
+	return 0
+}
@@ -0,0 +1,152 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// document open in the editor and results of its analysis
+type document struct {
+	uri  string
+	path string
+	text string
+
+	// syntax tree. nil if the document can't be parsed
+	file       *lc3.File
+	references []lc3.LabelReference
+	// assembled program. nil if there are errors
+	program *lc3.Program
+	// error of parsing or assembling
+	err error
+}
+
+// path of file URI. other URIs are used as is
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.Clean(filepath.FromSlash(u.Path))
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// parse and assemble the document
+func (d *document) analyze(assembler *lc3.Assembler) {
+	d.file, d.references, d.program = nil, nil, nil
+
+	d.file, d.err = assembler.ParseSource(d.path, strings.NewReader(d.text))
+	if d.err != nil {
+		return
+	}
+	d.references = d.file.LabelReferences()
+	d.program, d.err = assembler.Assemble(d.path, strings.NewReader(d.text))
+}
+
+// line of the document an error refers to. errors in included files and macros are reported
+// at the last position in the document, usually a macro call
+func (d *document) errorLine(err error) int {
+	var e *lc3.Error
+	if !errors.As(err, &e) {
+		return 0
+	}
+	line := 0
+	positions := []lc3.Position{e.Pos}
+	for _, call := range e.Calls {
+		positions = append(positions, call.Pos)
+	}
+	for _, pos := range positions {
+		if pos.File != "" && filepath.Clean(pos.File) != d.path || pos.Line <= 0 {
+			continue
+		}
+		line = pos.Line - 1
+	}
+	return line
+}
+
+func (d *document) diagnostics() []Diagnostic {
+	ret := []Diagnostic{}
+	if d.err != nil {
+		ret = append(ret, Diagnostic{
+			Range:    lineRange(d.errorLine(d.err), d.text),
+			Severity: SeverityError,
+			Source:   "lc3",
+			Message:  d.err.Error(),
+		})
+	}
+	return ret
+}
+
+// convert position of syntax tree to LSP range of length characters
+func toRange(pos lc3.Position, length int) Range {
+	start := Position{pos.Line - 1, pos.Column - 1}
+	return Range{start, Position{start.Line, start.Character + length}}
+}
+
+// label defined or referenced at position
+func (d *document) referenceAt(pos Position) (lc3.LabelReference, bool) {
+	for _, ref := range d.references {
+		r := toRange(ref.Pos, len(ref.Text))
+		if r.Start.Line == pos.Line && r.Start.Character <= pos.Character && pos.Character <= r.End.Character {
+			return ref, true
+		}
+	}
+	return lc3.LabelReference{}, false
+}
+
+// line of the syntax tree at zero based line number
+func (d *document) line(number int) *lc3.Line {
+	if d.file == nil || number < 0 || number >= len(d.file.Lines) {
+		return nil
+	}
+	return &d.file.Lines[number]
+}
+
+// global label which scope contains zero based line number
+func (d *document) scope(line int) string {
+	scope := ""
+	for _, ref := range d.references {
+		if ref.Pos.Line-1 > line {
+			break
+		}
+		if ref.Definition && !strings.HasPrefix(ref.Text, ".") && !strings.Contains(ref.Name, "@") {
+			scope = ref.Name
+		}
+	}
+	return scope
+}
+
+// words assembled from zero based line and their encoding
+func (d *document) assembled(line int) string {
+	if d.program == nil {
+		return ""
+	}
+
+	var ret strings.Builder
+	for _, source := range d.program.Lines {
+		if filepath.Clean(source.File) != d.path || source.Line != line+1 {
+			continue
+		}
+		for i := lc3.Word(0); i < source.Size; i++ {
+			address := source.Address + i
+			word := d.program.WordAt(address)
+			fmt.Fprintf(&ret, "x%04X: x%04X", address, word)
+			if source.Code {
+				fmt.Fprintf(&ret, "  %s", lc3.EncodeInstructionAt(address, word))
+				instruction := lc3.DecodeInstruction(word)
+				if target, ok := instruction.Target(address); ok {
+					fmt.Fprintf(&ret, "  ; offset #%d", int16(target-address-1))
+				}
+			}
+			ret.WriteString("\n")
+		}
+	}
+	return ret.String()
+}
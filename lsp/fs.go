@@ -0,0 +1,41 @@
+package lsp
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// overlay reads files from the operating system, but documents open in the editor are read from memory,
+// so unsaved changes of included files are assembled too
+type overlay struct {
+	documents map[string]*document
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	for _, doc := range o.documents {
+		if doc.path == filepath.Clean(name) {
+			return &memFile{Reader: strings.NewReader(doc.text), name: filepath.Base(name), size: int64(len(doc.text))}, nil
+		}
+	}
+	return os.Open(name)
+}
+
+type memFile struct {
+	*strings.Reader
+	name string
+	size int64
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *memFile) Close() error               { return nil }
+
+// fs.FileInfo
+func (f *memFile) Name() string       { return f.name }
+func (f *memFile) Size() int64        { return f.size }
+func (f *memFile) Mode() fs.FileMode  { return 0444 }
+func (f *memFile) ModTime() time.Time { return time.Time{} }
+func (f *memFile) IsDir() bool        { return false }
+func (f *memFile) Sys() interface{}   { return nil }
@@ -0,0 +1,491 @@
+// Package lsp implements Language Server Protocol for LC-3 assembly.
+//
+// The server supports diagnostics, go to definition, references, hover with assembled words,
+// completion, rename of labels and document formatting. Documents are synchronized in full.
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// Server talks LSP over a pair of streams, usually stdin and stdout
+type Server struct {
+	reader *bufio.Reader
+	writer io.Writer
+
+	assembler *lc3.Assembler
+	// open documents by URI
+	documents map[string]*document
+	// shutdown request was received
+	shutdown bool
+}
+
+// NewServer creates server reading requests from reader and writing responses to writer
+func NewServer(reader io.Reader, writer io.Writer) *Server {
+	s := &Server{
+		reader:    bufio.NewReader(reader),
+		writer:    writer,
+		documents: make(map[string]*document),
+	}
+	s.assembler = &lc3.Assembler{FS: overlay{s.documents}}
+	return s
+}
+
+// Run serves requests until exit notification or the end of input
+func (s *Server) Run() error {
+	for {
+		body, err := readMessage(s.reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.reply(nil, nil, &responseError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(req)
+		// notifications are not answered
+		if req.ID == nil {
+			continue
+		}
+		var replyErr *responseError
+		if err != nil {
+			var ok bool
+			if replyErr, ok = err.(*responseError); !ok {
+				replyErr = &responseError{codeRequestFailed, err.Error()}
+			}
+		}
+		if err := s.reply(req.ID, result, replyErr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, err *responseError) error {
+	return writeMessage(s.writer, response{JSONRPC: "2.0", ID: id, Result: result, Error: err})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return writeMessage(s.writer, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(req request) (result interface{}, err error) {
+	// a bug hit by one request or document must not stop the session
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, &responseError{codeInternalError, fmt.Sprintf("%s failed: %v", req.Method, r)}
+		}
+	}()
+
+	params := func(v interface{}) error {
+		if err := json.Unmarshal(req.Params, v); err != nil {
+			return &responseError{codeInvalidParams, err.Error()}
+		}
+		return nil
+	}
+
+	switch req.Method {
+	case "initialize":
+		var p InitializeParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		s.assembler.IncludePaths = p.InitializationOptions.IncludePaths
+		return s.initialize(), nil
+
+	case "initialized":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		doc := &document{uri: p.TextDocument.URI, path: uriToPath(p.TextDocument.URI), text: p.TextDocument.Text}
+		s.documents[doc.uri] = doc
+		return nil, s.analyze()
+
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		doc, ok := s.documents[p.TextDocument.URI]
+		if !ok || len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		doc.text = p.ContentChanges[len(p.ContentChanges)-1].Text
+		return nil, s.analyze()
+
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		delete(s.documents, p.TextDocument.URI)
+		if err := s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{p.TextDocument.URI, []Diagnostic{}}); err != nil {
+			return nil, err
+		}
+		return nil, s.analyze()
+
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		return s.definition(p)
+
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		return s.references(p)
+
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		return s.hover(p)
+
+	case "textDocument/completion":
+		var p TextDocumentPositionParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		return s.completion(p)
+
+	case "textDocument/rename":
+		var p RenameParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		return s.rename(p)
+
+	case "textDocument/formatting":
+		var p DocumentFormattingParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		return s.format(p)
+	}
+
+	if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+		return nil, nil
+	}
+	return nil, &responseError{codeMethodNotFound, fmt.Sprintf("method %s is not supported", req.Method)}
+}
+
+func (s *Server) initialize() initializeResult {
+	var ret initializeResult
+	ret.ServerInfo.Name = "lc3-lsp"
+	ret.Capabilities = serverCapabilities{
+		TextDocumentSync:           1,
+		DefinitionProvider:         true,
+		ReferencesProvider:         true,
+		HoverProvider:              true,
+		CompletionProvider:         map[string]interface{}{"triggerCharacters": []string{"."}},
+		RenameProvider:             true,
+		DocumentFormattingProvider: true,
+	}
+	return ret
+}
+
+// analyze all open documents and publish their diagnostics.
+// a change of one document can break others including it
+func (s *Server) analyze() error {
+	var uris []string
+	for uri := range s.documents {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	for _, uri := range uris {
+		doc := s.documents[uri]
+		doc.analyze(s.assembler)
+		if err := s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{doc.uri, doc.diagnostics()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{codeInvalidParams, fmt.Sprintf("document %s is not open", uri)}
+	}
+	return doc, nil
+}
+
+func (s *Server) definition(p TextDocumentPositionParams) (interface{}, error) {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	ref, ok := doc.referenceAt(p.Position)
+	if !ok {
+		return nil, nil
+	}
+
+	for _, r := range doc.references {
+		if r.Definition && r.Name == ref.Name {
+			return []Location{{doc.uri, toRange(r.Pos, len(r.Text))}}, nil
+		}
+	}
+
+	// global labels can be defined in included files
+	visited := map[string]bool{doc.path: true}
+	return s.includedDefinition(doc.file, doc.path, ref.Name, visited), nil
+}
+
+// find definition of global label in files included by file, recursively
+func (s *Server) includedDefinition(file *lc3.File, path string, name string, visited map[string]bool) []Location {
+	if file == nil {
+		return nil
+	}
+	for i := range file.Lines {
+		line := &file.Lines[i]
+		if line.Opcode != ".INCLUDE" || len(line.Operands) != 1 {
+			continue
+		}
+		include, ok := line.Operands[0].StringValue()
+		if !ok {
+			continue
+		}
+		for _, dir := range append([]string{filepath.Dir(path)}, s.assembler.IncludePaths...) {
+			candidate := include
+			if !filepath.IsAbs(candidate) {
+				candidate = filepath.Join(dir, include)
+			}
+			if visited[candidate] {
+				continue
+			}
+			source, err := s.assembler.FS.Open(candidate)
+			if err != nil {
+				continue
+			}
+			visited[candidate] = true
+			included, err := s.assembler.ParseSource(candidate, source)
+			source.Close()
+			if err != nil {
+				break
+			}
+			for _, r := range included.LabelReferences() {
+				if r.Definition && r.Name == name {
+					return []Location{{pathToURI(candidate), toRange(r.Pos, len(r.Text))}}
+				}
+			}
+			if ret := s.includedDefinition(included, candidate, name, visited); ret != nil {
+				return ret
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (s *Server) references(p ReferenceParams) (interface{}, error) {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	ref, ok := doc.referenceAt(p.Position)
+	if !ok {
+		return nil, nil
+	}
+
+	ret := []Location{}
+	for _, r := range doc.references {
+		if r.Name == ref.Name && (!r.Definition || p.Context.IncludeDeclaration) {
+			ret = append(ret, Location{doc.uri, toRange(r.Pos, len(r.Text))})
+		}
+	}
+	return ret, nil
+}
+
+func (s *Server) hover(p TextDocumentPositionParams) (interface{}, error) {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	var hoverRange *Range
+
+	if ref, ok := doc.referenceAt(p.Position); ok {
+		r := toRange(ref.Pos, len(ref.Text))
+		hoverRange = &r
+		if doc.program != nil {
+			if address, ok := doc.program.Symbol(ref.Name); ok {
+				fmt.Fprintf(&text, "%s = x%04X\n", ref.Name, address)
+			}
+		}
+	}
+	text.WriteString(doc.assembled(p.Position.Line))
+
+	if text.Len() == 0 {
+		return nil, nil
+	}
+	return Hover{Contents: MarkupContent{"markdown", "```lc3\n" + text.String() + "```"}, Range: hoverRange}, nil
+}
+
+func (s *Server) completion(p TextDocumentPositionParams) (interface{}, error) {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []CompletionItem{}
+	for _, mnemonic := range lc3.Mnemonics() {
+		ret = append(ret, CompletionItem{Label: mnemonic, Kind: CompletionKeyword})
+	}
+	for i := 0; i < 8; i++ {
+		ret = append(ret, CompletionItem{Label: fmt.Sprintf("R%d", i), Kind: CompletionVariable})
+	}
+
+	scope := doc.scope(p.Position.Line)
+	seen := make(map[string]bool)
+	for _, ref := range doc.references {
+		if !ref.Definition || strings.Contains(ref.Name, "@") {
+			continue
+		}
+		label := ref.Name
+		if strings.HasPrefix(ref.Text, ".") {
+			// local labels of the current scope are completed in the short form
+			if !strings.HasPrefix(ref.Name, scope+".") {
+				continue
+			}
+			label = ref.Name[len(scope):]
+		}
+		if !seen[label] {
+			seen[label] = true
+			ret = append(ret, CompletionItem{Label: label, Kind: CompletionReference, Detail: "label"})
+		}
+	}
+
+	if doc.file != nil {
+		for i := range doc.file.Lines {
+			line := &doc.file.Lines[i]
+			if line.Opcode != ".MACRO" || len(line.Operands) == 0 {
+				continue
+			}
+			if name, ok := line.Operands[0].Label(); ok && !seen[name] {
+				seen[name] = true
+				ret = append(ret, CompletionItem{Label: name, Kind: CompletionFunction, Detail: "macro"})
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *Server) rename(p RenameParams) (interface{}, error) {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	ref, ok := doc.referenceAt(p.Position)
+	if !ok {
+		return nil, &responseError{codeRequestFailed, "no label at the position"}
+	}
+	if strings.HasPrefix(ref.Name, "@@") {
+		return nil, &responseError{codeRequestFailed, "anonymous labels can't be renamed"}
+	}
+
+	newName := strings.TrimSpace(p.NewName)
+	if newName == "" || strings.ContainsAny(newName, " \t,;\"@") {
+		return nil, &responseError{codeRequestFailed, fmt.Sprintf("invalid label name %q", p.NewName)}
+	}
+
+	var definition *lc3.LabelReference
+	for i := range doc.references {
+		if doc.references[i].Definition && doc.references[i].Name == ref.Name {
+			definition = &doc.references[i]
+		}
+	}
+	if definition == nil {
+		return nil, &responseError{codeRequestFailed, fmt.Sprintf("label %s is not defined in this document", ref.Name)}
+	}
+
+	var edits []TextEdit
+	edit := func(r lc3.LabelReference, text string) {
+		edits = append(edits, TextEdit{toRange(r.Pos, len(r.Text)), text})
+	}
+
+	local := strings.HasPrefix(definition.Text, ".")
+	if local {
+		// local labels keep their scope. references qualified by the scope keep it
+		if !strings.HasPrefix(newName, ".") {
+			newName = "." + newName
+		}
+		for _, r := range doc.references {
+			if r.Name != ref.Name {
+				continue
+			}
+			if strings.HasPrefix(r.Text, ".") {
+				edit(r, newName)
+			} else {
+				edit(r, r.Text[:len(r.Text)-len(definition.Text)]+newName)
+			}
+		}
+	} else {
+		// local labels of a global label are qualified by it
+		for _, r := range doc.references {
+			switch {
+			case r.Name == ref.Name:
+				edit(r, newName)
+			case strings.HasPrefix(r.Name, ref.Name+".") && !strings.HasPrefix(r.Text, "."):
+				edit(r, newName+r.Text[len(ref.Name):])
+			}
+		}
+	}
+
+	return WorkspaceEdit{Changes: map[string][]TextEdit{doc.uri: edits}}, nil
+}
+
+func (s *Server) format(p DocumentFormattingParams) (interface{}, error) {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if doc.file == nil {
+		return nil, &responseError{codeRequestFailed, "document can't be parsed"}
+	}
+
+	var formatted bytes.Buffer
+	if err := lc3.FormatSource(&formatted, doc.file, lc3.Formatter{UpperHex: true, Source: true, SourceBase: true}); err != nil {
+		return nil, err
+	}
+	if formatted.String() == doc.text {
+		return []TextEdit{}, nil
+	}
+
+	end := Position{Line: strings.Count(doc.text, "\n") + 1, Character: 0}
+	return []TextEdit{{Range{Position{0, 0}, end}, formatted.String()}}, nil
+}
@@ -0,0 +1,220 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+const testURI = "file:///work/main.asm"
+
+const testDocument = `	.orig x3000
+main	ld r1, count
+.loop	add r1, r1, #-1
+	brp .loop
+	brnzp main.loop
+	halt
+count	.fill #3
+	.end
+`
+
+// send requests to a server and collect its output by request id. notifications are collected by method
+func runServer(t *testing.T, requests ...interface{}) (map[int]json.RawMessage, map[string][]json.RawMessage) {
+	var input bytes.Buffer
+	for _, req := range requests {
+		if err := writeMessage(&input, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var output bytes.Buffer
+	if err := NewServer(&input, &output).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	responses := make(map[int]json.RawMessage)
+	notifications := make(map[string][]json.RawMessage)
+	reader := bufio.NewReader(&output)
+	for {
+		body, err := readMessage(reader)
+		if err != nil {
+			break
+		}
+		var message struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  *responseError  `json:"error"`
+		}
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.ID == nil {
+			notifications[message.Method] = append(notifications[message.Method], message.Params)
+			continue
+		}
+		if message.Error != nil {
+			responses[*message.ID] = json.RawMessage(`{"error":"` + message.Error.Message + `"}`)
+			continue
+		}
+		responses[*message.ID] = message.Result
+	}
+	return responses, notifications
+}
+
+func call(id int, method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func notify(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+func open(text string) map[string]interface{} {
+	return notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocumentItem{URI: testURI, LanguageID: "lc3", Text: text}})
+}
+
+func at(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocumentIdentifier{testURI}, Position{line, character}}
+}
+
+func Test_Server(t *testing.T) {
+	references := ReferenceParams{TextDocumentPositionParams: at(2, 2)}
+	references.Context.IncludeDeclaration = true
+
+	responses, notifications := runServer(t,
+		call(1, "initialize", map[string]interface{}{}),
+		open(testDocument),
+		call(2, "textDocument/definition", at(3, 6)),
+		call(3, "textDocument/references", references),
+		call(4, "textDocument/hover", at(1, 13)),
+		call(5, "textDocument/completion", at(4, 1)),
+		call(6, "textDocument/rename", RenameParams{at(3, 6), "again"}),
+		call(7, "textDocument/rename", RenameParams{at(1, 1), "start"}),
+		call(8, "textDocument/formatting", DocumentFormattingParams{TextDocumentIdentifier{testURI}}),
+		call(9, "shutdown", nil),
+		notify("exit", nil),
+	)
+
+	expected := map[int]string{
+		2: `[{"uri":"file:///work/main.asm","range":{"start":{"line":2,"character":0},"end":{"line":2,"character":5}}}]`,
+		3: `[{"uri":"file:///work/main.asm","range":{"start":{"line":2,"character":0},"end":{"line":2,"character":5}}},` +
+			`{"uri":"file:///work/main.asm","range":{"start":{"line":3,"character":5},"end":{"line":3,"character":10}}},` +
+			`{"uri":"file:///work/main.asm","range":{"start":{"line":4,"character":7},"end":{"line":4,"character":16}}}]`,
+		4: `{"contents":{"kind":"markdown","value":"` + "```lc3\\nCOUNT = x3005\\nx3000: x2204  LD R1, x3005  ; offset #4\\n```" + `"},` +
+			`"range":{"start":{"line":1,"character":12},"end":{"line":1,"character":17}}}`,
+		6: `{"changes":{"file:///work/main.asm":[` +
+			`{"range":{"start":{"line":2,"character":0},"end":{"line":2,"character":5}},"newText":".again"},` +
+			`{"range":{"start":{"line":3,"character":5},"end":{"line":3,"character":10}},"newText":".again"},` +
+			`{"range":{"start":{"line":4,"character":7},"end":{"line":4,"character":16}},"newText":"main.again"}]}}`,
+		7: `{"changes":{"file:///work/main.asm":[` +
+			`{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":4}},"newText":"start"},` +
+			`{"range":{"start":{"line":4,"character":7},"end":{"line":4,"character":16}},"newText":"start.loop"}]}}`,
+		9: `null`,
+	}
+	for id, e := range expected {
+		if string(responses[id]) != e {
+			t.Errorf("%d: expected\n%s\ngot\n%s", id, e, responses[id])
+		}
+	}
+
+	var completion []CompletionItem
+	if err := json.Unmarshal(responses[5], &completion); err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]bool)
+	for _, item := range completion {
+		labels[item.Label] = true
+	}
+	for _, label := range []string{"ADD", "R7", "MAIN", ".LOOP", "COUNT"} {
+		if !labels[label] {
+			t.Errorf("%s is not completed", label)
+		}
+	}
+
+	var edits []TextEdit
+	if err := json.Unmarshal(responses[8], &edits); err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || !strings.Contains(edits[0].NewText, "main    LD    R1, count\n") {
+		t.Errorf("unexpected formatting %+v", edits)
+	}
+
+	var diagnostics PublishDiagnosticsParams
+	if err := json.Unmarshal(notifications["textDocument/publishDiagnostics"][0], &diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics %+v", diagnostics.Diagnostics)
+	}
+}
+
+func Test_ServerDiagnostics(t *testing.T) {
+	_, notifications := runServer(t,
+		call(1, "initialize", map[string]interface{}{}),
+		open(".orig x3000\nadd r1, r1, #1\nbrnzp missing\n.end\n"),
+	)
+
+	var diagnostics PublishDiagnosticsParams
+	if err := json.Unmarshal(notifications["textDocument/publishDiagnostics"][0], &diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics.Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %+v", diagnostics.Diagnostics)
+	}
+	d := diagnostics.Diagnostics[0]
+	if d.Range.Start.Line != 2 || d.Range.End.Character != 13 || !strings.Contains(d.Message, "unknown label MISSING") {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+}
+
+// half typed operands are syntax errors, the server keeps serving the document
+func Test_ServerPartialOperands(t *testing.T) {
+	responses, notifications := runServer(t,
+		call(1, "initialize", map[string]interface{}{}),
+		open(".orig x3000\nadd r0, r0, #\nld r0, x\n.end\n"),
+		call(2, "textDocument/hover", at(1, 1)),
+	)
+
+	var diagnostics PublishDiagnosticsParams
+	if err := json.Unmarshal(notifications["textDocument/publishDiagnostics"][0], &diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics.Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %+v", diagnostics.Diagnostics)
+	}
+	if d := diagnostics.Diagnostics[0]; d.Range.Start.Line != 1 || !strings.Contains(d.Message, "digits expected in #") {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+	if _, ok := responses[2]; !ok {
+		t.Errorf("no response to hover after partial operands")
+	}
+}
+
+func Test_ErrorLine(t *testing.T) {
+	d := &document{path: "/work dir/main.asm"}
+	type testCase struct {
+		err  error
+		line int
+	}
+	testData := []testCase{
+		{&lc3.Error{Pos: lc3.Position{File: "/work dir/main.asm", Line: 3}, Msg: "label AT at"}, 2},
+		{&lc3.Error{
+			Pos:   lc3.Position{File: "/work dir/lib.asm", Line: 2},
+			Calls: []lc3.MacroCall{{Macro: "M", Pos: lc3.Position{File: "/work dir/main.asm", Line: 5}}},
+		}, 4},
+		{&lc3.Error{Pos: lc3.Position{File: "/work dir/lib.asm", Line: 2}}, 0},
+		{errors.New("bad at main.asm:7"), 0},
+	}
+	for i, test := range testData {
+		if line := d.errorLine(test.err); line != test.line {
+			t.Errorf("%d: expected line %d, got %d", i, test.line, line)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package lc3
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// maximum depth of nested macro calls. deeper expansion usually means a macro calls itself
+const maxMacroDepth = 16
+
+// macro definition: ".MACRO NAME PARAM1, PARAM2", body lines, ".ENDM"
+type macro struct {
+	name   string
+	params []string
+	body   []Line
+}
+
+type macroExpander struct {
+	macros map[string]*macro
+	// number of expansions made so far. used to make labels of every expansion unique
+	expansions int
+	// lines produced by expansions and their limit. zero maxLines means no limit
+	lines    int
+	maxLines int
+}
+
+// replace macro definitions and calls with plain lines
+func expandMacros(lines []Line, maxLines int) ([]Line, error) {
+	e := &macroExpander{macros: make(map[string]*macro), maxLines: maxLines}
+
+	lines, err := e.collectDefinitions(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.expand(lines, 0)
+}
+
+// cut macro definitions out of lines and save them
+func (e *macroExpander) collectDefinitions(lines []Line) ([]Line, error) {
+	var ret []Line
+	var current *macro
+
+	for _, line := range lines {
+		switch line.Opcode {
+		case stropMacro:
+			if current != nil {
+				return nil, line.pos.errorf("nested macro definition")
+			}
+			if line.Label != "" {
+				return nil, line.pos.errorf("label is not allowed before .MACRO")
+			}
+			def, err := newMacro(line)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := e.macros[def.name]; ok {
+				return nil, line.pos.errorf("macro %s redefined", def.name)
+			}
+			current = def
+			continue
+		case stropEndm:
+			if current == nil {
+				return nil, line.pos.errorf(".ENDM without .MACRO")
+			}
+			if line.Label != "" || len(line.Operands) > 0 {
+				return nil, line.pos.errorf("unexpected input after .ENDM")
+			}
+			e.macros[current.name] = current
+			current = nil
+			continue
+		case stropEnd:
+			if current != nil {
+				return nil, errors.Errorf("unterminated macro %s", current.name)
+			}
+		}
+
+		if current != nil {
+			current.body = append(current.body, line)
+		} else {
+			ret = append(ret, line)
+		}
+	}
+
+	if current != nil {
+		return nil, errors.Errorf("unterminated macro %s", current.name)
+	}
+
+	return ret, nil
+}
+
+func newMacro(line Line) (*macro, error) {
+	if len(line.Operands) == 0 || !line.Operands[0].isLabel() {
+		return nil, line.pos.errorf("macro name expected")
+	}
+
+	// a macro named as an instruction or a directive would replace it everywhere
+	if isOpcode(*line.Operands[0].label) {
+		return nil, line.pos.errorf("macro name %s is an instruction or a directive", *line.Operands[0].label)
+	}
+
+	ret := &macro{name: *line.Operands[0].label}
+	for _, operand := range line.Operands[1:] {
+		if !operand.isLabel() {
+			return nil, line.pos.errorf("macro parameter name expected, got %s", operand.String())
+		}
+		for _, param := range ret.params {
+			if param == *operand.label {
+				return nil, line.pos.errorf("duplicate macro parameter %s", param)
+			}
+		}
+		ret.params = append(ret.params, *operand.label)
+	}
+
+	return ret, nil
+}
+
+func (e *macroExpander) expand(lines []Line, depth int) ([]Line, error) {
+	var ret []Line
+
+	for _, line := range lines {
+		def, ok := e.macros[line.Opcode]
+		if !ok {
+			ret = append(ret, line)
+			continue
+		}
+
+		if depth >= maxMacroDepth {
+			return nil, line.pos.errorf("macro expansion is too deep")
+		}
+
+		if len(line.Operands) != len(def.params) {
+			return nil, line.pos.errorf("macro %s expects %d arguments, got %d", def.name, len(def.params), len(line.Operands))
+		}
+
+		// label and comment of the call line stay on their own line,
+		// so the label points to the first word of the expansion
+		if line.Label != "" || line.Comment != "" {
+			ret = append(ret, Line{Label: line.Label, Comment: line.Comment, pos: line.pos})
+		}
+
+		body, err := e.instantiate(def, line)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err = e.expand(body, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, body...)
+	}
+
+	return ret, nil
+}
+
+// make a copy of macro body with parameters replaced by call arguments
+// and labels renamed to be unique for this call
+func (e *macroExpander) instantiate(def *macro, call Line) ([]Line, error) {
+	e.expansions++
+	// nested macros calling each other several times expand exponentially
+	if e.lines += len(def.body); e.maxLines > 0 && e.lines > e.maxLines {
+		return nil, call.pos.errorf("macro expansion exceeds %d lines", e.maxLines)
+	}
+
+	args := make(map[string]Operand)
+	for i, param := range def.params {
+		args[param] = call.Operands[i]
+	}
+
+	locals := make(map[string]string)
+	for _, line := range def.body {
+		// anonymous labels are resolved by their order after expansion
+		if line.Label != "" && line.Label != anonymousLabel {
+			locals[line.Label] = fmt.Sprintf("%s@%s.%d", line.Label, def.name, e.expansions)
+		}
+	}
+
+	ret := make([]Line, 0, len(def.body))
+	for _, line := range def.body {
+		if _, ok := args[line.Label]; ok {
+			return nil, line.pos.errorf("macro parameter %s used as a label", line.Label)
+		}
+
+		expanded := Line{
+			Label:   line.Label,
+			Opcode:  line.Opcode,
+			Comment: line.Comment,
+			pos:     line.pos,
+		}
+		expanded.pos.expansion = &expansion{macro: def.name, call: call.pos}
+		if local, ok := locals[line.Label]; ok {
+			expanded.Label = local
+		}
+
+		for _, operand := range line.Operands {
+			if operand.isLabel() {
+				if arg, ok := args[*operand.label]; ok {
+					operand = arg
+				} else if local, ok := locals[*operand.label]; ok {
+					operand = Operand{label: &local}
+				}
+			}
+			expanded.Operands = append(expanded.Operands, operand)
+		}
+
+		ret = append(ret, expanded)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,94 @@
+package lc3_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+	"github.com/pavel-krush/lc3/lc3test"
+)
+
+func Test_Macro(t *testing.T) {
+	lc3test.Cases{
+		// parameters substitution
+		lc3test.New("parameters").Source(`
+			.macro push reg
+					add r6, r6, #-1
+					str reg, r6, #0
+			.endm
+			.macro pop reg
+					ldr reg, r6, #0
+					add r6, r6, #1
+			.endm
+					lea r6, stack
+					add r0, r0, #7
+					push r0
+					pop r1
+					halt
+					.fill #0
+			stack	.fill #0`).
+			ExpectRegister(lc3.RegR1, 7).
+			ExpectRegister(lc3.RegR6, 8).
+			ExpectMemory(7, 7),
+		// local labels are unique for every expansion
+		lc3test.New("local labels").Source(`
+			.macro countdown reg, count
+					add reg, reg, count
+			loop	add reg, reg, #-1
+					brp loop
+			.endm
+					countdown r0, #3
+					countdown r1, #5
+					add r2, r2, #1
+					halt`).
+			ExpectRegister(lc3.RegR0, 0).
+			ExpectRegister(lc3.RegR1, 0).
+			ExpectRegister(lc3.RegR2, 1),
+		// nested expansion and label of the call line
+		lc3test.New("nested expansion").Source(`
+			.macro inc reg
+					add reg, reg, #1
+			.endm
+			.macro inc2 reg
+					inc reg
+					inc reg
+			.endm
+			start	inc2 r3
+					lea r4, start
+					halt`).
+			ExpectRegister(lc3.RegR3, 2).
+			ExpectRegister(lc3.RegR4, 0),
+	}.Test(t)
+}
+
+func Test_MacroErrors(t *testing.T) {
+	type testCase struct {
+		code  string
+		error string
+	}
+
+	testData := []testCase{
+		{".macro m\nadd r0, r0, #1\n", "unterminated macro M"},
+		{".endm\n", ".ENDM without .MACRO at line 1"},
+		{".macro m a\n.endm\nm\n", "macro M expects 1 arguments, got 0 at line 3"},
+		{".macro m\nm\n.endm\nm\n", "macro expansion is too deep at line 2 in macro M, expanded at"},
+		{".macro m\nld r0, nowhere\n.endm\nhalt\nm\n", "unknown label NOWHERE at line 2 in macro M, expanded at line 5"},
+		{".macro a\nld r0, nowhere\n.endm\n.macro b\na\n.endm\nb\n", "at line 2 in macro A, expanded at line 5 in macro B, expanded at line 7"},
+		{".macro m a, a\n.endm\n", "duplicate macro parameter A at line 1"},
+		{".macro m r0\n.endm\n", "macro parameter name expected, got R0 at line 1"},
+		{".macro add a\n.endm\nadd r0\nhalt\n", "macro name ADD is an instruction or a directive at line 1"},
+		{".macro halt\n.endm\n", "macro name HALT is an instruction or a directive at line 1"},
+		{".macro .fill\n.endm\n", "macro name .FILL is an instruction or a directive at line 1"},
+	}
+
+	for i := range testData {
+		_, err := lc3.ParseAssembly(strings.NewReader(testData[i].code))
+		if err == nil {
+			t.Errorf("%d: expected error %q", i, testData[i].error)
+			continue
+		}
+		if !strings.Contains(err.Error(), testData[i].error) {
+			t.Errorf("%d: expected error %q, got %q", i, testData[i].error, err.Error())
+		}
+	}
+}
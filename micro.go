@@ -0,0 +1,387 @@
+package lc3
+
+import "errors"
+
+// Condition tells which signal the microsequencer ors into the next state
+type Condition int
+
+const (
+	CondNone Condition = iota
+	// memory is ready, bit 1
+	CondReady
+	// branch is taken, bit 2
+	CondBranch
+	// JSR with PC-relative address, IR[11] as bit 0
+	CondAddressingMode
+)
+
+// PCMux selects the value loaded into PC
+type PCMux int
+
+const (
+	PCMuxIncrement PCMux = iota
+	PCMuxBus
+	PCMuxAdder
+)
+
+// DRMux selects the destination register
+type DRMux int
+
+const (
+	DRMuxIR11 DRMux = iota
+	DRMuxR7
+)
+
+// SR1Mux selects the first source register, also used as base register
+type SR1Mux int
+
+const (
+	SR1MuxIR11 SR1Mux = iota
+	SR1MuxIR8
+)
+
+// Addr1Mux selects the first operand of the address adder
+type Addr1Mux int
+
+const (
+	Addr1MuxPC Addr1Mux = iota
+	Addr1MuxBase
+)
+
+// Addr2Mux selects the second operand of the address adder
+type Addr2Mux int
+
+const (
+	Addr2MuxZero Addr2Mux = iota
+	Addr2MuxOffset6
+	Addr2MuxOffset9
+	Addr2MuxOffset11
+)
+
+// MARMux selects the value gated by GateMARMUX
+type MARMux int
+
+const (
+	// zero extended IR[7:0], the trap vector
+	MARMuxVector MARMux = iota
+	MARMuxAdder
+)
+
+// ALUK is the operation of the ALU
+type ALUK int
+
+const (
+	ALUAdd ALUK = iota
+	ALUAnd
+	ALUNot
+	ALUPassA
+)
+
+// Microinstruction is a word of the control store: control signals of a state and how the next state is chosen
+type Microinstruction struct {
+	// register transfers of the state, e.g. MAR<-PC, PC<-PC+1. empty for states which aren't defined
+	Text string
+
+	// the next state is IR[15:12]
+	IRD bool
+	// condition ored into J
+	Cond Condition
+	// the next state
+	J int
+
+	LdMAR, LdMDR, LdIR, LdBEN, LdReg, LdCC, LdPC bool
+	GatePC, GateMDR, GateALU, GateMARMUX         bool
+
+	PCMux    PCMux
+	DRMux    DRMux
+	SR1Mux   SR1Mux
+	Addr1Mux Addr1Mux
+	Addr2Mux Addr2Mux
+	MARMux   MARMux
+	ALUK     ALUK
+
+	// memory is accessed
+	MIOEn bool
+	// the access is a write
+	Write bool
+}
+
+// the first state of an instruction and the state reading the instruction
+const (
+	stateFetch       = 18
+	stateFetchMemory = 33
+)
+
+// ControlStore holds microinstructions by state numbers of the textbook state machine of Patt and Patel.
+// interrupts and privilege are not modelled, RTI and the reserved opcode have no states
+var ControlStore = [64]Microinstruction{
+	18: {Text: "MAR<-PC, PC<-PC+1", J: 33, GatePC: true, LdMAR: true, LdPC: true, PCMux: PCMuxIncrement},
+	33: {Text: "MDR<-M", J: 33, Cond: CondReady, MIOEn: true, LdMDR: true},
+	35: {Text: "IR<-MDR", J: 32, GateMDR: true, LdIR: true},
+	32: {Text: "BEN<-IR[11]&N+IR[10]&Z+IR[9]&P, [IR[15:12]]", IRD: true, LdBEN: true},
+
+	1: {Text: "DR<-SR1+OP2, set CC", J: 18, SR1Mux: SR1MuxIR8, ALUK: ALUAdd, GateALU: true, LdReg: true, LdCC: true},
+	5: {Text: "DR<-SR1&OP2, set CC", J: 18, SR1Mux: SR1MuxIR8, ALUK: ALUAnd, GateALU: true, LdReg: true, LdCC: true},
+	9: {Text: "DR<-NOT(SR), set CC", J: 18, SR1Mux: SR1MuxIR8, ALUK: ALUNot, GateALU: true, LdReg: true, LdCC: true},
+	14: {Text: "DR<-PC+off9, set CC", J: 18, Addr1Mux: Addr1MuxPC, Addr2Mux: Addr2MuxOffset9, MARMux: MARMuxAdder,
+		GateMARMUX: true, LdReg: true, LdCC: true},
+
+	2: {Text: "MAR<-PC+off9", J: 25, Addr1Mux: Addr1MuxPC, Addr2Mux: Addr2MuxOffset9, MARMux: MARMuxAdder,
+		GateMARMUX: true, LdMAR: true},
+	6: {Text: "MAR<-B+off6", J: 25, SR1Mux: SR1MuxIR8, Addr1Mux: Addr1MuxBase, Addr2Mux: Addr2MuxOffset6,
+		MARMux: MARMuxAdder, GateMARMUX: true, LdMAR: true},
+	10: {Text: "MAR<-PC+off9", J: 24, Addr1Mux: Addr1MuxPC, Addr2Mux: Addr2MuxOffset9, MARMux: MARMuxAdder,
+		GateMARMUX: true, LdMAR: true},
+	24: {Text: "MDR<-M", J: 24, Cond: CondReady, MIOEn: true, LdMDR: true},
+	26: {Text: "MAR<-MDR", J: 25, GateMDR: true, LdMAR: true},
+	25: {Text: "MDR<-M", J: 25, Cond: CondReady, MIOEn: true, LdMDR: true},
+	27: {Text: "DR<-MDR, set CC", J: 18, GateMDR: true, LdReg: true, LdCC: true},
+
+	3: {Text: "MAR<-PC+off9", J: 23, Addr1Mux: Addr1MuxPC, Addr2Mux: Addr2MuxOffset9, MARMux: MARMuxAdder,
+		GateMARMUX: true, LdMAR: true},
+	7: {Text: "MAR<-B+off6", J: 23, SR1Mux: SR1MuxIR8, Addr1Mux: Addr1MuxBase, Addr2Mux: Addr2MuxOffset6,
+		MARMux: MARMuxAdder, GateMARMUX: true, LdMAR: true},
+	11: {Text: "MAR<-PC+off9", J: 29, Addr1Mux: Addr1MuxPC, Addr2Mux: Addr2MuxOffset9, MARMux: MARMuxAdder,
+		GateMARMUX: true, LdMAR: true},
+	29: {Text: "MDR<-M", J: 29, Cond: CondReady, MIOEn: true, LdMDR: true},
+	31: {Text: "MAR<-MDR", J: 23, GateMDR: true, LdMAR: true},
+	23: {Text: "MDR<-SR", J: 16, SR1Mux: SR1MuxIR11, ALUK: ALUPassA, GateALU: true, LdMDR: true},
+	16: {Text: "M[MAR]<-MDR", J: 16, Cond: CondReady, MIOEn: true, Write: true},
+
+	0:  {Text: "[BEN]", J: 18, Cond: CondBranch},
+	22: {Text: "PC<-PC+off9", J: 18, Addr1Mux: Addr1MuxPC, Addr2Mux: Addr2MuxOffset9, PCMux: PCMuxAdder, LdPC: true},
+	12: {Text: "PC<-BaseR", J: 18, SR1Mux: SR1MuxIR8, Addr1Mux: Addr1MuxBase, Addr2Mux: Addr2MuxZero,
+		PCMux: PCMuxAdder, LdPC: true},
+	4: {Text: "R7<-PC, [IR[11]]", J: 20, Cond: CondAddressingMode, GatePC: true, DRMux: DRMuxR7, LdReg: true},
+	21: {Text: "PC<-PC+off11", J: 18, Addr1Mux: Addr1MuxPC, Addr2Mux: Addr2MuxOffset11, PCMux: PCMuxAdder,
+		LdPC: true},
+	20: {Text: "PC<-BaseR", J: 18, SR1Mux: SR1MuxIR8, Addr1Mux: Addr1MuxBase, Addr2Mux: Addr2MuxZero,
+		PCMux: PCMuxAdder, LdPC: true},
+
+	15: {Text: "MAR<-ZEXT[IR[7:0]]", J: 28, MARMux: MARMuxVector, GateMARMUX: true, LdMAR: true},
+	28: {Text: "MDR<-M, R7<-PC", J: 28, Cond: CondReady, MIOEn: true, LdMDR: true, GatePC: true, DRMux: DRMuxR7,
+		LdReg: true},
+	30: {Text: "PC<-MDR", J: 18, GateMDR: true, PCMux: PCMuxBus, LdPC: true},
+}
+
+// the state serving traps
+const stateTrap = 15
+
+// ErrNoDatapath is returned by Microstep of a VM which doesn't execute instructions by EngineMicro
+var ErrNoDatapath = errors.New("vm has no datapath")
+
+// Datapath is the state of the LC-3 datapath between microcycles. registers and memory are the ones of the VM.
+// memory accesses take a cycle or latencies of the timing model set by SetTiming. built-in traps
+// are served in state 15 in a single cycle
+type Datapath struct {
+	// the next state of the microsequencer
+	State int
+	MAR   Word
+	MDR   Word
+	IR    Word
+	BEN   bool
+	// microcycles executed since reset
+	Cycles uint
+	// cycles spent by the current memory access
+	waited uint
+}
+
+// Microcycle is a clock cycle of the datapath
+type Microcycle struct {
+	State            int
+	Microinstruction Microinstruction
+	// value on the bus. zero if no gate is open
+	Bus Word
+	// outputs of the ALU and the address adder
+	ALU   Word
+	Adder Word
+	// memory is ready, the access is done in this cycle
+	Ready bool
+	// the next state
+	Next int
+}
+
+// Datapath returns state of the datapath. false if the VM doesn't execute instructions by EngineMicro
+func (m *VM) Datapath() (Datapath, bool) {
+	if m.micro == nil {
+		return Datapath{}, false
+	}
+	return *m.micro, true
+}
+
+// Microstep executes a clock cycle of the datapath. Step executes microcycles until the next instruction fetch.
+// errors are the ones of Step, the datapath returns to the fetch of the failed instruction
+func (m *VM) Microstep() (Microcycle, error) {
+	d := m.micro
+	if d == nil {
+		return Microcycle{}, ErrNoDatapath
+	}
+	if d.State == stateFetch && !m.running {
+		return Microcycle{}, ErrNotRunning
+	}
+	if d.State == stateFetch {
+		m.instructionsExecuted++
+	}
+
+	u := &ControlStore[d.State]
+	c := Microcycle{State: d.State, Microinstruction: *u}
+	d.Cycles++
+	ir := d.IR
+
+	sr1 := getNBits(ir, 9, 3)
+	if u.SR1Mux == SR1MuxIR8 {
+		sr1 = getNBits(ir, 6, 3)
+	}
+	a := m.registers[sr1]
+	b := m.registers[getNBits(ir, 0, 3)]
+	if ir&(1<<5) != 0 {
+		b = getNBitsExtended(ir, 0, 5)
+	}
+	switch u.ALUK {
+	case ALUAdd:
+		c.ALU = a + b
+	case ALUAnd:
+		c.ALU = a & b
+	case ALUNot:
+		c.ALU = ^a
+	case ALUPassA:
+		c.ALU = a
+	}
+
+	addr1 := m.registers[RegPC]
+	if u.Addr1Mux == Addr1MuxBase {
+		addr1 = a
+	}
+	var addr2 Word
+	switch u.Addr2Mux {
+	case Addr2MuxOffset6:
+		addr2 = getNBitsExtended(ir, 0, 6)
+	case Addr2MuxOffset9:
+		addr2 = getNBitsExtended(ir, 0, 9)
+	case Addr2MuxOffset11:
+		addr2 = getNBitsExtended(ir, 0, 11)
+	}
+	c.Adder = addr1 + addr2
+	marMux := getNBits(ir, 0, 8)
+	if u.MARMux == MARMuxAdder {
+		marMux = c.Adder
+	}
+
+	switch {
+	case u.GatePC:
+		c.Bus = m.registers[RegPC]
+	case u.GateMDR:
+		c.Bus = d.MDR
+	case u.GateALU:
+		c.Bus = c.ALU
+	case u.GateMARMUX:
+		c.Bus = marMux
+	}
+
+	// memory is read or written in the last cycle of the access
+	var memory Word
+	if u.MIOEn {
+		latency := uint(1)
+		if m.timing != nil {
+			latency = m.timing.latency(d.MAR)
+		}
+		d.waited++
+		if c.Ready = d.waited >= latency; c.Ready {
+			d.waited = 0
+			switch {
+			case u.Write:
+				m.store(d.MAR, d.MDR)
+			case d.State == stateFetchMemory:
+				memory = m.ReadMem(d.MAR)
+			default:
+				memory = m.load(d.MAR)
+			}
+		}
+	}
+
+	if u.LdMAR {
+		d.MAR = c.Bus
+	}
+	if u.LdMDR && (!u.MIOEn || c.Ready) {
+		d.MDR = c.Bus
+		if u.MIOEn {
+			d.MDR = memory
+		}
+	}
+	if u.LdIR {
+		d.IR = c.Bus
+	}
+	if u.LdBEN {
+		d.BEN = getNBits(ir, 9, 3)&m.registers[RegCond] != 0
+	}
+	if u.LdReg {
+		dr := getNBits(ir, 9, 3)
+		if u.DRMux == DRMuxR7 {
+			dr = RegR7
+		}
+		m.registers[dr] = c.Bus
+	}
+	if u.LdCC {
+		m.setFlags(c.Bus)
+	}
+	if u.LdPC {
+		switch u.PCMux {
+		case PCMuxIncrement:
+			m.registers[RegPC]++
+		case PCMuxBus:
+			m.registers[RegPC] = c.Bus
+		case PCMuxAdder:
+			m.registers[RegPC] = c.Adder
+		}
+	}
+
+	// microsequencer
+	c.Next = u.J
+	switch {
+	case u.IRD:
+		c.Next = int(getOpcode(ir))
+	case u.Cond == CondReady && c.Ready:
+		c.Next |= 1 << 1
+	case u.Cond == CondBranch && d.BEN:
+		c.Next |= 1 << 2
+	case u.Cond == CondAddressingMode && ir&(1<<11) != 0:
+		c.Next |= 1
+	}
+	d.State = c.Next
+
+	var err error
+	switch {
+	case ControlStore[c.Next].Text == "":
+		// RTI and the reserved opcode
+		m.retry()
+		err = ErrBadInstruction
+	case c.State == stateTrap && !m.SystemTraps && isStandardTrap(d.MAR):
+		// the built-in routine is executed instead of the routine of the trap vector table
+		err = m.trap(d.MAR)
+	case u.Write && c.Ready:
+		err = m.complete()
+	default:
+		return c, nil
+	}
+	// the instruction is done. the datapath continues with the next fetch
+	c.Next = stateFetch
+	d.State = stateFetch
+	return c, err
+}
+
+// execute microcycles of the instruction at PC
+func (m *VM) stepMicro() error {
+	for {
+		if _, err := m.Microstep(); err != nil {
+			return err
+		}
+		if m.micro.State == stateFetch {
+			return nil
+		}
+	}
+}
+
+// checks if vector is served by a built-in routine without SystemTraps
+func isStandardTrap(vector Word) bool {
+	return vector >= TrapVectGetc && vector <= TrapVectHalt
+}
@@ -0,0 +1,125 @@
+package lc3
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Microstep(t *testing.T) {
+	program, err := (&Assembler{}).Assemble("main.asm", strings.NewReader(`
+			.orig x3000
+					ldi r0, pointer
+					sti r0, pointer
+					brz skip
+			skip	lea r1, sub
+					jsrr r1
+					trap x30
+			sub		ret
+			pointer	.fill data
+			data	.fill x1234
+			.end`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		states []int
+		// value on the bus in the last cycle
+		bus Word
+	}
+
+	testData := []testCase{
+		{[]int{18, 33, 35, 32, 10, 24, 26, 25, 27}, 0x1234},
+		{[]int{18, 33, 35, 32, 11, 29, 31, 23, 16}, 0},
+		{[]int{18, 33, 35, 32, 0}, 0},
+		{[]int{18, 33, 35, 32, 14}, 0x3006},
+		{[]int{18, 33, 35, 32, 4, 20}, 0},
+		{[]int{18, 33, 35, 32, 12}, 0},
+		{[]int{18, 33, 35, 32, 15, 28, 30}, 0x3005},
+	}
+
+	m := program.NewVMWithEngine(EngineMicro)
+	m.SystemTraps = true
+	m.WriteMem(0x30, 0x3005)
+	m.Start()
+	for i, test := range testData {
+		var states []int
+		var cycle Microcycle
+		for {
+			if cycle, err = m.Microstep(); err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+			states = append(states, cycle.State)
+			if cycle.Next == stateFetch {
+				break
+			}
+		}
+		if !reflect.DeepEqual(states, test.states) {
+			t.Errorf("%d: expected states %v, got %v", i, test.states, states)
+		}
+		if cycle.Bus != test.bus {
+			t.Errorf("%d: expected x%04X on the bus, got x%04X", i, test.bus, cycle.Bus)
+		}
+	}
+
+	d, _ := m.Datapath()
+	if d.IR != 0xF030 || d.MAR != 0x0030 || d.MDR != 0x3005 {
+		t.Errorf("expected IR xF030, MAR x0030, MDR x3005 after TRAP, got x%04X, x%04X, x%04X", d.IR, d.MAR, d.MDR)
+	}
+	if m.GetRegister(RegPC) != 0x3005 || m.GetRegister(RegR7) != 0x3006 {
+		t.Errorf("expected PC x3005 and R7 x3006, got x%04X and x%04X", m.GetRegister(RegPC), m.GetRegister(RegR7))
+	}
+	if d.Cycles != 9+9+5+5+6+5+7 {
+		t.Errorf("expected %d cycles, got %d", 9+9+5+5+6+5+7, d.Cycles)
+	}
+
+	if _, err := program.NewVM().Microstep(); err != ErrNoDatapath {
+		t.Errorf("expected %v without datapath, got %v", ErrNoDatapath, err)
+	}
+}
+
+// microcycles with memory latencies are cycles of the timing model
+func Test_MicroTiming(t *testing.T) {
+	codes := []string{`
+			.orig x3000
+			poll	ldi r0, kbsr
+					brzp poll
+					ldi r0, kbdr
+					jsr echo
+					add r1, r0, #-10
+					brnp poll
+					and r0, r0, #0
+					sti r0, mcr
+			echo	sti r0, ddr
+					ret
+			kbsr	.fill xfe00
+			kbdr	.fill xfe02
+			ddr		.fill xfe06
+			mcr		.fill xfffe
+			.end`}
+	for _, p := range benchmarkPrograms {
+		codes = append(codes, p.code)
+	}
+
+	for i, code := range codes {
+		program, err := (&Assembler{}).Assemble("main.asm", strings.NewReader(code))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		m := program.NewVMWithEngine(EngineMicro)
+		m.SetTiming(&Timing{MemoryLatency: 4, DeviceLatency: 2})
+		m.Start()
+		m.Stdin <- 'a'
+		m.Stdin <- '\n'
+		if err := m.Run(1000); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if i == 0 && m.IsRunning() {
+			t.Fatalf("%d: program doesn't stop at the end of input", i)
+		}
+		if d, _ := m.Datapath(); d.Cycles != m.Cycles() {
+			t.Errorf("%d: expected %d microcycles, got %d", i, m.Cycles(), d.Cycles)
+		}
+	}
+}
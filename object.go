@@ -0,0 +1,138 @@
+package lc3
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// magic string at the beginning of relocatable object files
+const objectMagic = "LC3RELOC1\n"
+
+// Section is a continuous block of words produced by the assembler
+type Section struct {
+	// address of the first word. relocatable sections start at 0 until they are linked
+	Origin Word
+	// relocatable section can be placed anywhere by the linker. others stay at Origin
+	Relocatable bool
+	Words       []Word
+}
+
+// ObjectSymbol is a label defined in an object
+type ObjectSymbol struct {
+	Section int
+	// address as if the section was placed at its Origin
+	Address Word
+	// global symbols are visible to other objects
+	Global bool
+}
+
+type RelocationKind int
+
+const (
+	RelocPCOffset9  RelocationKind = iota // bits [8:0] are an offset from incremented PC
+	RelocPCOffset11                       // bits [10:0] are an offset from incremented PC. JSR
+	RelocAbsolute                         // whole word is an absolute address. .FILL
+)
+
+func (k RelocationKind) String() string {
+	switch k {
+	case RelocPCOffset9:
+		return "PCoffset9"
+	case RelocPCOffset11:
+		return "PCoffset11"
+	case RelocAbsolute:
+		return "absolute address"
+	}
+	return "unknown"
+}
+
+// Relocation is a field the linker should patch when it knows where Symbol is placed
+type Relocation struct {
+	Section int
+	// address of the word to patch as if the section was placed at its Origin
+	Address Word
+	Kind    RelocationKind
+	Symbol  string
+}
+
+// ObjectLine is a SourceLine of an object section
+type ObjectLine struct {
+	Section int
+	SourceLine
+}
+
+// Object is an assembled but not yet linked program
+type Object struct {
+	// used in linker diagnostics
+	Name        string
+	Sections    []Section
+	Symbols     map[string]ObjectSymbol
+	Externals   []string
+	Relocations []Relocation
+	Lines       []ObjectLine
+}
+
+// ParseObject assembles a relocatable object read from reader.
+// code before the first .ORIG is placed by the linker, labels declared by .EXTERNAL come from other objects
+// and labels declared by .GLOBAL are visible to them
+func (a *Assembler) ParseObject(name string, reader io.Reader) (*Object, error) {
+	lines, err := a.parse(name, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err = a.expandLines(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := assembleObject(lines, true)
+	if err != nil {
+		return nil, err
+	}
+	obj.Name = name
+
+	return obj, nil
+}
+
+// ParseObjectFile assembles relocatable object from source file name
+func (a *Assembler) ParseObjectFile(name string) (*Object, error) {
+	file, err := a.fs().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return a.ParseObject(name, file)
+}
+
+// Write saves object so it can be linked later without its source
+func (o *Object) Write(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+	if _, err := w.WriteString(objectMagic); err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(w).Encode(o); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// ReadObject loads object saved by Object.Write
+func ReadObject(reader io.Reader) (*Object, error) {
+	r := bufio.NewReader(reader)
+
+	magic := make([]byte, len(objectMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != objectMagic {
+		return nil, errors.New("not a relocatable object")
+	}
+
+	ret := &Object{}
+	if err := gob.NewDecoder(r).Decode(ret); err != nil {
+		return nil, errors.Wrap(err, "cannot decode object")
+	}
+	return ret, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"io/fs"
 	"strconv"
 	"strings"
 
@@ -45,17 +46,34 @@ const (
 	stropPutsp = "PUTSP"
 	stropHalt  = "HALT"
 
-	stropEnd     = ".END"
-	stropFill    = ".FILL"
-	stropOrig    = ".ORIG"
-	stropStringZ = ".STRINGZ"
+	stropEnd      = ".END"
+	stropFill     = ".FILL"
+	stropOrig     = ".ORIG"
+	stropStringZ  = ".STRINGZ"
+	stropBlkw     = ".BLKW"
+	stropMacro    = ".MACRO"
+	stropEndm     = ".ENDM"
+	stropInclude  = ".INCLUDE"
+	stropExternal = ".EXTERNAL"
+	stropGlobal   = ".GLOBAL"
+	stropIf       = ".IF"
+	stropIfdef    = ".IFDEF"
+	stropIfndef   = ".IFNDEF"
+	stropElse     = ".ELSE"
+	stropEndif    = ".ENDIF"
 )
 
 var strOps = []string{stropBrn, stropBrz, stropBrp, stropBrzp, stropBrnp, stropBrnz, stropBrnzp,
 	stropAdd, stropLd, stropSt, stropJsr, stropJsrr, stropAnd, stropLdr, stropStr, stropRti,
 	stropNot, stropLdi, stropSti, stropJmp, stropRet, stropRes, stropLea, stropTrap,
 	stropGetc, stropOut, stropPuts, stropIn, stropPutsp, stropHalt,
-	stropEnd, stropFill, stropOrig, stropStringZ}
+	stropEnd, stropFill, stropOrig, stropStringZ, stropBlkw, stropMacro, stropEndm, stropInclude,
+	stropExternal, stropGlobal, stropIf, stropIfdef, stropIfndef, stropElse, stropEndif}
+
+// Mnemonics returns names of all instructions and directives in upper case
+func Mnemonics() []string {
+	return append([]string{}, strOps...)
+}
 
 const (
 	strReg0 = "R0"
@@ -70,37 +88,52 @@ const (
 
 var strRegs = []string{strReg0, strReg1, strReg2, strReg3, strReg4, strReg5, strReg6, strReg7}
 
+// Line is a source line: an optional label, an opcode, a directive or a macro call with operands
+// and an optional comment. names of labels and opcodes are in upper case
 type Line struct {
 	Label    string
 	Opcode   string
 	Operands []Operand
-	Comment  string
+	// text after the semicolon
+	Comment string
+
+	pos position
+	// line as written in the source. set by the parser
+	source string
+	// the line has a comment, maybe an empty one
+	hasComment bool
+	// columns of tokens. 0 if there is no such token
+	labelColumn   int
+	opcodeColumn  int
+	commentColumn int
+	// syntax error of the line
+	err error
 }
 
 func (l *Line) String() string {
+	return l.Format(sourceFormatter)
+}
+
+// Format prints line using f for registers and numbers
+func (l *Line) Format(f Formatter) string {
 	var buffer strings.Builder
 
 	putSpaceBeforeComment := false
 
 	if len(l.Label) > 0 {
-		buffer.WriteString(l.Label)
+		buffer.WriteString(l.label(f))
 		buffer.WriteByte(' ')
 		putSpaceBeforeComment = true
 	}
 
 	if len(l.Opcode) > 0 {
-		buffer.WriteString(l.Opcode)
+		buffer.WriteString(f.mnemonic(l.Opcode))
 		putSpaceBeforeComment = true
 	}
 
-	var operands []string
-	for _, operand := range l.Operands {
-		operands = append(operands, operand.String())
-	}
-
-	if len(operands) > 0 {
+	if len(l.Operands) > 0 {
 		buffer.WriteByte(' ')
-		buffer.WriteString(strings.Join(operands, ", "))
+		buffer.WriteString(l.operands(f))
 	}
 
 	if len(l.Comment) > 0 {
@@ -113,30 +146,166 @@ func (l *Line) String() string {
 	return buffer.String()
 }
 
-// operand can be one of: label, register, string, number
+// label as it is printed by f
+func (l *Line) label(f Formatter) string {
+	source := strings.TrimLeft(l.source, " \t")
+	if f.Source && len(source) >= len(l.Label) && strings.EqualFold(source[:len(l.Label)], l.Label) {
+		return source[:len(l.Label)]
+	}
+	return l.Label
+}
+
+// position of a line in the source file.
+// lines produced by a macro call keep the position inside the macro body and remember the call site
+type position struct {
+	file      string
+	line      int
+	expansion *expansion
+}
+
+// macro call that produced a line
+type expansion struct {
+	macro string
+	call  position
+}
+
+func (p position) String() string {
+	ret := currentPosition(p.file, p.line)
+	if p.file == "" {
+		ret = "line " + ret
+	}
+	if p.expansion != nil {
+		ret += fmt.Sprintf(" in macro %s, expanded at %s", p.expansion.macro, p.expansion.call)
+	}
+	return ret
+}
+
+// position of the outermost macro call that produced the line
+func (p position) root() position {
+	for p.expansion != nil {
+		p = p.expansion.call
+	}
+	return p
+}
+
+// errorf returns Error at the line
+func (p position) errorf(format string, args ...interface{}) error {
+	return p.at(0, fmt.Sprintf(format, args...))
+}
+
+// wrap returns message of err as Error at the line. errors which already have positions are returned as is
+func (p position) wrap(err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return p.at(0, err.Error())
+}
+
+// Error at column of the line. zero column is unknown
+func (p position) at(column int, message string) *Error {
+	ret := &Error{Pos: Position{File: p.file, Line: p.line, Column: column}, Msg: message}
+	for e := p.expansion; e != nil; e = e.call.expansion {
+		ret.Calls = append(ret.Calls, MacroCall{Macro: e.macro, Pos: Position{File: e.call.file, Line: e.call.line}})
+	}
+	return ret
+}
+
+// Error is an assembler error at a source line. lines produced by macros tell where the macros were called
+type Error struct {
+	Pos Position
+	Msg string
+	// calls of macros which produced the line, the innermost first
+	Calls []MacroCall
+}
+
+// MacroCall is a call of macro which produced a line
+type MacroCall struct {
+	Macro string
+	Pos   Position
+}
+
+// Error formats error as "message at file:line[:column]" followed by " in macro M, expanded at file:line"
+// for every macro call. positions in files without names are "line N"
+func (e *Error) Error() string {
+	ret := e.Msg + " at " + e.Pos.location()
+	if trace := e.Trace(); trace != "" {
+		ret += " " + trace
+	}
+	return ret
+}
+
+// Trace formats macro calls as "in macro M, expanded at file:line". empty if the line is not produced by macros
+func (e *Error) Trace() string {
+	var calls []string
+	for _, call := range e.Calls {
+		calls = append(calls, fmt.Sprintf("in macro %s, expanded at %s", call.Macro, call.Pos.location()))
+	}
+	return strings.Join(calls, " ")
+}
+
+// format line number for error messages
+func currentPosition(file string, lineno int) string {
+	if file == "" {
+		return strconv.Itoa(lineno)
+	}
+	return fmt.Sprintf("%s:%d", file, lineno)
+}
+
+// Operand is one of: label, register, string, number, expression. see Kind
 type Operand struct {
-	register *string
-	number   *Word
-	string   *string
-	label    *string
+	register   *string
+	number     *Word
+	string     *string
+	label      *string
+	expression *string // condition of .IF
+	// operand as written in the source. empty for operands made by the assembler
+	text string
+	pos  Position
 }
 
-func (o *Operand) isRegister() bool { return o.register != nil }
-func (o *Operand) isNumber() bool   { return o.number != nil }
-func (o *Operand) isString() bool   { return o.string != nil }
-func (o *Operand) isLabel() bool    { return o.label != nil }
+func (o *Operand) isRegister() bool   { return o.register != nil }
+func (o *Operand) isNumber() bool     { return o.number != nil }
+func (o *Operand) isString() bool     { return o.string != nil }
+func (o *Operand) isLabel() bool      { return o.label != nil }
+func (o *Operand) isExpression() bool { return o.expression != nil }
 func (o *Operand) String() string {
+	return o.Format(sourceFormatter)
+}
+
+// Format prints operand using f for registers and numbers. numbers are printed as signed immediates
+func (o *Operand) Format(f Formatter) string {
+	return o.format(f, true)
+}
+
+// print operand. unsigned numbers, e.g. addresses, are printed with minus sign only if they were written with it
+func (o *Operand) format(f Formatter, signed bool) string {
+	if o.isExpression() {
+		return *o.expression
+	}
 	if o.isRegister() {
-		return *o.register
+		return f.register(Word((*o.register)[1] - '0'))
 	}
 	if o.isString() {
+		if f.Source && o.text != "" {
+			return o.text
+		}
 		return strconv.Quote(*o.string)
 	}
 	if o.isLabel() {
+		if f.Source && strings.EqualFold(o.text, *o.label) {
+			return o.text
+		}
 		return *o.label
 	}
 	if o.isNumber() {
-		return fmt.Sprintf("x%X", *o.number)
+		if f.SourceBase && o.text != "" {
+			f.Mode = ModeHex
+			if o.text[0] == '#' {
+				f.Mode = ModeDec
+			}
+			f.Signed = strings.Contains(o.text, "-")
+		}
+		return f.literal(*o.number, signed || strings.Contains(o.text, "-"))
 	}
 
 	return ""
@@ -171,6 +340,8 @@ func parseIdentifier(line string, pos int) (string, int) {
 
 // if error is not nil, second return value should point to error position
 func parseOperand(line string, pos int) (Operand, int, error) {
+	start := pos
+
 	// string
 	if line[pos] == '"' {
 		var buffer strings.Builder
@@ -205,13 +376,16 @@ func parseOperand(line string, pos int) (Operand, int, error) {
 		pos = len(parsed)
 
 		ret := buffer.String()
-		return Operand{string: &ret}, pos, nil
+		return Operand{string: &ret, text: line[start:pos]}, pos, nil
 	}
 
 	// labels, registers as numbers initially could be parsed as identifiers
 	identifier, newPos := parseIdentifier(line, pos)
+	if identifier == "" {
+		return Operand{}, pos, errors.New("operand expected")
+	}
 	if isRegister(identifier) {
-		return Operand{register: &identifier}, newPos, nil
+		return Operand{register: &identifier, text: line[start:newPos]}, newPos, nil
 	}
 
 	base := 0
@@ -225,15 +399,19 @@ func parseOperand(line string, pos int) (Operand, int, error) {
 		base = 16
 	} else {
 		// label
-		return Operand{label: &identifier}, newPos, nil
+		return Operand{label: &identifier, text: line[start:newPos]}, newPos, nil
 	}
 
 	identifier = identifier[1:]
 
-	if identifier[0] == '-' {
+	if strings.HasPrefix(identifier, "-") {
 		neg = -1
 		identifier = identifier[1:]
 	}
+	// #, x or #- of a half typed number
+	if identifier == "" {
+		return Operand{}, pos, errors.Errorf("digits expected in %s", line[start:newPos])
+	}
 
 	// continue parse as number
 	number, err := strconv.ParseUint(identifier, base, 16)
@@ -245,7 +423,33 @@ func parseOperand(line string, pos int) (Operand, int, error) {
 	// strconv.ParseInt checks bit length
 	word := Word(int(number) * neg)
 
-	return Operand{number: &word}, newPos, nil
+	return Operand{number: &word, text: line[start:newPos]}, newPos, nil
+}
+
+// ParseNumber parses number written outside of a source as N, #N, xN or 0xN, e.g. in command line flags.
+// negative numbers are in two's complement
+func ParseNumber(text string) (Word, error) {
+	n, err := ParseInt(text)
+	return Word(n), err
+}
+
+// ParseInt parses number like ParseNumber keeping its sign: -1 is -1 and xFFFF is 65535
+func ParseInt(text string) (int, error) {
+	base, digits, sign := 10, strings.TrimPrefix(text, "#"), int64(1)
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = -1, digits[1:]
+	}
+	switch {
+	case strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X"):
+		base, digits = 16, digits[2:]
+	case strings.HasPrefix(digits, "x") || strings.HasPrefix(digits, "X"):
+		base, digits = 16, digits[1:]
+	}
+	n, err := strconv.ParseInt(digits, base, 32)
+	if err != nil || n > WordMax {
+		return 0, errors.Errorf("bad number %s", text)
+	}
+	return int(sign * n), nil
 }
 
 // check fif given identifier if opcode
@@ -267,19 +471,22 @@ func isRegister(identifier string) bool {
 	return false
 }
 
-func parseInput(reader io.Reader) ([]Line, error) {
-	var lines []Line
+// parse source file into syntax tree. name is used in positions and to resolve .INCLUDE relative to the file.
+// files included by the source are read for names of macros they define, so macro calls can be told from labels.
+// lines that can't be parsed keep the error, so it is reported in the order of assembly.
+// lines after .END are kept as they are if they can't be parsed
+func (p *parser) parseSource(name string, reader io.Reader) (*File, error) {
+	file := &File{Name: name}
 	var err error
 
-	const (
-		ParseLabelAndOpcode = iota
-		ParseOpcode
-		ParseOperands
-	)
+	isOpcodeOrMacro := func(identifier string) bool {
+		return isOpcode(identifier) || p.macros[identifier]
+	}
 
 	r := bufio.NewReader(reader)
 	lineno := 0
 	done := false
+	ended := false
 	for done == false {
 		var line string
 		lineno++
@@ -288,89 +495,177 @@ func parseInput(reader io.Reader) ([]Line, error) {
 			if err == io.EOF {
 				done = true
 			} else {
-				return nil, errors.Wrapf(err, "line %d: cannot read line", lineno)
+				return nil, errors.Wrapf(err, "%s: cannot read line", currentPosition(name, lineno))
 			}
 		}
 
 		line = strings.TrimSuffix(line, "\n")
 		line = strings.TrimSuffix(line, "\r")
 
-		currentLine := Line{}
+		currentLine, err := p.parseLine(name, lineno, line, isOpcodeOrMacro)
+		if err != nil {
+			currentLine = Line{pos: position{file: name, line: lineno}, source: line}
+			// text after .END is ignored by the assembler
+			if !ended {
+				currentLine.err = err
+			}
+		}
 
-		state := ParseLabelAndOpcode
+		p.learnMacros(name, currentLine)
 
-	ParseLine:
-		for i := 0; i < len(line); {
-			// skip spaces
-			i = eatSpaces(line, i)
+		file.Lines = append(file.Lines, currentLine)
+		if currentLine.Opcode == stropEnd {
+			ended = true
+		}
+	}
 
-			// empty line or no characters left
-			// this check guarantee that we have an input
-			if len(line[i:]) == 0 {
-				break
-			}
+	return file, nil
+}
 
-			// parse comment
-			if line[i] == ';' {
-				// save comment if any
-				if len(line) > i {
-					currentLine.Comment = line[i+1:]
-				}
-				// finish line processing
-				i = len(line)
-				continue
-			}
+// parse one line of source file
+func (p *parser) parseLine(name string, lineno int, line string, isOpcodeOrMacro func(string) bool) (Line, error) {
+	const (
+		ParseLabelAndOpcode = iota
+		ParseOpcode
+		ParseOperands
+	)
 
-			switch state {
-			case ParseLabelAndOpcode:
-				var identifier string
-				identifier, i = parseIdentifier(line, i)
+	currentLine := Line{pos: position{file: name, line: lineno}, source: line}
 
-				if len(identifier) == 0 {
-					return nil, errors.Errorf("label or opcode expected at %d:%d", lineno, i)
-				}
+	state := ParseLabelAndOpcode
 
-				// no label on this line
-				if isOpcode(identifier) {
-					currentLine.Opcode = identifier
-					state = ParseOperands
-					continue ParseLine
-				}
+ParseLine:
+	for i := 0; i < len(line); {
+		// skip spaces
+		i = eatSpaces(line, i)
 
-				currentLine.Label = identifier
-				state = ParseOpcode
-				continue ParseLine
+		// empty line or no characters left
+		// this check guarantee that we have an input
+		if len(line[i:]) == 0 {
+			break
+		}
 
-			case ParseOpcode:
-				identifier, tmpPos := parseIdentifier(line, i)
-				if !isOpcode(identifier) {
-					return nil, errors.Errorf("opcode expected at %d:%d", lineno, i)
-				}
-				i = tmpPos
+		// parse comment
+		if line[i] == ';' {
+			// save comment if any
+			if len(line) > i {
+				currentLine.Comment = line[i+1:]
+			}
+			currentLine.hasComment = true
+			currentLine.commentColumn = i + 1
+			// finish line processing
+			i = len(line)
+			continue
+		}
+
+		switch state {
+		case ParseLabelAndOpcode:
+			var identifier string
+			start := i
+			identifier, i = parseIdentifier(line, i)
 
+			if len(identifier) == 0 {
+				return Line{}, currentLine.pos.at(i+1, "label or opcode expected")
+			}
+
+			// no label on this line
+			if isOpcodeOrMacro(identifier) {
 				currentLine.Opcode = identifier
+				currentLine.opcodeColumn = start + 1
 				state = ParseOperands
 				continue ParseLine
+			}
 
-			case ParseOperands:
-				operand, tmpPos, err := parseOperand(line, i)
-				if err != nil {
-					return nil, errors.Errorf("%s at %d:%d", err.Error(), lineno, tmpPos)
-				}
-				i = tmpPos
-				currentLine.Operands = append(currentLine.Operands, operand)
+			currentLine.Label = identifier
+			currentLine.labelColumn = start + 1
+			state = ParseOpcode
+			continue ParseLine
 
-				// eat spaces and comma
-				i = eatSpaces(line, i)
-				if i < len(line) && line[i] == ',' {
-					i++
+		case ParseOpcode:
+			identifier, tmpPos := parseIdentifier(line, i)
+			if !isOpcodeOrMacro(identifier) {
+				return Line{}, currentLine.pos.at(i+1, "opcode expected")
+			}
+			currentLine.opcodeColumn = i + 1
+			i = tmpPos
+
+			currentLine.Opcode = identifier
+			state = ParseOperands
+			continue ParseLine
+
+		case ParseOperands:
+			// the rest of .IF line up to a comment is an expression
+			if currentLine.Opcode == stropIf {
+				end := strings.IndexByte(line[i:], ';')
+				if end < 0 {
+					end = len(line[i:])
 				}
-
-				// do not change state, parse comment or operand again
+				expression := strings.TrimSpace(line[i : i+end])
+				currentLine.Operands = append(currentLine.Operands, Operand{
+					expression: &expression,
+					text:       expression,
+					pos:        currentLine.position(i + 1),
+				})
+				i += end
 				continue ParseLine
 			}
-			panic("unreachable")
-			//return nil, errors.Errorf("unknown input at %d: %s", lineno, line[i:])
+
+			operand, tmpPos, err := parseOperand(line, i)
+			if err != nil {
+				return Line{}, currentLine.pos.at(tmpPos+1, err.Error())
+			}
+			operand.pos = currentLine.position(i + 1)
+			i = tmpPos
+			currentLine.Operands = append(currentLine.Operands, operand)
+
+			// eat spaces and comma
+			i = eatSpaces(line, i)
+			if i < len(line) && line[i] == ',' {
+				i++
+			}
+
+			// do not change state, parse comment or operand again
+			continue ParseLine
+		}
+		panic("unreachable")
+		//return nil, errors.Errorf("unknown input at %d: %s", lineno, line[i:])
+	}
+
+	return currentLine, nil
+}
+
+// apply conditional assembly and includes to a parsed file. returns lines to be assembled
+func (p *parser) preprocess(file *File) ([]Line, error) {
+	var lines []Line
+
+	// conditions opened in this file must be closed in it
+	base := p.conditionsBase
+	p.conditionsBase = len(p.conditions)
+	defer func() { p.conditionsBase = base }()
+
+	for _, currentLine := range file.Lines {
+		if currentLine.err != nil {
+			return nil, currentLine.err
+		}
+		active, err := p.conditional(currentLine)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			continue
+		}
+		if currentLine.Opcode == stropInclude {
+			included, err := p.include(file.Name, currentLine)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, included...)
+			continue
+		}
+
+		// .END of an included file ends only that file
+		if currentLine.Opcode == stropEnd && len(p.files) > 1 {
+			break
 		}
 
 		lines = append(lines, currentLine)
@@ -379,18 +674,21 @@ func parseInput(reader io.Reader) ([]Line, error) {
 		}
 	}
 
+	if len(p.conditions) > p.conditionsBase {
+		last := p.conditions[len(p.conditions)-1]
+		return nil, last.pos.errorf("unterminated %s", last.opcode)
+	}
+
 	return lines, nil
 }
 
+// ParseAssembly assembles a program read from reader.
+// .INCLUDE files are looked up in the current directory
 func ParseAssembly(reader io.Reader) (*VM, error) {
-	lines, err := parseInput(reader)
-	if err != nil {
-		return nil, err
-	}
+	return (&Assembler{}).Parse("", reader)
+}
 
-	m, err := assembleVM(lines)
-	//if err == nil {
-	//	m.Dump(true)
-	//}
-	return m, err
+// ParseAssemblyFS assembles file name and files it includes reading them from fsys
+func ParseAssemblyFS(fsys fs.FS, name string) (*VM, error) {
+	return (&Assembler{FS: fsys}).ParseFile(name)
 }
@@ -0,0 +1,207 @@
+package lc3
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Symbol is a label and its address in a linked program
+type Symbol struct {
+	Name    string
+	Address Word
+}
+
+// SourceLine tells which source line produced words of a program
+type SourceLine struct {
+	Address Word
+	// number of words produced by the line
+	Size Word
+	// lines produced by macros refer to the macro call
+	File string
+	Line int
+	// false for words of data directives
+	Code bool
+	// words reserved by .BLKW have no initial value
+	Reserved bool
+	Text     string
+}
+
+// Program is a linked memory image ready to be loaded into a VM
+type Program struct {
+	// address of the first instruction
+	Entry    Word
+	Sections []Section
+	// sorted by address
+	Symbols []Symbol
+	// sorted by address
+	Lines []SourceLine
+}
+
+// Symbol returns address of label name
+func (p *Program) Symbol(name string) (Word, bool) {
+	for _, symbol := range p.Symbols {
+		if symbol.Name == name {
+			return symbol.Address, true
+		}
+	}
+	return 0, false
+}
+
+// Line returns the source line which produced word at address. nil program has no lines
+func (p *Program) Line(address Word) (SourceLine, bool) {
+	if p == nil {
+		return SourceLine{}, false
+	}
+	for _, line := range p.Lines {
+		if line.Address <= address && int(address) < int(line.Address)+int(line.Size) {
+			return line, true
+		}
+	}
+	return SourceLine{}, false
+}
+
+// Location returns the nearest label at or before address with offset, e.g. LOOP+2. nil program has no labels
+func (p *Program) Location(address Word) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	i := sort.Search(len(p.Symbols), func(i int) bool { return p.Symbols[i].Address > address })
+	if i == 0 {
+		return "", false
+	}
+	symbol := p.Symbols[i-1]
+	if symbol.Address == address {
+		return symbol.Name, true
+	}
+	return fmt.Sprintf("%s+%d", symbol.Name, address-symbol.Address), true
+}
+
+// Diagnostic is a message about an instruction of a running program
+type Diagnostic struct {
+	// address of the instruction
+	PC Word
+	// the nearest label at or before the instruction with offset, e.g. LOOP+2. empty if there are no labels before it
+	Location string
+	// source line of the instruction. zero if unknown
+	Line    SourceLine
+	Message string
+}
+
+// String formats diagnostic as file:line: LOCATION (xPC): message
+func (d Diagnostic) String() string {
+	ret := ""
+	if d.Line.File != "" {
+		ret = fmt.Sprintf("%s:%d: ", d.Line.File, d.Line.Line)
+	}
+	if d.Location != "" {
+		ret += d.Location + " "
+	}
+	return ret + fmt.Sprintf("(x%04X): %s", d.PC, d.Message)
+}
+
+// Diagnostics reports every instruction once for every kind of diagnostics. the zero value is ready to use
+type Diagnostics struct {
+	reported map[diagnosticKey]bool
+}
+
+type diagnosticKey struct {
+	kind int
+	pc   Word
+}
+
+// Report describes instruction at pc of program, which can be nil. false is returned if the instruction
+// is already reported with kind
+func (d *Diagnostics) Report(program *Program, kind int, pc Word, message string) (Diagnostic, bool) {
+	key := diagnosticKey{kind, pc}
+	if d.reported[key] {
+		return Diagnostic{}, false
+	}
+	if d.reported == nil {
+		d.reported = make(map[diagnosticKey]bool)
+	}
+	d.reported[key] = true
+	location, _ := program.Location(pc)
+	line, _ := program.Line(pc)
+	return Diagnostic{PC: pc, Location: location, Line: line, Message: message}, true
+}
+
+// Load writes program into memory and sets PC to its entry point
+func (p *Program) Load(m *VM) {
+	for _, section := range p.Sections {
+		for i, word := range section.Words {
+			m.WriteMem(section.Origin+Word(i), word)
+		}
+	}
+	m.SetOrigin(p.Entry)
+}
+
+// NewVM creates VM with full memory and loads program into it
+func (p *Program) NewVM() *VM {
+	ret := NewVM(WordMax + 1)
+	p.Load(ret)
+	return ret
+}
+
+// memory image from the first section to the end of the last one. gaps between sections are zeros
+func (p *Program) image() (Word, []Word) {
+	if len(p.Sections) == 0 {
+		return p.Entry, nil
+	}
+
+	origin := p.Sections[0].Origin
+	var image []Word
+	for _, section := range p.Sections {
+		offset := int(section.Origin - origin)
+		for len(image) < offset+len(section.Words) {
+			image = append(image, 0)
+		}
+		copy(image[offset:], section.Words)
+	}
+	return origin, image
+}
+
+// WriteObj writes program in the standard .obj format: big endian origin followed by words.
+// gaps between sections are filled with zeros
+func (p *Program) WriteObj(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+
+	origin, image := p.image()
+	if err := binary.Write(w, binary.BigEndian, origin); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, image); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// ReadObj reads program in the standard .obj format
+func ReadObj(reader io.Reader) (*Program, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || len(data)%2 != 0 {
+		return nil, errors.New("malformed object file")
+	}
+
+	origin := Word(binary.BigEndian.Uint16(data))
+	words := make([]Word, 0, len(data)/2-1)
+	for i := 2; i < len(data); i += 2 {
+		words = append(words, Word(binary.BigEndian.Uint16(data[i:])))
+	}
+	if int(origin)+len(words) > WordMax+1 {
+		return nil, errors.New("object file does not fit into memory")
+	}
+
+	ret := &Program{Entry: origin}
+	if len(words) > 0 {
+		ret.Sections = []Section{{Origin: origin, Words: words}}
+	}
+	return ret, nil
+}
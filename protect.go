@@ -0,0 +1,83 @@
+package lc3
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CodeWriteAction tells what the VM does when an instruction writes into protected code
+type CodeWriteAction int
+
+const (
+	// the write is done and recorded
+	CodeWriteReport CodeWriteAction = iota
+	// the write is done and recorded, Step returns ErrCodeWritten after the instruction like at a watchpoint.
+	// the next Step continues execution
+	CodeWriteStop
+	// the write isn't done, Step returns ErrWriteProtected and PC stays at the instruction
+	CodeWriteException
+)
+
+// ErrCodeWritten is returned by Step after an instruction wrote into protected code with CodeWriteStop
+var ErrCodeWritten = errors.New("code is overwritten")
+
+// ErrWriteProtected is returned by Step for an instruction writing into protected code with CodeWriteException
+var ErrWriteProtected = errors.New("write into protected code")
+
+// CodeWrite is a write into protected code
+type CodeWrite struct {
+	// address of the writing instruction
+	PC      Word
+	Address Word
+	// word at the address before the write and the written one
+	Old Word
+	New Word
+}
+
+// String formats write as xPC writes xADDRESS: old instruction -> new instruction
+func (w CodeWrite) String() string {
+	return fmt.Sprintf("x%04X writes x%04X: %s -> %s", w.PC, w.Address,
+		EncodeInstructionAt(w.Address, w.Old), EncodeInstructionAt(w.Address, w.New))
+}
+
+// ProtectCode marks size words starting at address as code. writes into them by instructions
+// are recorded and handled according to CodeWriteAction. WriteMem isn't checked
+func (m *VM) ProtectCode(address Word, size Word) {
+	if m.protected == nil {
+		m.protected = make([]bool, WordMax+1)
+	}
+	for i := Word(0); i < size; i++ {
+		m.protected[address+i] = true
+	}
+}
+
+// CodeWrites returns writes into protected code in order they happened
+func (m *VM) CodeWrites() []CodeWrite {
+	return m.codeWrites
+}
+
+// check write of instruction into protected code. false if the write must not be done
+func (m *VM) checkCodeWrite(address Word, value Word) bool {
+	if m.protected == nil || !m.protected[address] {
+		return true
+	}
+	m.codeWrites = append(m.codeWrites, CodeWrite{PC: m.registers[RegPC] - 1, Address: address, Old: m.PeekMem(address), New: value})
+	switch m.CodeWriteAction {
+	case CodeWriteStop:
+		m.codeWriteErr = ErrCodeWritten
+	case CodeWriteException:
+		m.codeWriteErr = ErrWriteProtected
+		return false
+	}
+	return true
+}
+
+// ProtectCode marks words produced by instructions of the program as code in m.
+// programs read from .obj files have no source lines, nothing is protected for them
+func (p *Program) ProtectCode(m *VM) {
+	for _, line := range p.Lines {
+		if line.Code {
+			m.ProtectCode(line.Address, line.Size)
+		}
+	}
+}
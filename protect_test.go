@@ -0,0 +1,65 @@
+package lc3
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ProtectCode(t *testing.T) {
+	program, err := (&Assembler{}).Assemble("main.asm", strings.NewReader(`
+			.orig x3000
+					ld r0, nop
+					st r0, target
+					add r1, r1, #1
+			target	add r1, r1, #1
+					halt
+			nop		.fill x0000
+			.end`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		action CodeWriteAction
+		// error of the storing instruction and PC after it
+		err error
+		pc  Word
+		r1  Word
+	}
+
+	testData := []testCase{
+		{CodeWriteReport, nil, 0x3002, 1},
+		{CodeWriteStop, ErrCodeWritten, 0x3002, 1},
+		{CodeWriteException, ErrWriteProtected, 0x3001, 2},
+	}
+
+	for i, test := range testData {
+		m := program.NewVM()
+		m.CodeWriteAction = test.action
+		program.ProtectCode(m)
+		m.Start()
+
+		m.Step()
+		if err := m.Step(); err != test.err {
+			t.Errorf("%d: expected error %v, got %v", i, test.err, err)
+		}
+		if pc := m.GetRegister(RegPC); pc != test.pc {
+			t.Errorf("%d: expected PC x%04X, got x%04X", i, test.pc, pc)
+		}
+
+		writes := m.CodeWrites()
+		if len(writes) != 1 || writes[0].String() != "x3001 writes x3003: ADD R1, R1, x1 -> NOP" {
+			t.Errorf("%d: expected write of NOP into x3003, got %v", i, writes)
+		}
+
+		if test.action == CodeWriteException {
+			// skip the store
+			m.SetRegister(RegPC, 0x3002)
+		}
+		for m.Step() == nil {
+		}
+		if m.GetRegister(RegR1) != test.r1 {
+			t.Errorf("%d: expected r1 = %d, got %d", i, test.r1, m.GetRegister(RegR1))
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package shadow finds uses of uninitialised memory at runtime. The VM fills memory with zeros,
+// so a program reading a .BLKW word it never wrote works by accident.
+//
+// The checker keeps shadow memory telling which words are initialised: words of loaded images,
+// except ones reserved by .BLKW, words written by stores and memory mapped device registers.
+// It reports reads of other words, execution of them and execution of words produced
+// by data directives. Violations are collected while the program runs with Checker.Step
+// instead of VM.Step.
+package shadow
+
+import (
+	"fmt"
+
+	"github.com/pavel-krush/lc3"
+)
+
+// Kind of a violation
+type Kind int
+
+const (
+	// an instruction or a trap reads a word which is never written
+	UninitializedRead Kind = iota
+	// PC points to a word which is never written
+	UninitializedExecution
+	// PC points to a word of .FILL, .STRINGZ or .BLKW
+	DataExecution
+)
+
+func (k Kind) String() string {
+	switch k {
+	case UninitializedRead:
+		return "uninitialised read"
+	case UninitializedExecution:
+		return "uninitialised execution"
+	case DataExecution:
+		return "data execution"
+	}
+	return "unknown"
+}
+
+// the first address of memory mapped device registers. they are always initialised
+const deviceRegisters = 0xFE00
+
+// Violation made by an instruction. String formats it as file:line: LOCATION (xPC): message
+type Violation struct {
+	lc3.Diagnostic
+	Kind Kind
+	// address of the uninitialised word. PC for execution
+	Address lc3.Word
+}
+
+// Checker runs VM and records uses of uninitialised memory
+type Checker struct {
+	VM      *lc3.VM
+	Program *lc3.Program
+
+	initialized []bool
+	// words of data directives not overwritten since loading
+	data        []bool
+	violations  []Violation
+	diagnostics lc3.Diagnostics
+}
+
+// New checks vm running program. words of program are initialised, program is also used
+// for labels and source lines of violations
+func New(vm *lc3.VM, program *lc3.Program) *Checker {
+	ret := &Checker{
+		VM:          vm,
+		Program:     program,
+		initialized: make([]bool, lc3.WordMax+1),
+		data:        make([]bool, lc3.WordMax+1),
+	}
+	for address := deviceRegisters; address <= lc3.WordMax; address++ {
+		ret.initialized[address] = true
+	}
+	if program != nil {
+		ret.Add(program)
+	}
+	vm.AddObserver(ret)
+	return ret
+}
+
+// Add marks words of another image loaded into the VM, e.g. an operating system, as initialised
+func (c *Checker) Add(program *lc3.Program) {
+	for _, section := range program.Sections {
+		for i := range section.Words {
+			c.initialized[section.Origin+lc3.Word(i)] = true
+		}
+	}
+	for _, line := range program.Lines {
+		for i := lc3.Word(0); i < line.Size; i++ {
+			address := line.Address + i
+			c.data[address] = !line.Code
+			if line.Reserved {
+				c.initialized[address] = false
+			}
+		}
+	}
+}
+
+// Violations returns violations in order they happened. every instruction is reported once for every kind
+func (c *Checker) Violations() []Violation {
+	return c.violations
+}
+
+// Step checks the instruction at PC and executes it
+func (c *Checker) Step() error {
+	c.Fetch()
+	return c.VM.Step()
+}
+
+// Fetch checks the word at PC before it is executed. Step calls it, it is called directly
+// when the VM is stepped by another checker
+func (c *Checker) Fetch() {
+	if !c.VM.IsRunning() {
+		return
+	}
+	pc := c.VM.GetRegister(lc3.RegPC)
+	if !c.initialized[pc] {
+		c.report(UninitializedExecution, pc, pc, fmt.Sprintf("execution of uninitialised word x%04X", pc))
+	} else if c.data[pc] {
+		c.report(DataExecution, pc, pc, fmt.Sprintf("execution of data word x%04X", pc))
+	}
+}
+
+// Load is called during execution, when PC already points to the next instruction
+func (c *Checker) Load(address lc3.Word, value lc3.Word) {
+	if c.initialized[address] {
+		return
+	}
+	pc := c.VM.GetRegister(lc3.RegPC) - 1
+	target := fmt.Sprintf("x%04X", address)
+	if location, ok := c.Program.Location(address); ok {
+		target += " (" + location + ")"
+	}
+	c.report(UninitializedRead, pc, address, "read of uninitialised "+target)
+}
+
+func (c *Checker) Store(address lc3.Word, value lc3.Word) {
+	c.initialized[address] = true
+	c.data[address] = false
+}
+
+func (c *Checker) report(kind Kind, pc lc3.Word, address lc3.Word, message string) {
+	if d, ok := c.diagnostics.Report(c.Program, int(kind), pc, message); ok {
+		c.violations = append(c.violations, Violation{Diagnostic: d, Kind: kind, Address: address})
+	}
+}
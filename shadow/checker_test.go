@@ -0,0 +1,103 @@
+package shadow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pavel-krush/lc3"
+)
+
+const testProgram = `	.orig x3000
+main	ld r0, count
+	ld r1, buffer
+	st r0, buffer
+	ld r1, buffer
+	ldi r2, ptr
+	jsr table
+	ld r3, retop
+	sti r3, slotptr
+	jsr slot
+	brnzp spare
+count	.fill #5
+ptr	.fill rest
+retop	.fill xC1C0
+slotptr	.fill slot
+table	.fill xC1C0
+buffer	.blkw #1
+rest	.blkw #1
+slot	.blkw #1
+spare	.blkw #1
+	halt
+	.end
+`
+
+func assemble(t *testing.T, name string, code string) *lc3.Program {
+	program, err := (&lc3.Assembler{}).Assemble(name, strings.NewReader(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// run at most 100 instructions, zeros of uninitialised memory are executed forever
+func run(t *testing.T, c *Checker) {
+	c.VM.Start()
+	for i := 0; i < 100 && c.VM.IsRunning(); i++ {
+		if err := c.Step(); err != nil && err != lc3.ErrNotRunning {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkViolations(t *testing.T, c *Checker, expected []string) {
+	violations := c.Violations()
+	if len(violations) != len(expected) {
+		t.Errorf("expected %d violations, got %d: %v", len(expected), len(violations), violations)
+		return
+	}
+	for i := range expected {
+		if violations[i].String() != expected[i] {
+			t.Errorf("%d: expected %q, got %q", i, expected[i], violations[i].String())
+		}
+	}
+}
+
+func Test_Checker(t *testing.T) {
+	program := assemble(t, "main.asm", testProgram)
+	c := New(program.NewVM(), program)
+	run(t, c)
+
+	checkViolations(t, c, []string{
+		"main.asm:3: MAIN+1 (x3001): read of uninitialised x300F (BUFFER)",
+		"main.asm:6: MAIN+4 (x3004): read of uninitialised x3010 (REST)",
+		"main.asm:16: TABLE (x300E): execution of data word x300E",
+		"main.asm:20: SPARE (x3012): execution of uninitialised word x3012",
+	})
+	if v := c.Violations()[1]; v.Kind != UninitializedRead || v.PC != 0x3004 || v.Address != 0x3010 {
+		t.Errorf("expected uninitialised read of x3010 at x3004, got %+v", v)
+	}
+}
+
+func Test_Add(t *testing.T) {
+	const code = ".orig x3000\ntrap x30\nhalt\n"
+
+	program := assemble(t, "main.asm", code)
+	c := New(program.NewVM(), program)
+	run(t, c)
+	violations := c.Violations()
+	if len(violations) < 2 || violations[0].String() != "main.asm:2: (x3000): read of uninitialised x0030" ||
+		violations[1].Kind != UninitializedExecution || violations[1].PC != 0 {
+		t.Errorf("expected read of the trap vector and execution of x0000, got %v", violations)
+	}
+
+	// the vector points to RET of the system image
+	system := assemble(t, "os.asm", ".orig x0030\n.fill x0031\nret\n")
+	program = assemble(t, "main.asm", code)
+	vm := program.NewVM()
+	system.Load(vm)
+	program.Load(vm)
+	c = New(vm, program)
+	c.Add(system)
+	run(t, c)
+	checkViolations(t, c, nil)
+}
@@ -0,0 +1,92 @@
+package lc3
+
+// Timing is a model of clock cycles taken by instructions on the LC-3 microarchitecture.
+// an instruction takes a cycle for every state of the control unit it passes, see the state diagram
+// in appendix C of Patt and Patel, and a latency for every memory access including its fetch.
+// TRAP without SystemTraps is charged for the instruction and memory read by the built-in routine
+type Timing struct {
+	// cycles of an access to memory
+	MemoryLatency uint
+	// cycles of an access to memory mapped device registers
+	DeviceLatency uint
+}
+
+// DefaultTiming is a memory taking 5 cycles per access and devices answering in a cycle
+var DefaultTiming = Timing{MemoryLatency: 5, DeviceLatency: 1}
+
+// states of instruction fetch without the memory access: MAR <- PC, IR <- MDR and decode
+const fetchStates = 3
+
+// states of executing instruction without memory accesses. cond is the condition register before it
+func executeStates(instruction Word, cond Word) uint {
+	switch getOpcode(instruction) {
+	case OpBr:
+		// taken branch passes the state loading PC
+		if getNBits(instruction, 9, 3)&cond != 0 {
+			return 2
+		}
+		return 1
+	case OpJsr, OpLd, OpLdr, OpSt, OpStr, OpTrap:
+		return 2
+	case OpLdi, OpSti:
+		return 3
+	}
+	return 1
+}
+
+// cycles of accessing address
+func (t *Timing) latency(address Word) uint {
+	if address >= MrKbsr {
+		return t.DeviceLatency
+	}
+	return t.MemoryLatency
+}
+
+// timing model of a VM. observes memory accesses of the current instruction
+type timing struct {
+	Timing
+	// cycles of memory accesses by the current instruction
+	accesses uint
+}
+
+func (t *timing) Load(address Word, value Word) {
+	t.accesses += t.latency(address)
+}
+
+func (t *timing) Store(address Word, value Word) {
+	t.accesses += t.latency(address)
+}
+
+// SetTiming starts counting cycles of executed instructions with t. nil stops counting.
+// Run of EngineBlocks executes instructions one by one while cycles are counted
+func (m *VM) SetTiming(t *Timing) {
+	if m.timing != nil {
+		m.RemoveObserver(m.timing)
+		m.timing = nil
+	}
+	if t != nil {
+		m.timing = &timing{Timing: *t}
+		m.AddObserver(m.timing)
+	}
+}
+
+// Cycles returns cycles taken by instructions executed since reset. zero if cycles aren't counted
+func (m *VM) Cycles() uint {
+	return m.cycles
+}
+
+// Step counting cycles of the instruction. an instruction which isn't executed takes no cycles
+func (m *VM) stepTimed() error {
+	t := m.timing
+	pc := m.registers[RegPC]
+	instruction := m.PeekMem(pc)
+	cond := m.registers[RegCond]
+	executed := m.instructionsExecuted
+
+	t.accesses = 0
+	err := m.execute()
+	if m.instructionsExecuted != executed {
+		m.cycles += fetchStates + t.latency(pc) + executeStates(instruction, cond) + t.accesses
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package lc3
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Timing(t *testing.T) {
+	type testCase struct {
+		code string
+		// cycles of the code without HALT
+		cycles uint
+	}
+
+	// a memory access takes 5 cycles, a device register access 1. every instruction is fetched for 3+5 cycles
+	testData := []testCase{
+		{"add r0, r0, #1", 9},
+		{"ld r0, data", 15},
+		{"ldi r0, pointer", 21},
+		{"sti r0, pointer", 21},
+		{"ldi r0, kbsr", 17},
+		{"sti r0, ddr", 17},
+		{"ldr r0, r0, #0", 15},
+		{"str r0, r0, #0", 15},
+		{"lea r0, data", 9},
+		{"brz skip\nskip", 9},
+		{"and r0, r0, #0\nbrz skip\nskip", 9 + 10},
+		{"jsr skip\nskip", 10},
+		// the built-in routine reads the string
+		{"lea r0, data\nputs", 9 + 10 + 2*5},
+	}
+
+	for i, test := range testData {
+		program, err := (&Assembler{}).Assemble("main.asm", strings.NewReader(`
+			.orig x3000
+			`+test.code+`
+					halt
+			data	.fill x0041
+					.fill x0000
+			pointer	.fill data
+			kbsr	.fill xFE00
+			ddr		.fill xFE06
+			.end`))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		for _, engine := range []Engine{EngineInterpreter, EngineDecodeCache, EngineBlocks, EngineMicro} {
+			m := program.NewVMWithEngine(engine)
+			m.Console = &testConsole{}
+			m.SetTiming(&DefaultTiming)
+			m.Start()
+			if err := m.Run(100); err != nil {
+				t.Fatalf("%d: %s: %v", i, engine, err)
+			}
+			if m.IsRunning() {
+				t.Fatalf("%d: %s: program doesn't halt", i, engine)
+			}
+			// HALT takes 3+5+2 cycles
+			if cycles := m.Cycles() - 10; cycles != test.cycles {
+				t.Errorf("%d: %s: expected %d cycles, got %d", i, engine, test.cycles, cycles)
+			}
+		}
+	}
+}
+
+func Test_TimingLatency(t *testing.T) {
+	program, err := (&Assembler{}).Assemble("main.asm", strings.NewReader(`
+			.orig x3000
+					getc
+					ldi r1, kbsr
+					halt
+			kbsr	.fill xFE00
+			.end`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := program.NewVM()
+	console := &testConsole{}
+	m.Console = console
+	m.SetTiming(&Timing{MemoryLatency: 10, DeviceLatency: 2})
+	m.Start()
+
+	// waiting for input takes no cycles
+	if err := m.Step(); err != ErrWaitingForInput {
+		t.Fatalf("expected %v, got %v", ErrWaitingForInput, err)
+	}
+	if m.Cycles() != 0 {
+		t.Errorf("expected no cycles, got %d", m.Cycles())
+	}
+
+	console.input = "a"
+	m.Step()
+	if m.Cycles() != 3+10+2 {
+		t.Errorf("expected %d cycles of GETC, got %d", 3+10+2, m.Cycles())
+	}
+	m.Step()
+	if m.Cycles() != 15+3+10+3+10+2 {
+		t.Errorf("expected %d cycles after LDI, got %d", 15+3+10+3+10+2, m.Cycles())
+	}
+
+	m.SetTiming(nil)
+	m.Step()
+	if m.Cycles() != 43 {
+		t.Errorf("expected cycles not counted after SetTiming(nil), got %d", m.Cycles())
+	}
+	m.Reset()
+	if m.Cycles() != 0 {
+		t.Errorf("expected cycles cleared by Reset, got %d", m.Cycles())
+	}
+}
@@ -2,11 +2,18 @@ package lc3
 
 import (
 	"fmt"
+	"sort"
+
 	"github.com/pkg/errors"
-	"math"
 )
 
-type labelRegistry map[string]Word
+type labelRegistry map[string]label
+
+// label address and index of the section it was defined in
+type label struct {
+	section int
+	address Word
+}
 
 type OperandType int
 
@@ -15,13 +22,14 @@ const (
 	Immediate
 	Offset
 	String
+	Address // absolute address of a label or a number
 )
 
 type InstructionSignature struct {
 	opcode          string
 	operands        []OperandType
 	builderFunction interface{}
-	writerFunction  func(pass int, labels labelRegistry, m *VM, currentAddress Word, signature InstructionSignature, line Line) (Word, error)
+	writerFunction  func(a *assembly, currentAddress Word, signature InstructionSignature, line Line) (Word, error)
 }
 
 var signatures = []InstructionSignature{
@@ -43,6 +51,7 @@ var signatures = []InstructionSignature{
 	{stropAnd, []OperandType{Register, Register, Register}, NewAndRegister, simpleWriterFunction},
 	{stropLdr, []OperandType{Register, Register, Offset}, NewLdr, simpleWriterFunction},
 	{stropStr, []OperandType{Register, Register, Offset}, NewStr, simpleWriterFunction},
+	{stropRti, []OperandType{}, NewRti, simpleWriterFunction},
 	{stropNot, []OperandType{Register, Register}, NewNot, simpleWriterFunction},
 	{stropLdi, []OperandType{Register, Offset}, NewLdi, simpleWriterFunction},
 	{stropSti, []OperandType{Register, Offset}, NewSti, simpleWriterFunction},
@@ -57,9 +66,12 @@ var signatures = []InstructionSignature{
 	{stropHalt, []OperandType{}, NewHalt, simpleWriterFunction},
 	{stropTrap, []OperandType{Offset}, NewTrap, simpleWriterFunction},
 	{stropFill, []OperandType{Immediate}, nil, rawWriterFunction},
-	{stropFill, []OperandType{Offset}, nil, rawWriterFunction},
+	{stropFill, []OperandType{Address}, nil, rawWriterFunction},
 	{stropOrig, []OperandType{Immediate}, nil, originWriterFunction},
 	{stropStringZ, []OperandType{String}, nil, rawWriterFunction},
+	{stropBlkw, []OperandType{Immediate}, nil, rawWriterFunction},
+	{stropExternal, []OperandType{Address}, nil, symbolWriterFunction},
+	{stropGlobal, []OperandType{Address}, nil, symbolWriterFunction},
 }
 
 const (
@@ -67,18 +79,23 @@ const (
 	pass2 = 2
 )
 
-func (lr labelRegistry) setLabelOffset(label string, offset Word) {
-	lr[label] = offset
+func (lr labelRegistry) setLabelOffset(name string, section int, offset Word) {
+	lr[name] = label{section: section, address: offset}
 }
 
-func (lr labelRegistry) getLabelOffset(label string) (Word, error) {
-	value, ok := lr[label]
+func (lr labelRegistry) getLabel(name string) (label, error) {
+	value, ok := lr[name]
 	if !ok {
-		return value, fmt.Errorf("unknown label %s", label)
+		return value, fmt.Errorf("unknown label %s", name)
 	}
 	return value, nil
 }
 
+func (lr labelRegistry) getLabelOffset(name string) (Word, error) {
+	value, err := lr.getLabel(name)
+	return value.address, err
+}
+
 func makeRegisterFromString(register string) Word {
 	mapping := map[string]Word{
 		strReg0: RegR0,
@@ -96,48 +113,141 @@ func makeRegisterFromString(register string) Word {
 	panic("unknown register " + register)
 }
 
-func resolveLabelOrImmediate(currentAddress Word, labels labelRegistry, opType OperandType, operand Operand) (Word, error) {
-	var value Word
-	var err error
-
-	if opType == Immediate {
-		if operand.isNumber() {
-			// leave number as number
-			value = *operand.number
-		} else if operand.isLabel() {
-			// absolute address
-			value, err = labels.getLabelOffset(*operand.label)
-			if err != nil {
-				return 0, err
-			}
-		}
-	} else if opType == Offset {
-		// calculate relative offset for label
-		if operand.isLabel() {
-			value, err = labels.getLabelOffset(*operand.label)
-			if err != nil {
-				return 0, err
-			}
-			// relative to incremented PC
-			value = value - (currentAddress + 1)
-		} else if operand.isNumber() {
-			value = *operand.number
-		} else {
-			panic(operand)
+// state of a single assembly: labels, emitted sections and symbols left for the linker
+type assembly struct {
+	pass   int
+	labels labelRegistry
+	// code before the first .ORIG is relocatable and can be placed anywhere by the linker
+	relocatable bool
+
+	sections    []Section
+	externals   map[string]bool
+	globals     map[string]position
+	relocations []Relocation
+	lines       []ObjectLine
+}
+
+// index of the section words are written to
+func (a *assembly) section() int {
+	if len(a.sections) == 0 {
+		a.sections = append(a.sections, Section{Relocatable: a.relocatable})
+	}
+	return len(a.sections) - 1
+}
+
+// only the implicit first section can be relocatable
+func (a *assembly) isRelocatable(section int) bool {
+	return section == 0 && a.sections[0].Relocatable
+}
+
+// define label of line at address of the current section
+func (a *assembly) defineLabel(line Line, address Word) error {
+	if a.externals[line.Label] {
+		return line.pos.errorf("external label %s is defined", line.Label)
+	}
+	// labels of pass 1 are redefined with the same addresses in pass 2
+	if _, ok := a.labels[line.Label]; ok && a.pass == pass1 {
+		return line.pos.errorf("label %s already defined", line.Label)
+	}
+	a.labels.setLabelOffset(line.Label, a.section(), address)
+	return nil
+}
+
+func (a *assembly) startSection(origin Word) {
+	a.sections = append(a.sections, Section{Origin: origin})
+}
+
+func (a *assembly) write(address Word, value Word) {
+	section := &a.sections[a.section()]
+	offset := int(address - section.Origin)
+	for len(section.Words) <= offset {
+		section.Words = append(section.Words, 0)
+	}
+	section.Words[offset] = value
+}
+
+// remember source line of words written to the current section
+func (a *assembly) addLine(address Word, size Word, line Line) {
+	pos := line.pos.root()
+	a.lines = append(a.lines, ObjectLine{
+		Section: a.section(),
+		SourceLine: SourceLine{
+			Address:  address,
+			Size:     size,
+			File:     pos.file,
+			Line:     pos.line,
+			Code:     line.Opcode != stropFill && line.Opcode != stropStringZ && line.Opcode != stropBlkw,
+			Reserved: line.Opcode == stropBlkw,
+			Text:     line.String(),
+		},
+	})
+}
+
+// kind of PC-relative offset of instruction. false if the offset can't be relocated
+func relocationKind(opcode string) (RelocationKind, bool) {
+	switch opcode {
+	case stropLdr, stropStr, stropTrap:
+		return 0, false
+	case stropJsr:
+		return RelocPCOffset11, true
+	}
+	return RelocPCOffset9, true
+}
+
+func (a *assembly) resolveLabelOrImmediate(currentAddress Word, opType OperandType, operand Operand, line Line) (Word, error) {
+	if operand.isNumber() {
+		// leave number as number
+		return *operand.number, nil
+	}
+
+	if !operand.isLabel() {
+		panic(operand)
+	}
+
+	name := *operand.label
+
+	var target label
+	if !a.externals[name] {
+		var err error
+		target, err = a.labels.getLabel(name)
+		if err != nil {
+			return 0, err
 		}
+	} else if !a.relocatable {
+		return 0, errors.Errorf("external label %s can't be resolved without linking", name)
+	}
 
-	} else {
-		panic(opType)
+	current := a.section()
+
+	switch opType {
+	case Immediate, Address:
+		// absolute address. known now only for labels of fixed sections
+		if !a.externals[name] && !a.isRelocatable(target.section) {
+			return target.address, nil
+		}
+		a.relocations = append(a.relocations, Relocation{Section: current, Address: currentAddress, Kind: RelocAbsolute, Symbol: name})
+		return 0, nil
+	case Offset:
+		// relative to incremented PC. known now if both addresses are in the same or in fixed sections
+		if !a.externals[name] && (target.section == current || !a.isRelocatable(target.section) && !a.isRelocatable(current)) {
+			return target.address - (currentAddress + 1), nil
+		}
+		kind, ok := relocationKind(line.Opcode)
+		if !ok {
+			return 0, errors.Errorf("label %s can't be relocated in %s", name, line.Opcode)
+		}
+		a.relocations = append(a.relocations, Relocation{Section: current, Address: currentAddress, Kind: kind, Symbol: name})
+		return 0, nil
 	}
 
-	return value, nil
+	panic(opType)
 }
 
 // write instruction to vm's memory
 // all instructions except .ORIG, .STRINGZ are handled by this functions
-func simpleWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
+func simpleWriterFunction(a *assembly, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
 	// nothing to do on the first pass
-	if pass != pass2 {
+	if a.pass != pass2 {
 		return currentAddress + 1, nil
 	}
 
@@ -147,7 +257,7 @@ func simpleWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress
 		if line.Operands[i].isRegister() {
 			args[i] = makeRegisterFromString(*line.Operands[i].register)
 		} else if line.Operands[i].isLabel() || line.Operands[i].isNumber() {
-			arg, err := resolveLabelOrImmediate(currentAddress, labels, signature.operands[i], line.Operands[i])
+			arg, err := a.resolveLabelOrImmediate(currentAddress, signature.operands[i], line.Operands[i], line)
 			if err != nil {
 				return currentAddress, err
 			}
@@ -161,7 +271,6 @@ func simpleWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress
 	}
 
 	var instruction Word
-	var err error
 	if len(signature.operands) == 0 {
 		instruction = signature.builderFunction.(func() Word)()
 	} else if len(signature.operands) == 1 {
@@ -171,25 +280,22 @@ func simpleWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress
 	} else if len(signature.operands) == 3 {
 		instruction = signature.builderFunction.(func(Word, Word, Word) Word)(args[0], args[1], args[2])
 	}
-	if err != nil {
-		return currentAddress, err
-	}
-	m.WriteMem(currentAddress, instruction)
+	a.write(currentAddress, instruction)
 
 	return currentAddress + 1, nil
 }
 
-func originWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
+func originWriterFunction(a *assembly, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
 	if !line.Operands[0].isNumber() {
 		return currentAddress, errors.Errorf("number expected for .ORIG")
 	}
-	m.SetOrigin(*line.Operands[0].number)
+	a.startSection(*line.Operands[0].number)
 	// .origin resets currentAddress to origin's's absolute value
 	return *line.Operands[0].number, nil
 }
 
-func brWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
-	if pass != pass2 {
+func brWriterFunction(a *assembly, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
+	if a.pass != pass2 {
 		return currentAddress + 1, nil
 	}
 	var flags Word
@@ -214,7 +320,7 @@ func brWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress Word
 		return currentAddress, errors.Errorf("label or immediate number expected")
 	}
 
-	value, err := resolveLabelOrImmediate(currentAddress, labels, signature.operands[0], line.Operands[0])
+	value, err := a.resolveLabelOrImmediate(currentAddress, signature.operands[0], line.Operands[0], line)
 	if err != nil {
 		return currentAddress, err
 	}
@@ -224,12 +330,12 @@ func brWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress Word
 	}
 
 	instruction := NewBR(flags, value)
-	m.WriteMem(currentAddress, instruction)
+	a.write(currentAddress, instruction)
 	return currentAddress + 1, nil
 }
 
-// write raw value. handler for .STRINGZ and .FILL
-func rawWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
+// write raw value. handler for .STRINGZ, .BLKW and .FILL
+func rawWriterFunction(a *assembly, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
 	var advancement Word = 0
 
 	if signature.opcode == stropStringZ {
@@ -240,42 +346,75 @@ func rawWriterFunction(pass int, labels labelRegistry, m *VM, currentAddress Wor
 		str := *line.Operands[0].string
 
 		for i := 0; i < len(str); i++ {
-			m.WriteMem(currentAddress+advancement, Word(str[i]))
+			a.write(currentAddress+advancement, Word(str[i]))
 			advancement++
 		}
 
 		// termination zero
-		m.WriteMem(currentAddress+advancement, 0)
+		a.write(currentAddress+advancement, 0)
 		advancement++
 
 		return currentAddress + advancement, nil
+	} else if signature.opcode == stropBlkw {
+		size := *line.Operands[0].number
+		if size == 0 || IsNegative(size) {
+			return currentAddress, errors.Errorf("positive number of words expected for .BLKW")
+		}
+		// reserved words are zeros in the image
+		for ; advancement < size; advancement++ {
+			a.write(currentAddress+advancement, 0)
+		}
 	} else if signature.opcode == stropFill {
-		if pass != pass2 {
+		if a.pass != pass2 {
 			return currentAddress + 1, nil
 		}
-		value, err := resolveLabelOrImmediate(currentAddress, labels, signature.operands[0], line.Operands[0])
+		value, err := a.resolveLabelOrImmediate(currentAddress, signature.operands[0], line.Operands[0], line)
 		if err != nil {
 			return currentAddress, err
 		}
-		m.WriteMem(currentAddress, value)
+		a.write(currentAddress, value)
 		advancement++
 	}
 
 	return currentAddress + advancement, nil
 }
 
-func assembleVM(lines []Line) (*VM, error) {
-	ret := NewVM(math.MaxUint16 + 1)
-	labels := make(labelRegistry)
+// handler for .EXTERNAL and .GLOBAL. they declare symbols for the linker and take no space
+func symbolWriterFunction(a *assembly, currentAddress Word, signature InstructionSignature, line Line) (Word, error) {
+	if !line.Operands[0].isLabel() {
+		return currentAddress, errors.Errorf("label expected for %s", line.Opcode)
+	}
+	name := *line.Operands[0].label
+
+	if signature.opcode == stropExternal {
+		a.externals[name] = true
+	} else {
+		a.globals[name] = line.pos
+	}
+
+	return currentAddress, nil
+}
+
+// translate lines into sections of an object.
+// relocatable objects keep code before the first .ORIG movable and may refer to external labels
+func assembleObject(lines []Line, relocatable bool) (*Object, error) {
+	a := &assembly{
+		labels:      make(labelRegistry),
+		relocatable: relocatable,
+		externals:   make(map[string]bool),
+		globals:     make(map[string]position),
+	}
 
-	for pass := pass1; pass <= pass2; pass++ {
+	for a.pass = pass1; a.pass <= pass2; a.pass++ {
 		var currentAddress Word = 0
-		for lineno, line := range lines {
-			// save label position
-			//fmt.Printf("pass %d line %d\n", pass, lineno)
-			if line.Label != "" {
-				if line.Label != "" {
-					labels.setLabelOffset(line.Label, currentAddress)
+		a.sections = nil
+		a.relocations = nil
+		a.lines = nil
+		for _, line := range lines {
+			// save label position. a label on the .ORIG line names the origin of the new section
+			if line.Label != "" && line.Opcode != stropOrig {
+				if err := a.defineLabel(line, currentAddress); err != nil {
+					return nil, err
 				}
 			}
 
@@ -309,7 +448,7 @@ func assembleVM(lines []Line) (*VM, error) {
 						operandsMatch = false
 					}
 
-					if signature.operands[i] == Offset && !(lineOp.isNumber() || lineOp.isLabel()) {
+					if (signature.operands[i] == Offset || signature.operands[i] == Address) && !(lineOp.isNumber() || lineOp.isLabel()) {
 						operandsMatch = false
 					}
 				}
@@ -320,20 +459,47 @@ func assembleVM(lines []Line) (*VM, error) {
 				break
 			}
 			if !foundSignature {
-				return nil, errors.Errorf("unknown opcode signature at line %d", lineno+1)
+				return nil, line.pos.errorf("unknown opcode signature")
 			}
 
-			var err error
-			currentAddress, err = signature.writerFunction(pass, labels, ret, currentAddress, signature, line)
+			nextAddress, err := signature.writerFunction(a, currentAddress, signature, line)
 			if err != nil {
-				return nil, errors.Errorf("%s at line %d", err.Error(), lineno+1)
+				return nil, line.pos.wrap(err)
+			}
+			if line.Label != "" && line.Opcode == stropOrig {
+				if err := a.defineLabel(line, nextAddress); err != nil {
+					return nil, err
+				}
+			}
+			if a.pass == pass2 && line.Opcode != stropOrig && nextAddress != currentAddress {
+				a.addLine(currentAddress, nextAddress-currentAddress, line)
 			}
+			currentAddress = nextAddress
 		}
 	}
 
-	//for label, address := range labels {
-	//	fmt.Printf("%04X %6d %s\n", address, address, label)
-	//}
+	ret := &Object{
+		Sections:    a.sections,
+		Symbols:     make(map[string]ObjectSymbol),
+		Relocations: a.relocations,
+		Lines:       a.lines,
+	}
+
+	for name, l := range a.labels {
+		_, global := a.globals[name]
+		ret.Symbols[name] = ObjectSymbol{Section: l.section, Address: l.address, Global: global}
+	}
+
+	for name, pos := range a.globals {
+		if _, ok := a.labels[name]; !ok {
+			return nil, pos.errorf("global label %s is not defined", name)
+		}
+	}
+
+	for name := range a.externals {
+		ret.Externals = append(ret.Externals, name)
+	}
+	sort.Strings(ret.Externals)
 
 	return ret, nil
 }